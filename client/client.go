@@ -0,0 +1,685 @@
+// Package client is a Go client for the raftdemojson key-value REST API.
+//
+// A Client is created with the http addresses of some or all of the nodes
+// of a cluster. Writes are sent to the leader, which is discovered through
+// the /servers endpoint and cached. When the cached leader stops being the
+// leader, the redirect returned by the node is followed and remembered.
+// Operations are retried with an exponential backoff while the cluster has
+// no leader. Deletes and membership changes are not retried after a network
+// error once they may have reached a node, as they may have been applied.
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	ErrKeyNotFound    = errors.New("key not found")
+	ErrLeaderNotFound = errors.New("leader not found")
+	ErrNoEndpoints    = errors.New("no endpoints")
+)
+
+// Messages sent by a node when the cluster has no leader, when the node is
+// being drained and when its rate limit is exceeded
+const (
+	leaderNotFoundMessage  = "Leader not found"
+	drainingMessage        = "Node is draining"
+	tooManyRequestsMessage = "Too many requests"
+)
+
+// StatusError is returned when a node fails a request with a status
+// that is not retried
+type StatusError struct {
+	StatusCode int
+	Message    string
+}
+
+func (err *StatusError) Error() string {
+	if err.Message == "" {
+		return fmt.Sprintf("request failed with status %d", err.StatusCode)
+	}
+	return fmt.Sprintf("request failed with status %d: %s", err.StatusCode, err.Message)
+}
+
+// Server is a member of the cluster as reported by /servers
+type Server struct {
+	Address     string
+	Id          string
+	Leader      bool
+	HttpAddress string
+	Suffrage    string
+}
+
+type keyValue struct {
+	Key   string `json:"key"`
+	Value string `json:"value"`
+}
+
+type requestData struct {
+	Data []keyValue `json:"data"`
+}
+
+type requestKeys struct {
+	Keys []string `json:"keys"`
+}
+
+type requestScan struct {
+	Prefix string `json:"prefix"`
+	Limit  int    `json:"limit"`
+}
+
+type requestServer struct {
+	ID          string `json:"id"`
+	Address     string `json:"address,omitempty"`
+	HttpAddress string `json:"httpaddress,omitempty"`
+}
+
+// JoinRequest asks the cluster to add a node
+type JoinRequest struct {
+	ID          string `json:"id"`
+	Address     string `json:"address"`
+	HttpAddress string `json:"httpaddress,omitempty"`
+
+	// Join as a nonvoter
+	Nonvoter bool `json:"nonvoter,omitempty"`
+
+	// Cluster the joining node has state from, if any
+	ClusterID string `json:"clusterid,omitempty"`
+}
+
+// ImportProgress tells how far an import went
+type ImportProgress struct {
+	// Records of the stream committed, counting the skipped ones. A
+	// failed import is resumed by skipping them.
+	Committed int64 `json:"committed"`
+
+	// Key-values added by the import and RAFT entries used for them
+	Added   int64 `json:"added"`
+	Entries int64 `json:"entries"`
+}
+
+// ImportOptions of Import
+type ImportOptions struct {
+	// Records to skip, the Committed count of a failed import
+	Skip int64
+
+	// Key-values per RAFT entry, the node's default if 0
+	Batch int
+}
+
+type response struct {
+	Status     string            `json:"status"`
+	Message    string            `json:"message,omitempty"`
+	DeleteKeys []string          `json:"deleted,omitempty"`
+	NotFound   []string          `json:"notfound,omitempty"`
+	FoundKeys  map[string]string `json:"found,omitempty"`
+	Servers    []Server          `json:"servers,omitempty"`
+	ClusterID  string            `json:"clusterid,omitempty"`
+	Import     *ImportProgress   `json:"import,omitempty"`
+}
+
+// Config for a Client. Zero values are replaced with defaults.
+type Config struct {
+	// Http addresses of the nodes, either host:port or a http URL
+	Endpoints []string
+
+	// Client used for the requests. Redirects are handled by the
+	// Client itself.
+	HttpClient *http.Client
+
+	// Number of times an operation is retried
+	MaxRetries int
+
+	// Initial delay between retries, doubled on every retry
+	Backoff time.Duration
+
+	// Upper bound for the delay between retries
+	MaxBackoff time.Duration
+}
+
+// Client talks to a raftdemojson cluster. It is safe for concurrent use.
+type Client struct {
+	config     Config
+	httpclient *http.Client
+
+	lock sync.Mutex
+	// Base URL of the last known leader
+	leader string
+	// Next endpoint to try when the leader is not needed
+	next int
+}
+
+// New creates a Client with the default configuration
+func New(endpoints []string) (*Client, error) {
+	return NewWithConfig(Config{Endpoints: endpoints})
+}
+
+// NewWithConfig creates a Client from config
+func NewWithConfig(config Config) (*Client, error) {
+	if len(config.Endpoints) == 0 {
+		return nil, ErrNoEndpoints
+	}
+	var endpoints []string
+	for _, endpoint := range config.Endpoints {
+		endpoints = append(endpoints, baseURL(endpoint))
+	}
+	config.Endpoints = endpoints
+	if config.MaxRetries <= 0 {
+		config.MaxRetries = 5
+	}
+	if config.Backoff <= 0 {
+		config.Backoff = 100 * time.Millisecond
+	}
+	if config.MaxBackoff <= 0 {
+		config.MaxBackoff = 2 * time.Second
+	}
+	httpclient := http.Client{Timeout: 60 * time.Second}
+	if config.HttpClient != nil {
+		httpclient = *config.HttpClient
+	}
+	httpclient.CheckRedirect = func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}
+	return &Client{config: config, httpclient: &httpclient}, nil
+}
+
+// Put adds or replaces a key
+func (c *Client) Put(ctx context.Context, key, value string) error {
+	return c.PutAll(ctx, map[string]string{key: value})
+}
+
+// PutAll adds or replaces all the keys in kvs
+func (c *Client) PutAll(ctx context.Context, kvs map[string]string) error {
+	var req requestData
+	for key, value := range kvs {
+		req.Data = append(req.Data, keyValue{Key: key, Value: value})
+	}
+	code, resp, err := c.do(ctx, http.MethodPost, "/keyvals", req, true, true)
+	if err != nil {
+		return err
+	}
+	if code != http.StatusOK {
+		return &StatusError{StatusCode: code, Message: resp.Message}
+	}
+	return nil
+}
+
+// Get returns the value of key or ErrKeyNotFound
+func (c *Client) Get(ctx context.Context, key string) (string, error) {
+	found, _, err := c.GetAll(ctx, []string{key})
+	if err != nil {
+		return "", err
+	}
+	value, ok := found[key]
+	if !ok {
+		return "", ErrKeyNotFound
+	}
+	return value, nil
+}
+
+// GetAll returns the values of the keys that exist and the list of keys
+// that were not found
+func (c *Client) GetAll(ctx context.Context, keys []string) (map[string]string, []string, error) {
+	code, resp, err := c.do(ctx, http.MethodGet, "/getkeys", requestKeys{Keys: keys}, false, true)
+	if err != nil {
+		return nil, nil, err
+	}
+	if code != http.StatusOK && code != http.StatusNotFound {
+		return nil, nil, &StatusError{StatusCode: code, Message: resp.Message}
+	}
+	if resp.FoundKeys == nil {
+		resp.FoundKeys = make(map[string]string)
+	}
+	return resp.FoundKeys, resp.NotFound, nil
+}
+
+// Scan returns the keys starting with prefix along with their values. At
+// most limit keys, the first ones in sorted order, are returned unless
+// limit is 0.
+func (c *Client) Scan(ctx context.Context, prefix string, limit int) (map[string]string, error) {
+	code, resp, err := c.do(ctx, http.MethodGet, "/scan", requestScan{Prefix: prefix, Limit: limit}, false, true)
+	if err != nil {
+		return nil, err
+	}
+	if code != http.StatusOK {
+		return nil, &StatusError{StatusCode: code, Message: resp.Message}
+	}
+	if resp.FoundKeys == nil {
+		resp.FoundKeys = make(map[string]string)
+	}
+	return resp.FoundKeys, nil
+}
+
+// Delete deletes key. It returns ErrKeyNotFound if the key does not exist.
+func (c *Client) Delete(ctx context.Context, key string) error {
+	deleted, _, err := c.DeleteAll(ctx, []string{key})
+	if err != nil {
+		return err
+	}
+	if len(deleted) == 0 {
+		return ErrKeyNotFound
+	}
+	return nil
+}
+
+// DeleteAll deletes the keys and returns the keys that were deleted and
+// the keys that were not found
+func (c *Client) DeleteAll(ctx context.Context, keys []string) ([]string, []string, error) {
+	code, resp, err := c.do(ctx, http.MethodDelete, "/delete", requestKeys{Keys: keys}, true, false)
+	if err != nil {
+		return nil, nil, err
+	}
+	if code != http.StatusOK && code != http.StatusNotFound {
+		return nil, nil, &StatusError{StatusCode: code, Message: resp.Message}
+	}
+	return resp.DeleteKeys, resp.NotFound, nil
+}
+
+// Servers returns the members of the cluster
+func (c *Client) Servers(ctx context.Context) ([]Server, error) {
+	code, resp, err := c.do(ctx, http.MethodGet, "/servers", nil, false, true)
+	if err != nil {
+		return nil, err
+	}
+	if code != http.StatusOK {
+		return nil, &StatusError{StatusCode: code, Message: resp.Message}
+	}
+	return resp.Servers, nil
+}
+
+// Leader returns the current leader of the cluster
+func (c *Client) Leader(ctx context.Context) (Server, error) {
+	servers, err := c.Servers(ctx)
+	if err != nil {
+		return Server{}, err
+	}
+	for _, server := range servers {
+		if server.Leader {
+			return server, nil
+		}
+	}
+	return Server{}, ErrLeaderNotFound
+}
+
+// AddVoter adds a server to the cluster as a voter. address is the raft
+// address of the server and httpaddress its http listener.
+func (c *Client) AddVoter(ctx context.Context, id, address, httpaddress string) error {
+	return c.admin(ctx, "/admin/addvoter", requestServer{ID: id, Address: address,
+		HttpAddress: httpaddress})
+}
+
+// AddNonvoter adds a server to the cluster which does not vote
+func (c *Client) AddNonvoter(ctx context.Context, id, address, httpaddress string) error {
+	return c.admin(ctx, "/admin/addnonvoter", requestServer{ID: id, Address: address,
+		HttpAddress: httpaddress})
+}
+
+// DemoteVoter turns a voter into a nonvoter
+func (c *Client) DemoteVoter(ctx context.Context, id string) error {
+	return c.admin(ctx, "/admin/demotevoter", requestServer{ID: id})
+}
+
+// RemoveServer removes a server from the cluster
+func (c *Client) RemoveServer(ctx context.Context, id string) error {
+	return c.admin(ctx, "/admin/removeserver", requestServer{ID: id})
+}
+
+// Join adds the node described by req to the cluster and returns the id
+// of the cluster. A node with state from another cluster is refused with
+// a StatusError with status 409.
+func (c *Client) Join(ctx context.Context, req JoinRequest) (string, error) {
+	code, resp, err := c.do(ctx, http.MethodPost, "/admin/join", req, true, false)
+	if err != nil {
+		return "", err
+	}
+	if code != http.StatusOK {
+		return "", &StatusError{StatusCode: code, Message: resp.Message}
+	}
+	return resp.ClusterID, nil
+}
+
+// LeadershipTransfer asks the leader to hand the leadership over to the
+// server id, or to any up to date follower if id is empty
+func (c *Client) LeadershipTransfer(ctx context.Context, id string) error {
+	return c.admin(ctx, "/admin/leadershiptransfer", requestServer{ID: id})
+}
+
+// admin posts body to an admin endpoint of the leader
+func (c *Client) admin(ctx context.Context, path string, body interface{}) error {
+	code, resp, err := c.do(ctx, http.MethodPost, path, body, true, false)
+	if err != nil {
+		return err
+	}
+	if code != http.StatusOK {
+		return &StatusError{StatusCode: code, Message: resp.Message}
+	}
+	return nil
+}
+
+// Snapshot asks the leader to take a snapshot
+func (c *Client) Snapshot(ctx context.Context) error {
+	code, resp, err := c.do(ctx, http.MethodPost, "/testpersist", nil, true, true)
+	if err != nil {
+		return err
+	}
+	if code != http.StatusOK {
+		return &StatusError{StatusCode: code, Message: resp.Message}
+	}
+	return nil
+}
+
+// ExportSnapshot writes the newest snapshot of the leader to w, as the
+// node stores it, and returns the file name suggested by the node. With
+// fresh the leader takes a snapshot first. The snapshot is not retried
+// once it has started streaming.
+func (c *Client) ExportSnapshot(ctx context.Context, w io.Writer, fresh bool) (string, error) {
+	base, err := c.endpoint(ctx, true)
+	if err != nil {
+		return "", err
+	}
+	path := "/admin/snapshot"
+	if fresh {
+		path += "?fresh=true"
+	}
+	httpresp, err := c.stream(ctx, http.MethodGet, base, path, nil)
+	if err != nil {
+		return "", err
+	}
+	defer httpresp.Body.Close()
+	if _, err := io.Copy(w, httpresp.Body); err != nil {
+		return "", err
+	}
+	_, params, _ := mime.ParseMediaType(httpresp.Header.Get("Content-Disposition"))
+	return params["filename"], nil
+}
+
+// Import sends the key-values read from r, JSON Lines or a JSON array of
+// {"key": ..., "value": ...} records, to the leader, which adds them in
+// batches. progress is called, if not nil, after every committed batch.
+// The import is not retried, when it fails the returned progress tells how
+// many records to skip to resume it.
+func (c *Client) Import(ctx context.Context, r io.Reader, options ImportOptions,
+	progress func(ImportProgress)) (ImportProgress, error) {
+	status := ImportProgress{Committed: options.Skip}
+	base, err := c.endpoint(ctx, true)
+	if err != nil {
+		return status, err
+	}
+	query := url.Values{}
+	query.Set("skip", strconv.FormatInt(options.Skip, 10))
+	if options.Batch > 0 {
+		query.Set("batch", strconv.Itoa(options.Batch))
+	}
+	httpresp, err := c.stream(ctx, http.MethodPost, base, "/import?"+query.Encode(), r)
+	if err != nil {
+		return status, err
+	}
+	defer httpresp.Body.Close()
+	decoder := json.NewDecoder(httpresp.Body)
+	for {
+		var resp response
+		if err := decoder.Decode(&resp); err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return status, fmt.Errorf("import interrupted: %w", err)
+		}
+		if resp.Import != nil {
+			status = *resp.Import
+		}
+		switch resp.Status {
+		case "progress":
+			if progress != nil {
+				progress(status)
+			}
+		case "success":
+			return status, nil
+		default:
+			return status, fmt.Errorf("import failed after %d records: %s", status.Committed, resp.Message)
+		}
+	}
+}
+
+// Export writes the keys starting with prefix to w as JSON Lines, from a
+// consistent view of one of the nodes. The cached leader is used if there
+// is one, followers may be behind it.
+func (c *Client) Export(ctx context.Context, w io.Writer, prefix string) error {
+	base, err := c.endpoint(ctx, false)
+	if err != nil {
+		return err
+	}
+	httpresp, err := c.stream(ctx, http.MethodGet, base, "/export?prefix="+url.QueryEscape(prefix), nil)
+	if err != nil {
+		return err
+	}
+	defer httpresp.Body.Close()
+	_, err = io.Copy(w, httpresp.Body)
+	return err
+}
+
+// stream sends a request whose body or response is streamed, with no
+// timeout besides the one of ctx. Responses other than 200 are returned as
+// errors, a redirect to the leader is remembered for the next call.
+func (c *Client) stream(ctx context.Context, method, base, path string, body io.Reader) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, base+path, body)
+	if err != nil {
+		return nil, err
+	}
+	httpclient := *c.httpclient
+	httpclient.Timeout = 0
+	httpresp, err := httpclient.Do(req)
+	if err != nil {
+		c.forget(base)
+		return nil, err
+	}
+	if httpresp.StatusCode == http.StatusOK {
+		return httpresp, nil
+	}
+	defer httpresp.Body.Close()
+	if location := httpresp.Header.Get("Location"); location != "" {
+		c.setLeader(baseURL(location))
+	}
+	data, _ := io.ReadAll(httpresp.Body)
+	resp := &response{}
+	if json.Unmarshal(data, resp) != nil {
+		resp.Message = strings.TrimSpace(string(data))
+	}
+	return nil, &StatusError{StatusCode: httpresp.StatusCode, Message: resp.Message}
+}
+
+// do sends the request and retries it on network errors, leader changes
+// and while there is no leader. A request which is not idempotent is only
+// retried after a network error if it could not reach the node. The status
+// code and decoded body of the first response that is not retried are
+// returned.
+func (c *Client) do(ctx context.Context, method, path string, body interface{},
+	toLeader, idempotent bool) (int, *response, error) {
+	var payload []byte
+	if body != nil {
+		var err error
+		payload, err = json.Marshal(body)
+		if err != nil {
+			return 0, nil, err
+		}
+	}
+
+	backoff := c.config.Backoff
+	var lasterr error
+	for attempt := 0; attempt <= c.config.MaxRetries; attempt++ {
+		if attempt > 0 && lasterr != nil {
+			if err := sleep(ctx, backoff); err != nil {
+				return 0, nil, err
+			}
+			backoff = min(2*backoff, c.config.MaxBackoff)
+		}
+		base, err := c.endpoint(ctx, toLeader)
+		if err != nil {
+			lasterr = err
+			continue
+		}
+		code, resp, location, err := c.send(ctx, method, base+path, payload)
+		if err != nil {
+			if ctx.Err() != nil {
+				return 0, nil, ctx.Err()
+			}
+			c.forget(base)
+			if !idempotent && !notSent(err) {
+				return 0, nil, err
+			}
+			lasterr = err
+			continue
+		}
+		if location != "" && code >= 300 && code < 400 {
+			// A follower pointed us to the leader, retry right away
+			c.setLeader(baseURL(location))
+			lasterr = nil
+			continue
+		}
+		if resp.Message == leaderNotFoundMessage || resp.Message == drainingMessage {
+			// Wait for an election or for the leadership to be handed over
+			c.forget(base)
+			lasterr = ErrLeaderNotFound
+			continue
+		}
+		if code == http.StatusTooManyRequests && resp.Message == tooManyRequestsMessage {
+			// Back off and try the same node again
+			lasterr = &StatusError{StatusCode: code, Message: resp.Message}
+			continue
+		}
+		return code, resp, nil
+	}
+	if lasterr == nil {
+		lasterr = ErrLeaderNotFound
+	}
+	return 0, nil, lasterr
+}
+
+// notSent tells whether a request failed before reaching the node, when
+// the connection could not be made
+func notSent(err error) bool {
+	var operr *net.OpError
+	return errors.As(err, &operr) && operr.Op == "dial"
+}
+
+func (c *Client) send(ctx context.Context, method, url string,
+	payload []byte) (int, *response, string, error) {
+	var body io.Reader
+	if payload != nil {
+		body = bytes.NewReader(payload)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, url, body)
+	if err != nil {
+		return 0, nil, "", err
+	}
+	if payload != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	httpresp, err := c.httpclient.Do(req)
+	if err != nil {
+		return 0, nil, "", err
+	}
+	defer httpresp.Body.Close()
+	data, err := io.ReadAll(httpresp.Body)
+	if err != nil {
+		return 0, nil, "", err
+	}
+	resp := &response{}
+	if json.Unmarshal(data, resp) != nil {
+		resp.Message = strings.TrimSpace(string(data))
+	}
+	return httpresp.StatusCode, resp, httpresp.Header.Get("Location"), nil
+}
+
+// endpoint returns the base URL to send a request to. Requests for the
+// leader go to the cached leader, discovering it first if needed. Other
+// requests use the cached leader if there is one and otherwise rotate
+// through the configured endpoints.
+func (c *Client) endpoint(ctx context.Context, toLeader bool) (string, error) {
+	c.lock.Lock()
+	leader := c.leader
+	next := c.next
+	c.lock.Unlock()
+	if leader != "" {
+		return leader, nil
+	}
+	if !toLeader {
+		return c.config.Endpoints[next%len(c.config.Endpoints)], nil
+	}
+	return c.discoverLeader(ctx)
+}
+
+// discoverLeader asks the endpoints for the list of servers until one of
+// them knows the leader
+func (c *Client) discoverLeader(ctx context.Context) (string, error) {
+	var lasterr error = ErrLeaderNotFound
+	for _, endpoint := range c.config.Endpoints {
+		code, resp, _, err := c.send(ctx, http.MethodGet, endpoint+"/servers", nil)
+		if err != nil {
+			lasterr = err
+			continue
+		}
+		if code != http.StatusOK {
+			continue
+		}
+		for _, server := range resp.Servers {
+			if server.Leader && server.HttpAddress != "" {
+				leader := baseURL(server.HttpAddress)
+				c.setLeader(leader)
+				return leader, nil
+			}
+		}
+	}
+	return "", lasterr
+}
+
+func (c *Client) setLeader(leader string) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.leader = leader
+}
+
+// forget drops base as the cached leader and moves on to the next endpoint
+func (c *Client) forget(base string) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.leader == base {
+		c.leader = ""
+	}
+	c.next++
+}
+
+// baseURL turns host:port or a URL into scheme://host
+func baseURL(endpoint string) string {
+	if !strings.Contains(endpoint, "://") {
+		endpoint = "http://" + endpoint
+	}
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return strings.TrimRight(endpoint, "/")
+	}
+	return u.Scheme + "://" + u.Host
+}
+
+func sleep(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
@@ -0,0 +1,394 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/nipuntalukdar/raftdemojson/jsonstore"
+	"github.com/nipuntalukdar/raftdemojson/testcluster"
+)
+
+func newTestClient(t *testing.T, endpoints []string) *Client {
+	c, err := NewWithConfig(Config{Endpoints: endpoints, MaxRetries: 20,
+		Backoff: 100 * time.Millisecond, MaxBackoff: time.Second})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func TestClient(t *testing.T) {
+	cluster := testcluster.New(t, 3)
+	leader, err := cluster.WaitForLeader(10 * time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Start from a follower so that the client has to find the leader
+	var endpoints []string
+	for _, node := range cluster.Nodes {
+		if node != leader {
+			endpoints = append(endpoints, node.HttpAddr)
+		}
+	}
+	c := newTestClient(t, endpoints)
+	ctx := context.Background()
+
+	server, err := c.Leader(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if server.Id != leader.ID || server.HttpAddress != leader.HttpAddr {
+		t.Fatalf("leader %+v, expected %s", server, leader.ID)
+	}
+
+	if err := c.PutAll(ctx, map[string]string{"Hello": "World", "Hi": "There"}); err != nil {
+		t.Fatal(err)
+	}
+	value, err := c.Get(ctx, "Hello")
+	if err != nil || value != "World" {
+		t.Fatal("Get failed", value, err)
+	}
+	if _, err := c.Get(ctx, "missing"); err != ErrKeyNotFound {
+		t.Fatal("Expected ErrKeyNotFound, got", err)
+	}
+	found, err := c.Scan(ctx, "H", 0)
+	if err != nil || len(found) != 2 || found["Hi"] != "There" {
+		t.Fatal("Scan failed", found, err)
+	}
+	if err := c.Delete(ctx, "Hi"); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Delete(ctx, "Hi"); err != ErrKeyNotFound {
+		t.Fatal("Expected ErrKeyNotFound, got", err)
+	}
+}
+
+func TestClientFollowsLeaderChange(t *testing.T) {
+	cluster := testcluster.New(t, 3)
+	leader, err := cluster.WaitForLeader(10 * time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := newTestClient(t, cluster.Endpoints())
+	ctx := context.Background()
+	if err := c.Put(ctx, "before", "1"); err != nil {
+		t.Fatal(err)
+	}
+
+	cluster.Stop(leader)
+	if err := c.Put(ctx, "after", "2"); err != nil {
+		t.Fatal(err)
+	}
+	newleader, err := cluster.WaitForLeader(10 * time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if newleader == leader {
+		t.Fatal("Leader did not change")
+	}
+	value, err := c.Get(ctx, "before")
+	if err != nil || value != "1" {
+		t.Fatal("Get failed", value, err)
+	}
+}
+
+// lossyTransport fails the next DELETE, after sending it when lose is set
+// and without sending it otherwise
+type lossyTransport struct {
+	lock  sync.Mutex
+	fail  bool
+	lose  bool
+	calls int
+}
+
+func (transport *lossyTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	transport.lock.Lock()
+	fail := transport.fail && req.Method == http.MethodDelete
+	if req.Method == http.MethodDelete {
+		transport.calls++
+	}
+	transport.fail = transport.fail && !fail
+	transport.lock.Unlock()
+	if !fail {
+		return http.DefaultTransport.RoundTrip(req)
+	}
+	if !transport.lose {
+		return nil, &net.OpError{Op: "dial", Net: "tcp", Err: errors.New("connection refused")}
+	}
+	resp, err := http.DefaultTransport.RoundTrip(req)
+	if err == nil {
+		resp.Body.Close()
+	}
+	return nil, io.ErrUnexpectedEOF
+}
+
+func TestDeleteNotRetriedOnceSent(t *testing.T) {
+	cluster := testcluster.New(t, 1)
+	if _, err := cluster.WaitForLeader(10 * time.Second); err != nil {
+		t.Fatal(err)
+	}
+	transport := &lossyTransport{}
+	c, err := NewWithConfig(Config{Endpoints: cluster.Endpoints(), HttpClient: &http.Client{Transport: transport},
+		MaxRetries: 5, Backoff: 10 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	if err := c.PutAll(ctx, map[string]string{"a": "1", "b": "2"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// The delete is applied but its response is lost, it is not sent again
+	transport.fail, transport.lose = true, true
+	if err := c.Delete(ctx, "a"); err == nil || errors.Is(err, ErrKeyNotFound) {
+		t.Fatal("Expected the network error, got", err)
+	}
+	if transport.calls != 1 {
+		t.Fatalf("Expected one attempt, got %d", transport.calls)
+	}
+	if _, err := c.Get(ctx, "a"); !errors.Is(err, ErrKeyNotFound) {
+		t.Fatal("Expected the key to be deleted, got", err)
+	}
+
+	// A delete which could not be sent is retried
+	transport.fail, transport.lose, transport.calls = true, false, 0
+	if err := c.Delete(ctx, "b"); err != nil {
+		t.Fatal(err)
+	}
+	if transport.calls != 2 {
+		t.Fatalf("Expected two attempts, got %d", transport.calls)
+	}
+}
+
+func TestNoEndpoints(t *testing.T) {
+	if _, err := New(nil); err != ErrNoEndpoints {
+		t.Fatal("Expected ErrNoEndpoints, got", err)
+	}
+}
+
+func TestMembership(t *testing.T) {
+	cluster := testcluster.New(t, 3)
+	if _, err := cluster.WaitForLeader(10 * time.Second); err != nil {
+		t.Fatal(err)
+	}
+	c := newTestClient(t, cluster.Endpoints())
+	ctx := context.Background()
+
+	node := cluster.AddNode()
+	if err := c.AddNonvoter(ctx, node.ID, node.RaftAddr, node.HttpAddr); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.AddVoter(ctx, node.ID, node.RaftAddr, node.HttpAddr); err != nil {
+		t.Fatal(err)
+	}
+	servers, err := c.Servers(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var added *Server
+	for i := range servers {
+		if servers[i].Id == node.ID {
+			added = &servers[i]
+		}
+	}
+	// The new node is not in the static http config of the others
+	if added == nil || added.HttpAddress != node.HttpAddr || added.Suffrage != "Voter" {
+		t.Fatalf("New server not found in %+v", servers)
+	}
+
+	if err := c.DemoteVoter(ctx, node.ID); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.RemoveServer(ctx, node.ID); err != nil {
+		t.Fatal(err)
+	}
+	servers, err = c.Servers(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(servers) != 3 {
+		t.Fatalf("Expected 3 servers, found %+v", servers)
+	}
+}
+
+func TestRemoveLeader(t *testing.T) {
+	cluster := testcluster.New(t, 3)
+	leader, err := cluster.WaitForLeader(10 * time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := newTestClient(t, cluster.Endpoints())
+	ctx := context.Background()
+
+	if err := c.RemoveServer(ctx, leader.ID); err != nil {
+		t.Fatal(err)
+	}
+	newleader, err := cluster.WaitForLeader(10 * time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if newleader == leader {
+		t.Fatal("The removed server is still the leader")
+	}
+	servers, err := newleader.Raft.GetServers()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(servers) != 2 {
+		t.Fatalf("Expected 2 servers, found %+v", servers)
+	}
+	if address, err := newleader.Raft.HttpListener(leader.ID); err == nil {
+		t.Fatal("The http address of the removed server is still known:", address)
+	}
+}
+
+func TestJoin(t *testing.T) {
+	cluster := testcluster.New(t, 3)
+	leader, err := cluster.WaitForLeader(10 * time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := newTestClient(t, cluster.Endpoints())
+	ctx := context.Background()
+
+	node := cluster.AddNode()
+	_, err = c.Join(ctx, JoinRequest{ID: node.ID, Address: node.RaftAddr, HttpAddress: node.HttpAddr,
+		ClusterID: "othercluster"})
+	if statuserr, ok := err.(*StatusError); !ok || statuserr.StatusCode != 409 {
+		t.Fatal("Expected the join to be refused, got", err)
+	}
+
+	clusterid, err := c.Join(ctx, JoinRequest{ID: node.ID, Address: node.RaftAddr,
+		HttpAddress: node.HttpAddr, Nonvoter: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if clusterid == "" || clusterid != leader.Raft.ClusterID() {
+		t.Fatalf("Cluster id %q, expected %q", clusterid, leader.Raft.ClusterID())
+	}
+	servers, err := c.Servers(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(servers) != 4 || servers[3].Id != node.ID || servers[3].Suffrage != "Nonvoter" {
+		t.Fatalf("Joined server not found in %+v", servers)
+	}
+}
+
+func TestLeadershipTransfer(t *testing.T) {
+	cluster := testcluster.New(t, 3)
+	leader, err := cluster.WaitForLeader(10 * time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := newTestClient(t, cluster.Endpoints())
+	ctx := context.Background()
+
+	var target *testcluster.Node
+	for _, node := range cluster.Nodes {
+		if node != leader {
+			target = node
+			break
+		}
+	}
+	if err := c.LeadershipTransfer(ctx, target.ID); err != nil {
+		t.Fatal(err)
+	}
+	newleader, err := cluster.WaitForLeader(10 * time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if newleader != target {
+		t.Fatalf("Leader is %s, expected %s", newleader.ID, target.ID)
+	}
+
+	// A draining leader hands over the leadership and refuses writes
+	status, err := target.Raft.Drain()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !status.Draining || status.Leader || !status.SafeToStop {
+		t.Fatalf("Unexpected drain status %+v", status)
+	}
+	if err := target.Raft.AddKV("key", "value"); err != jsonstore.LeaderDifferent {
+		t.Fatal("Expected LeaderDifferent, got", err)
+	}
+	if err := c.Put(ctx, "key", "value"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestGracefulShutdown(t *testing.T) {
+	cluster := testcluster.New(t, 3)
+	leader, err := cluster.WaitForLeader(10 * time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := newTestClient(t, cluster.Endpoints())
+	ctx := context.Background()
+	if err := c.Put(ctx, "before", "1"); err != nil {
+		t.Fatal(err)
+	}
+
+	shutdownctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	if err := leader.Raft.GracefulShutdown(shutdownctx, true); err != nil {
+		t.Fatal(err)
+	}
+	if err := leader.Raft.AddKV("key", "value"); err != jsonstore.ErrDraining && err != jsonstore.LeaderDifferent {
+		t.Fatal("Write accepted after shutdown", err)
+	}
+	cluster.Stop(leader)
+
+	if _, err := cluster.WaitForLeader(10 * time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Put(ctx, "after", "2"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSnapshotInstall(t *testing.T) {
+	cluster := testcluster.New(t, 1)
+	if _, err := cluster.WaitForLeader(10 * time.Second); err != nil {
+		t.Fatal(err)
+	}
+	c := newTestClient(t, cluster.Endpoints())
+	ctx := context.Background()
+	for i := 0; i < 100; i++ {
+		if err := c.Put(ctx, fmt.Sprintf("key%03d", i), fmt.Sprint(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// The first entries are compacted away, a new node gets them from the
+	// snapshot
+	if err := c.Snapshot(ctx); err != nil {
+		t.Fatal(err)
+	}
+	node := cluster.AddNode()
+	if err := c.AddVoter(ctx, node.ID, node.RaftAddr, node.HttpAddr); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		value, err := node.Raft.Get("key000")
+		if err == nil && value == "0" {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("Snapshot not installed on the new node", err)
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	if node.Raft.NodeStatus().LatestSnapshot == nil {
+		t.Fatal("The new node caught up without a snapshot")
+	}
+}
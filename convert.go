@@ -0,0 +1,68 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/nipuntalukdar/raftdemojson/jsonstore"
+)
+
+const convertUsage = `Usage: raftdemojson convert -codec json|msgpack [flags]
+
+Rewrites the log store and the stable store of a stopped node with another
+codec, either those of -datadir or the files given with -logstore and
+-stablestore. The compression of the log store is kept. Nodes read the
+files whatever codec they have, and write them with the store_codec of
+their tuning.
+
+Flags:
+`
+
+// runConvert converts the store files of a node and returns the exit code
+func runConvert(args []string) int {
+	flags := flag.NewFlagSet("convert", flag.ContinueOnError)
+	codec := flags.String("codec", "", "Codec to convert to, json or msgpack")
+	dataDir := flags.String("datadir", "", "Data directory of the node")
+	logstoreFile := flags.String("logstore", "", "Directory of the logstore, when the node has no data directory")
+	stablestoreFile := flags.String("stablestore", "", "Path to stablestore file, when the node has no data directory")
+	flags.Usage = func() {
+		fmt.Fprint(flags.Output(), convertUsage)
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if flags.NArg() != 0 || *codec == "" ||
+		(*dataDir == "") == (*logstoreFile == "" && *stablestoreFile == "") {
+		flags.Usage()
+		return 2
+	}
+	to := jsonstore.Codec(*codec)
+	if *dataDir != "" {
+		logcodec, stablecodec, err := jsonstore.ConvertDataDir(*dataDir, to)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Conversion failed:", err)
+			return 1
+		}
+		fmt.Printf("Converted the log store from %s and the stable store from %s to %s in %s\n",
+			logcodec, stablecodec, to, *dataDir)
+		return 0
+	}
+	convert := []struct {
+		path    string
+		convert func(string, jsonstore.Codec) (jsonstore.Codec, error)
+	}{{*logstoreFile, jsonstore.ConvertLogStore}, {*stablestoreFile, jsonstore.ConvertStableStore}}
+	for _, file := range convert {
+		if file.path == "" {
+			continue
+		}
+		from, err := file.convert(file.path, to)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Conversion failed:", err)
+			return 1
+		}
+		fmt.Printf("Converted %s from %s to %s\n", file.path, from, to)
+	}
+	return 0
+}
@@ -0,0 +1,166 @@
+package httpapi
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/nipuntalukdar/raftdemojson/jsonstore"
+)
+
+var errMissingHttpAddress = errors.New("httpaddress missing")
+
+type RequestServer struct {
+	ID          string `json:"id"`
+	Address     string `json:"address,omitempty"`
+	HttpAddress string `json:"httpaddress,omitempty"`
+}
+
+type RequestJoin struct {
+	RequestServer
+
+	// Join as a nonvoter
+	Nonvoter bool `json:"nonvoter,omitempty"`
+
+	// Cluster the joining node has state from, if any
+	ClusterID string `json:"clusterid,omitempty"`
+}
+
+func (kv *KVStore) addVoter(w http.ResponseWriter, r *http.Request) {
+	kv.changeMembership(w, r, true, func(req *RequestServer) error {
+		return kv.rinf.AddVoter(req.ID, req.Address, req.HttpAddress)
+	})
+}
+
+func (kv *KVStore) addNonvoter(w http.ResponseWriter, r *http.Request) {
+	kv.changeMembership(w, r, true, func(req *RequestServer) error {
+		return kv.rinf.AddNonvoter(req.ID, req.Address, req.HttpAddress)
+	})
+}
+
+func (kv *KVStore) demoteVoter(w http.ResponseWriter, r *http.Request) {
+	kv.changeMembership(w, r, false, func(req *RequestServer) error {
+		return kv.rinf.DemoteVoter(req.ID)
+	})
+}
+
+func (kv *KVStore) removeServer(w http.ResponseWriter, r *http.Request) {
+	kv.changeMembership(w, r, false, func(req *RequestServer) error {
+		return kv.rinf.RemoveServer(req.ID)
+	})
+}
+
+func (kv *KVStore) setHttpListener(w http.ResponseWriter, r *http.Request) {
+	kv.changeMembership(w, r, false, func(req *RequestServer) error {
+		if req.HttpAddress == "" {
+			return errMissingHttpAddress
+		}
+		return kv.rinf.SetHttpListener(req.ID, req.HttpAddress)
+	})
+}
+
+// join adds a node which asks to be part of the cluster. Nodes with state
+// from another cluster are refused.
+func (kv *KVStore) join(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		json.NewEncoder(w).Encode(Response{Status: "failed", Message: "Method not allowed"})
+		return
+	}
+
+	var req RequestJoin
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil || req.ID == "" || req.Address == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(Response{Status: "failed", Message: "Bad request body"})
+		return
+	}
+
+	clusterid := kv.rinf.ClusterID()
+	if req.ClusterID != "" && clusterid != "" && req.ClusterID != clusterid {
+		kv.logger.Error("Join refused", "id", req.ID, "clusterid", req.ClusterID)
+		w.WriteHeader(http.StatusConflict)
+		json.NewEncoder(w).Encode(Response{Status: "failed", ClusterID: clusterid,
+			Message: fmt.Sprintf("node has state from cluster %s", req.ClusterID)})
+		return
+	}
+
+	kv.logger.Info("Join", "id", req.ID, "address", req.Address, "httpaddress", req.HttpAddress,
+		"nonvoter", req.Nonvoter)
+	if req.Nonvoter {
+		err = kv.rinf.AddNonvoter(req.ID, req.Address, req.HttpAddress)
+	} else {
+		err = kv.rinf.AddVoter(req.ID, req.Address, req.HttpAddress)
+	}
+	if err == jsonstore.LeaderDifferent {
+		kv.redirectToLeader(w, r.URL.Path)
+		return
+	}
+	if err != nil {
+		kv.logger.Error("Join failed", "id", req.ID, "Error", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(Response{Status: "failed", Message: err.Error()})
+		return
+	}
+	json.NewEncoder(w).Encode(Response{Status: "success", ClusterID: clusterid})
+}
+
+// changeMembership decodes a RequestServer and runs change on the leader.
+// Followers redirect the request to the leader.
+func (kv *KVStore) changeMembership(w http.ResponseWriter, r *http.Request, needAddress bool,
+	change func(req *RequestServer) error) {
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		json.NewEncoder(w).Encode(Response{Status: "failed", Message: "Method not allowed"})
+		return
+	}
+
+	var req RequestServer
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil || req.ID == "" || (needAddress && req.Address == "") {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(Response{Status: "failed", Message: "Bad request body"})
+		return
+	}
+
+	kv.logger.Info("Membership change", "path", r.URL.Path, "id", req.ID, "address", req.Address,
+		"httpaddress", req.HttpAddress)
+	err = change(&req)
+	if err == errMissingHttpAddress {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(Response{Status: "failed", Message: err.Error()})
+		return
+	}
+	if err == jsonstore.LeaderDifferent {
+		kv.redirectToLeader(w, r.URL.Path)
+		return
+	}
+	if err != nil {
+		kv.logger.Error("Membership change failed", "id", req.ID, "Error", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(Response{Status: "failed", Message: err.Error()})
+		return
+	}
+	json.NewEncoder(w).Encode(Response{Status: "success"})
+}
+
+// redirectToLeader redirects the request to the same path on the leader
+func (kv *KVStore) redirectToLeader(w http.ResponseWriter, path string) {
+	leaderserver, leaderid := kv.rinf.LeaderWithID()
+	kv.logger.Info("Different leader", "leader", leaderserver)
+	address := ""
+	if leaderserver != "" {
+		address = kv.httpAddress(leaderid)
+	}
+	if address == "" {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(Response{Status: "failed", Message: "Leader not found"})
+		return
+	}
+	w.Header().Set("Location", fmt.Sprintf("%s://%s%s", kv.scheme, address, path))
+	w.WriteHeader(http.StatusPermanentRedirect)
+}
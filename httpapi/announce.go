@@ -0,0 +1,72 @@
+package httpapi
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/nipuntalukdar/raftdemojson/jsonstore"
+)
+
+// How often a node retries announcing its http address
+const announceInterval = time.Second
+
+// Announce replicates the http address of this node through raft so that
+// the other nodes can redirect clients to it. A follower sends its address
+// to the leader. Announce returns once the address is in the replicated
+// state of this node or ctx is done.
+func (kv *KVStore) Announce(ctx context.Context, id, httpaddress string) {
+	for {
+		current, err := kv.rinf.HttpListener(id)
+		if err == nil && current == httpaddress {
+			kv.logger.Info("Http address replicated", "id", id, "httpaddress", httpaddress)
+			return
+		}
+		err = kv.rinf.SetHttpListener(id, httpaddress)
+		if err == jsonstore.LeaderDifferent {
+			err = kv.announceToLeader(ctx, id, httpaddress)
+		}
+		if err != nil {
+			kv.logger.Debug("Announcing http address", "Error", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(announceInterval):
+		}
+	}
+}
+
+func (kv *KVStore) announceToLeader(ctx context.Context, id, httpaddress string) error {
+	leaderserver, leaderid := kv.rinf.LeaderWithID()
+	if leaderserver == "" {
+		return errors.New("Leader not found")
+	}
+	leaderaddress := kv.httpAddress(leaderid)
+	if leaderaddress == "" {
+		return fmt.Errorf("http address of leader %s not known", leaderid)
+	}
+	data, err := json.Marshal(RequestServer{ID: id, HttpAddress: httpaddress})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost,
+		fmt.Sprintf("%s://%s/admin/httplistener", kv.scheme, leaderaddress), bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("leader %s returned %s", leaderid, resp.Status)
+	}
+	return nil
+}
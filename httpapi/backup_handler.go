@@ -0,0 +1,58 @@
+package httpapi
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/nipuntalukdar/raftdemojson/jsonstore"
+)
+
+// SetBackups sets the scheduled backups reported and run by /admin/backup
+func (kv *KVStore) SetBackups(backups *jsonstore.Backups) {
+	kv.backups.Store(backups)
+}
+
+// backup reports the backups of this node on GET. POST takes a backup on
+// the leader, followers redirect it.
+func (kv *KVStore) backup(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	backups := kv.backups.Load()
+	switch r.Method {
+	case http.MethodGet:
+		status := jsonstore.BackupStatus{}
+		if backups != nil {
+			status = backups.Status()
+		}
+		json.NewEncoder(w).Encode(Response{Status: "success", Backup: &status})
+	case http.MethodPost:
+		if backups == nil {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(Response{Status: "failed", Message: jsonstore.ErrBackupsDisabled.Error()})
+			return
+		}
+		_, err := backups.Run()
+		switch {
+		case err == jsonstore.LeaderDifferent:
+			kv.redirectToLeader(w, r.URL.Path)
+			return
+		case errors.Is(err, jsonstore.ErrBackupsDisabled):
+			w.WriteHeader(http.StatusBadRequest)
+		case errors.Is(err, jsonstore.ErrBackupRunning):
+			w.WriteHeader(http.StatusConflict)
+		case errors.Is(err, jsonstore.ErrBackupsStopped):
+			w.WriteHeader(http.StatusServiceUnavailable)
+		case err != nil:
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+		status := backups.Status()
+		if err != nil {
+			json.NewEncoder(w).Encode(Response{Status: "failed", Message: err.Error(), Backup: &status})
+			return
+		}
+		json.NewEncoder(w).Encode(Response{Status: "success", Backup: &status})
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		json.NewEncoder(w).Encode(Response{Status: "failed", Message: "Method not allowed"})
+	}
+}
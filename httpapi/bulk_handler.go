@@ -0,0 +1,82 @@
+package httpapi
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/nipuntalukdar/raftdemojson/jsonstore"
+)
+
+// importKeys adds the key-values streamed in the body, JSON Lines or a JSON
+// array, in batches. The query parameters skip and batch give the records
+// to skip, to resume a failed import, and the key-values per RAFT entry.
+// The response is a stream of JSON Lines: a progress line after every
+// committed batch, then a success or a failed line.
+func (kv *KVStore) importKeys(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		json.NewEncoder(w).Encode(Response{Status: "failed", Message: "Method not allowed"})
+		return
+	}
+	skip, err := queryInt(r, "skip", 0)
+	batch, berr := queryInt(r, "batch", jsonstore.DefaultImportBatch)
+	if err != nil || berr != nil || skip < 0 || batch <= 0 {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(Response{Status: "failed", Message: "Bad skip or batch"})
+		return
+	}
+	if !kv.rinf.IsLeader() {
+		kv.redirectToLeader(w, r.URL.RequestURI())
+		return
+	}
+
+	// Progress is reported while the body is still being read
+	controller := http.NewResponseController(w)
+	if err := controller.EnableFullDuplex(); err != nil {
+		kv.logger.Warn("Import progress is sent once the body is read", "Error", err)
+	}
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	encoder := json.NewEncoder(w)
+	kv.logger.Info("Importing keys", "skip", skip, "batch", batch)
+	progress, err := kv.rinf.Import(r.Body, skip, int(batch), func(progress jsonstore.ImportProgress) {
+		encoder.Encode(Response{Status: "progress", Import: &progress})
+		controller.Flush()
+	})
+	if err != nil {
+		kv.logger.Error("Import failed", "committed", progress.Committed, "Error", err)
+		encoder.Encode(Response{Status: "failed", Message: err.Error(), Import: &progress})
+		return
+	}
+	kv.logger.Info("Imported keys", "added", progress.Added, "entries", progress.Entries)
+	encoder.Encode(Response{Status: "success", Import: &progress})
+}
+
+// exportKeys streams the keys starting with the prefix query parameter as
+// JSON Lines, from a consistent view of this node
+func (kv *KVStore) exportKeys(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		json.NewEncoder(w).Encode(Response{Status: "failed", Message: "Method not allowed"})
+		return
+	}
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	count, err := kv.rinf.Export(w, r.URL.Query().Get("prefix"))
+	if err != nil {
+		kv.logger.Error("Export failed", "Error", err)
+		return
+	}
+	kv.logger.Info("Exported keys", "count", count)
+}
+
+// queryInt parses a query parameter, def if it is missing
+func queryInt(r *http.Request, name string, def int64) (int64, error) {
+	value := r.URL.Query().Get(name)
+	if value == "" {
+		return def, nil
+	}
+	return strconv.ParseInt(value, 10, 64)
+}
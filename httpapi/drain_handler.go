@@ -0,0 +1,85 @@
+package httpapi
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+
+	"github.com/nipuntalukdar/raftdemojson/jsonstore"
+)
+
+// Message sent for client writes while the node is draining
+const drainingMessage = "Node is draining"
+
+type RequestLeadershipTransfer struct {
+	// Server to hand the leadership to, any up to date follower if empty
+	ID string `json:"id,omitempty"`
+}
+
+// leadershipTransfer hands the leadership over, to the server in the
+// request body if one is given. Followers redirect to the leader.
+func (kv *KVStore) leadershipTransfer(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		json.NewEncoder(w).Encode(Response{Status: "failed", Message: "Method not allowed"})
+		return
+	}
+
+	var req RequestLeadershipTransfer
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil && err != io.EOF {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(Response{Status: "failed", Message: "Bad request body"})
+		return
+	}
+
+	kv.logger.Info("Leadership transfer", "to", req.ID)
+	if req.ID == "" {
+		err = kv.rinf.LeadershipTransfer()
+	} else {
+		err = kv.rinf.LeadershipTransferToServer(req.ID)
+	}
+	if err == jsonstore.LeaderDifferent {
+		kv.redirectToLeader(w, r.URL.Path)
+		return
+	}
+	if err != nil {
+		kv.logger.Error("Leadership transfer failed", "Error", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(Response{Status: "failed", Message: err.Error()})
+		return
+	}
+	json.NewEncoder(w).Encode(Response{Status: "success"})
+}
+
+// drain reports the drain status of this node on GET, starts draining on
+// POST and stops draining on DELETE
+func (kv *KVStore) drain(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	var err error
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		_, err = kv.rinf.Drain()
+	case http.MethodDelete:
+		kv.rinf.Undrain()
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		json.NewEncoder(w).Encode(Response{Status: "failed", Message: "Method not allowed"})
+		return
+	}
+	status := kv.rinf.DrainStatus()
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(Response{Status: "failed", Message: err.Error(), Drain: &status})
+		return
+	}
+	json.NewEncoder(w).Encode(Response{Status: "success", Drain: &status})
+}
+
+func (kv *KVStore) drainingResponse(w http.ResponseWriter) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusServiceUnavailable)
+	json.NewEncoder(w).Encode(Response{Status: "failed", Message: drainingMessage})
+}
@@ -0,0 +1,66 @@
+package httpapi_test
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nipuntalukdar/raftdemojson/jsonstore"
+	"github.com/nipuntalukdar/raftdemojson/testcluster"
+)
+
+func TestEvents(t *testing.T) {
+	cluster := testcluster.New(t, 3)
+	leader, err := cluster.WaitForLeader(10 * time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var follower *testcluster.Node
+	for _, node := range cluster.Nodes {
+		if node != leader {
+			follower = node
+			break
+		}
+	}
+	resp, err := http.Get("http://" + follower.HttpAddr + "/admin/events")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.Header.Get("Content-Type") != "text/event-stream" {
+		t.Fatal("Unexpected content type", resp.Header.Get("Content-Type"))
+	}
+
+	// Losing the leader starts an election
+	cluster.Stop(leader)
+	found := make(chan jsonstore.Event, 1)
+	go func() {
+		scanner := bufio.NewScanner(resp.Body)
+		for scanner.Scan() {
+			data, ok := strings.CutPrefix(scanner.Text(), "data: ")
+			if !ok {
+				continue
+			}
+			var event jsonstore.Event
+			if err := json.Unmarshal([]byte(data), &event); err != nil {
+				t.Error(err)
+				return
+			}
+			if event.Type == jsonstore.EventLeaderChange && event.Leader != "" && event.Leader != leader.ID {
+				found <- event
+				return
+			}
+		}
+	}()
+	select {
+	case event := <-found:
+		if event.LeaderAddress == "" {
+			t.Fatalf("Leader address missing from %+v", event)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("No leader change event")
+	}
+}
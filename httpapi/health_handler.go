@@ -0,0 +1,36 @@
+package httpapi
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/nipuntalukdar/raftdemojson/jsonstore"
+)
+
+// live tells that the process is up
+func (kv *KVStore) live(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(Response{Status: "success"})
+}
+
+// ready succeeds when the node can serve requests, see jsonstore.Health
+func (kv *KVStore) ready(w http.ResponseWriter, r *http.Request) {
+	health := kv.rinf.Health()
+	kv.healthResponse(w, health.Ready, &health)
+}
+
+// leader succeeds only on the leader
+func (kv *KVStore) leader(w http.ResponseWriter, r *http.Request) {
+	health := kv.rinf.Health()
+	kv.healthResponse(w, kv.rinf.IsLeader(), &health)
+}
+
+func (kv *KVStore) healthResponse(w http.ResponseWriter, healthy bool, health *jsonstore.Health) {
+	w.Header().Set("Content-Type", "application/json")
+	if !healthy {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		json.NewEncoder(w).Encode(Response{Status: "failed", Health: health})
+		return
+	}
+	json.NewEncoder(w).Encode(Response{Status: "success", Health: health})
+}
@@ -0,0 +1,60 @@
+package httpapi_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/nipuntalukdar/raftdemojson/httpapi"
+	"github.com/nipuntalukdar/raftdemojson/testcluster"
+)
+
+func getHealth(t *testing.T, node *testcluster.Node, path string) (int, httpapi.Response) {
+	resp, err := http.Get("http://" + node.HttpAddr + path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var body httpapi.Response
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		t.Fatal(err)
+	}
+	return resp.StatusCode, body
+}
+
+func TestHealth(t *testing.T) {
+	cluster := testcluster.New(t, 3)
+	leader, err := cluster.WaitForLeader(10 * time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, node := range cluster.Nodes {
+		if code, _ := getHealth(t, node, "/health/live"); code != http.StatusOK {
+			t.Fatal("Live check failed on", node.ID, code)
+		}
+		code, body := getHealth(t, node, "/health/leader")
+		if (code == http.StatusOK) != (node == leader) || body.Health == nil {
+			t.Fatalf("Leader check on %s returned %d", node.ID, code)
+		}
+		var ready bool
+		for i := 0; i < 50 && !ready; i++ {
+			code, body = getHealth(t, node, "/health/ready")
+			ready = code == http.StatusOK
+			if !ready {
+				time.Sleep(100 * time.Millisecond)
+			}
+		}
+		if !ready || body.Health.Leader != leader.ID || !body.Health.StoresWritable {
+			t.Fatalf("Node %s not ready: %+v", node.ID, body.Health)
+		}
+	}
+
+	// A draining node is taken out of the load balancer
+	node := cluster.Nodes[0]
+	node.Raft.Drain()
+	if code, body := getHealth(t, node, "/health/ready"); code != http.StatusServiceUnavailable ||
+		body.Health.Ready {
+		t.Fatalf("Draining node %s is ready: %+v", node.ID, body.Health)
+	}
+}
@@ -0,0 +1,377 @@
+package httpapi
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"sync/atomic"
+
+	hclog "github.com/hashicorp/go-hclog"
+	"github.com/nipuntalukdar/raftdemojson/jsonstore"
+	"github.com/nipuntalukdar/raftdemojson/telemetry"
+)
+
+type Document struct {
+	Key   string `json:"key"`
+	Value string `json:"value"`
+}
+
+type RequestData struct {
+	Data []Document `json:"data"`
+}
+
+type RequestKeys struct {
+	Keys []string `json:"keys"`
+}
+
+type RequestScan struct {
+	Prefix string `json:"prefix"`
+	Limit  int    `json:"limit"`
+}
+
+type Servers struct {
+	Servers []jsonstore.Server `json:"servers"`
+}
+
+type Response struct {
+	Status     string                    `json:"status"`
+	Message    string                    `json:"message,omitempty"`
+	DeleteKeys []string                  `json:"deleted,omitempty"`
+	NotFound   []string                  `json:"notfound,omitempty"`
+	FoundKeys  map[string]string         `json:"found,omitempty"`
+	Servers    []jsonstore.Server        `json:"servers,omitempty"`
+	ClusterID  string                    `json:"clusterid,omitempty"`
+	Drain      *jsonstore.DrainStatus    `json:"drain,omitempty"`
+	Reload     *ReloadReport             `json:"reload,omitempty"`
+	Health     *jsonstore.Health         `json:"health,omitempty"`
+	Raft       *jsonstore.Stats          `json:"raft,omitempty"`
+	Backup     *jsonstore.BackupStatus   `json:"backup,omitempty"`
+	Import     *jsonstore.ImportProgress `json:"import,omitempty"`
+}
+
+// KVStore serves the key-value REST API on top of a RaftInterface
+type KVStore struct {
+	rinf          *jsonstore.RaftInterface
+	logger        hclog.Logger
+	httplisteners map[string]string
+
+	// http or https, used in the redirects to the leader
+	scheme string
+
+	// Limits of the node, none if nil
+	guard atomic.Pointer[guard]
+
+	// Reloads the settings of the node
+	reloader atomic.Pointer[func() (ReloadReport, error)]
+
+	// Scheduled backups of the node, none if nil
+	backups atomic.Pointer[jsonstore.Backups]
+
+	// Closed to end the event streams
+	closing   chan struct{}
+	closeOnce sync.Once
+}
+
+// NewKVStore creates the REST handlers. httplisteners maps a raft server id
+// to the http address of that server. It is used for redirecting writes to
+// the leader until the servers have replicated their addresses, and may be
+// nil.
+func NewKVStore(rinf *jsonstore.RaftInterface, logger hclog.Logger,
+	httplisteners map[string]string) *KVStore {
+	return &KVStore{rinf: rinf, logger: logger, httplisteners: httplisteners, scheme: "http",
+		closing: make(chan struct{})}
+}
+
+// UseTLS makes the redirects to the leader and the announcements use https,
+// for nodes serving the REST API over TLS
+func (kv *KVStore) UseTLS() {
+	kv.scheme = "https"
+}
+
+// Register adds the REST endpoints to mux
+func (kv *KVStore) Register(mux *http.ServeMux) {
+	kv.handle(mux, "/keyvals", kv.limited(kv.handlePost))
+	kv.handle(mux, "/delete", kv.limited(kv.deleteKeys))
+	kv.handle(mux, "/testpersist", kv.limited(kv.testPersist))
+	kv.handle(mux, "/getkeys", kv.limited(kv.getKeys))
+	kv.handle(mux, "/scan", kv.limited(kv.scanKeys))
+	kv.handle(mux, "/import", kv.limited(kv.importKeys))
+	kv.handle(mux, "/export", kv.limited(kv.exportKeys))
+	kv.handle(mux, "/servers", kv.limited(kv.getServers))
+	kv.handle(mux, "/admin/addvoter", kv.limited(kv.addVoter))
+	kv.handle(mux, "/admin/addnonvoter", kv.limited(kv.addNonvoter))
+	kv.handle(mux, "/admin/demotevoter", kv.limited(kv.demoteVoter))
+	kv.handle(mux, "/admin/removeserver", kv.limited(kv.removeServer))
+	kv.handle(mux, "/admin/httplistener", kv.limited(kv.setHttpListener))
+	kv.handle(mux, "/admin/join", kv.limited(kv.join))
+	kv.handle(mux, "/admin/leadershiptransfer", kv.limited(kv.leadershipTransfer))
+	kv.handle(mux, "/admin/drain", kv.limited(kv.drain))
+	kv.handle(mux, "/admin/reload", kv.limited(kv.reload))
+	kv.handle(mux, "/admin/raft", kv.limited(kv.raftStats))
+	kv.handle(mux, "/admin/events", kv.limited(kv.events))
+	kv.handle(mux, "/admin/snapshot", kv.limited(kv.exportSnapshot))
+	kv.handle(mux, "/admin/backup", kv.limited(kv.backup))
+	kv.handle(mux, "/status", kv.limited(kv.clusterStatus))
+	kv.handle(mux, "/status/node", kv.limited(kv.nodeStatus))
+	kv.handle(mux, "/dashboard", kv.limited(kv.dashboard))
+	// Probes are not limited
+	kv.handle(mux, "/health/live", kv.live)
+	kv.handle(mux, "/health/ready", kv.ready)
+	kv.handle(mux, "/health/leader", kv.leader)
+}
+
+// handle registers handler for path with the request metrics
+func (kv *KVStore) handle(mux *http.ServeMux, path string, handler http.HandlerFunc) {
+	mux.HandleFunc(path, telemetry.Instrument(path, handler))
+}
+
+func (kv *KVStore) deleteKeys(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		json.NewEncoder(w).Encode(Response{Status: "failed", Message: "Method not allowed"})
+		return
+	}
+
+	var req RequestKeys
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(Response{Status: "failed", Message: "Bad request body"})
+		return
+	}
+
+	deletedKeys := []string{}
+	notFoundKeys := []string{}
+	baderr := err
+	// The deletions are all queued first so that they share log entries
+	futures := make([]*jsonstore.WriteFuture, len(req.Keys))
+	for i, key := range req.Keys {
+		futures[i] = kv.rinf.DeleteAsync(key)
+	}
+	for i, key := range req.Keys {
+
+		err = futures[i].Error()
+		if err != nil {
+			if err == jsonstore.ErrDraining {
+				kv.drainingResponse(w)
+				return
+			} else if err == jsonstore.ErrKeyNotFound {
+				notFoundKeys = append(notFoundKeys, key)
+			} else if err == jsonstore.LeaderDifferent {
+				leaderserver, leaderid := kv.rinf.LeaderWithID()
+				kv.logger.Info("Different leader", "leader", leaderserver)
+				if leaderserver != "" {
+					leaderUrl := fmt.Sprintf("%s://%s/delete", kv.scheme, kv.httpAddress(leaderid))
+					w.Header().Set("Location", leaderUrl)
+					w.WriteHeader(http.StatusPermanentRedirect)
+
+				} else {
+					w.Header().Set("Content-Type", "application/json")
+					w.WriteHeader(http.StatusInternalServerError)
+					json.NewEncoder(w).Encode(Response{Status: "failed", Message: "Leader not found"})
+				}
+				return
+
+			} else {
+				baderr = err
+			}
+		} else {
+			deletedKeys = append(deletedKeys, key)
+		}
+	}
+	response := Response{}
+	if len(notFoundKeys) > 0 {
+		response.NotFound = notFoundKeys
+	}
+
+	if len(deletedKeys) > 0 {
+		response.DeleteKeys = deletedKeys
+		w.WriteHeader(http.StatusOK)
+		response.Status = "success"
+	} else {
+		response.Status = "failed"
+		w.WriteHeader(http.StatusNotFound)
+	}
+	if baderr != nil {
+		response.Status = "failed"
+		w.WriteHeader(http.StatusInternalServerError)
+		response.Message = baderr.Error()
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// httpAddress returns the http address of a server. The addresses
+// replicated through raft are used, the static http listener config is
+// only a fallback until a server has announced its address.
+func (kv *KVStore) httpAddress(id string) string {
+	if address, err := kv.rinf.HttpListener(id); err == nil {
+		return address
+	}
+	return kv.httplisteners[id]
+}
+
+func (kv *KVStore) handlePost(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var requestData RequestData
+	decoder := json.NewDecoder(r.Body)
+	if err := decoder.Decode(&requestData); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		kv.logger.Error("DecodeError", "Error", err)
+		return
+	}
+
+	kv.logger.Info("Received keyvals:")
+	// The key-values are all queued first so that they share log entries
+	futures := make([]*jsonstore.WriteFuture, len(requestData.Data))
+	for i, doc := range requestData.Data {
+		kv.logger.Debug("Add Data", doc.Key, doc.Value)
+		futures[i] = kv.rinf.AddKVAsync(doc.Key, doc.Value)
+	}
+	for _, future := range futures {
+		err := future.Error()
+		if err != nil {
+			if err == jsonstore.ErrDraining {
+				kv.drainingResponse(w)
+				return
+			} else if err != jsonstore.LeaderDifferent {
+				http.Error(w, "Internal Error", http.StatusInternalServerError)
+				kv.logger.Error("KeyAadd", "Error", err)
+				return
+			} else {
+				leaderserver, leaderid := kv.rinf.LeaderWithID()
+				kv.logger.Info("Different leader", "leader", leaderserver)
+				if leaderserver != "" {
+					leaderUrl := fmt.Sprintf("%s://%s/keyvals", kv.scheme, kv.httpAddress(leaderid))
+					w.Header().Set("Location", leaderUrl)
+					w.WriteHeader(http.StatusPermanentRedirect)
+
+				} else {
+					w.Header().Set("Content-Type", "application/json")
+					w.WriteHeader(http.StatusInternalServerError)
+					json.NewEncoder(w).Encode(Response{Status: "failed", Message: "Leader not found"})
+				}
+				return
+			}
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(Response{Status: "success"})
+}
+
+func (kv *KVStore) getServers(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	kv.logger.Info("Getting servers")
+
+	servers, err := kv.rinf.GetServers()
+	for i := range servers {
+		servers[i].HttpAddress = kv.httpAddress(servers[i].Id)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(Response{Status: "failure"})
+	} else {
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(Response{Status: "success", Servers: servers})
+	}
+}
+
+func (kv *KVStore) testPersist(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	kv.logger.Info("Persisting snapshot")
+	kv.rinf.Persist()
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(Response{Status: "success"})
+}
+
+func (kv *KVStore) getKeys(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		json.NewEncoder(w).Encode(Response{Status: "failed", Message: "Method not allowed"})
+		return
+	}
+
+	var req RequestKeys
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(Response{Status: "failed", Message: "Bad request body"})
+		return
+	}
+
+	foundkeys := make(map[string]string)
+	notFoundKeys := []string{}
+	baderr := err
+	for _, key := range req.Keys {
+		value, err := kv.rinf.Get(key)
+		if err != nil {
+			if err == jsonstore.ErrKeyNotFound {
+				notFoundKeys = append(notFoundKeys, key)
+			} else {
+				baderr = err
+			}
+		} else {
+			foundkeys[key] = value
+		}
+	}
+	response := Response{}
+	if len(notFoundKeys) > 0 {
+		response.NotFound = notFoundKeys
+	}
+
+	if len(foundkeys) > 0 {
+		response.FoundKeys = foundkeys
+		w.WriteHeader(http.StatusOK)
+		response.Status = "success"
+	} else {
+		response.Status = "failed"
+		w.WriteHeader(http.StatusNotFound)
+	}
+	if baderr != nil {
+		response.Status = "failed"
+		w.WriteHeader(http.StatusInternalServerError)
+		response.Message = baderr.Error()
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+func (kv *KVStore) scanKeys(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		json.NewEncoder(w).Encode(Response{Status: "failed", Message: "Method not allowed"})
+		return
+	}
+
+	// An empty body scans all the keys
+	var req RequestScan
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if (err != nil && err != io.EOF) || req.Limit < 0 {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(Response{Status: "failed", Message: "Bad request body"})
+		return
+	}
+
+	found := kv.rinf.Scan(req.Prefix, req.Limit)
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(Response{Status: "success", FoundKeys: found})
+}
@@ -0,0 +1,152 @@
+package httpapi
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Limits restrict the requests served by a node. They can be replaced
+// while the node is serving.
+type Limits struct {
+	// Requests per second served by the node, no limit if 0
+	RateLimit float64 `json:"rate_limit,omitempty"`
+
+	// Requests served at once above the rate, 1 if 0
+	RateBurst int `json:"rate_burst,omitempty"`
+
+	// Networks in CIDR notation allowed to call the /admin endpoints and
+	// the other operator endpoints in adminPaths, any network if empty. The
+	// other nodes have to be allowed, they call /admin/httplistener and
+	// /admin/join on the leader.
+	AdminAllow []string `json:"admin_allow,omitempty"`
+}
+
+// Validate checks the limits
+func (limits Limits) Validate() error {
+	_, err := newGuard(limits)
+	return err
+}
+
+// guard enforces Limits with a token bucket and an allow list
+type guard struct {
+	limits   Limits
+	networks []*net.IPNet
+
+	lock   sync.Mutex
+	tokens float64
+	last   time.Time
+}
+
+func newGuard(limits Limits) (*guard, error) {
+	var errs []error
+	if limits.RateLimit < 0 {
+		errs = append(errs, fmt.Errorf("rate_limit must not be negative, got %v", limits.RateLimit))
+	}
+	if limits.RateBurst < 0 {
+		errs = append(errs, fmt.Errorf("rate_burst must not be negative, got %d", limits.RateBurst))
+	}
+	if limits.RateBurst == 0 {
+		limits.RateBurst = 1
+	}
+	g := &guard{limits: limits, tokens: float64(limits.RateBurst), last: time.Now()}
+	for _, cidr := range limits.AdminAllow {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("admin_allow: %w", err))
+			continue
+		}
+		g.networks = append(g.networks, network)
+	}
+	return g, errors.Join(errs...)
+}
+
+// allow takes a token from the bucket
+func (g *guard) allow() bool {
+	if g.limits.RateLimit == 0 {
+		return true
+	}
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	now := time.Now()
+	g.tokens += now.Sub(g.last).Seconds() * g.limits.RateLimit
+	if g.tokens > float64(g.limits.RateBurst) {
+		g.tokens = float64(g.limits.RateBurst)
+	}
+	g.last = now
+	if g.tokens < 1 {
+		return false
+	}
+	g.tokens--
+	return true
+}
+
+// admitted checks the client address against the allow list
+func (g *guard) admitted(remoteaddr string) bool {
+	if len(g.networks) == 0 {
+		return true
+	}
+	host, _, err := net.SplitHostPort(remoteaddr)
+	if err != nil {
+		host = remoteaddr
+	}
+	ip := net.ParseIP(host)
+	for _, network := range g.networks {
+		if ip != nil && network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// SetLimits replaces the limits of the node
+func (kv *KVStore) SetLimits(limits Limits) error {
+	g, err := newGuard(limits)
+	if err != nil {
+		return err
+	}
+	kv.guard.Store(g)
+	return nil
+}
+
+// Endpoints outside /admin/ which only the admin networks may call: bulk
+// writes, dumps of the whole store and forced snapshots
+var adminPaths = map[string]bool{"/import": true, "/export": true, "/testpersist": true}
+
+// adminOnly tells whether path is restricted to the admin networks
+func adminOnly(path string) bool {
+	return strings.HasPrefix(path, "/admin/") || adminPaths[path]
+}
+
+// limited applies the limits of the node before calling handler
+func (kv *KVStore) limited(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		g := kv.guard.Load()
+		if g == nil {
+			handler(w, r)
+			return
+		}
+		if adminOnly(r.URL.Path) && !g.admitted(r.RemoteAddr) {
+			kv.logger.Warn("Admin request refused", "remote", r.RemoteAddr, "path", r.URL.Path)
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusForbidden)
+			json.NewEncoder(w).Encode(Response{Status: "failed", Message: "Forbidden"})
+			return
+		}
+		if !g.allow() {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusTooManyRequests)
+			json.NewEncoder(w).Encode(Response{Status: "failed", Message: tooManyRequestsMessage})
+			return
+		}
+		handler(w, r)
+	}
+}
+
+// Message sent when the rate limit is exceeded
+const tooManyRequestsMessage = "Too many requests"
@@ -0,0 +1,40 @@
+package httpapi_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	hclog "github.com/hashicorp/go-hclog"
+	"github.com/nipuntalukdar/raftdemojson/httpapi"
+	"github.com/nipuntalukdar/raftdemojson/testcluster"
+)
+
+func TestAdminAllow(t *testing.T) {
+	cluster := testcluster.New(t, 1)
+	leader, err := cluster.WaitForLeader(10 * time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	kvstore := httpapi.NewKVStore(leader.Raft, hclog.NewNullLogger(), nil)
+	if err := kvstore.SetLimits(httpapi.Limits{AdminAllow: []string{"10.0.0.0/8"}}); err != nil {
+		t.Fatal(err)
+	}
+	mux := http.NewServeMux()
+	kvstore.Register(mux)
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	for path, forbidden := range map[string]bool{"/admin/raft": true, "/import": true, "/export": true,
+		"/testpersist": true, "/servers": false, "/status": false} {
+		resp, err := http.Post(server.URL+path, "application/json", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if (resp.StatusCode == http.StatusForbidden) != forbidden {
+			t.Errorf("Unexpected status %d for %s", resp.StatusCode, path)
+		}
+	}
+}
@@ -0,0 +1,44 @@
+package httpapi
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// ReloadReport lists the settings changed by a reload and the changed
+// settings which only take effect after a restart
+type ReloadReport struct {
+	Changed         []string `json:"changed"`
+	RestartRequired []string `json:"restartrequired"`
+}
+
+// SetReloader sets the function reloading the settings of the node for
+// /admin/reload
+func (kv *KVStore) SetReloader(reload func() (ReloadReport, error)) {
+	kv.reloader.Store(&reload)
+}
+
+// reload reloads the settings of this node. It is not redirected, every
+// node has its own settings.
+func (kv *KVStore) reload(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		json.NewEncoder(w).Encode(Response{Status: "failed", Message: "Method not allowed"})
+		return
+	}
+	reload := kv.reloader.Load()
+	if reload == nil {
+		w.WriteHeader(http.StatusNotImplemented)
+		json.NewEncoder(w).Encode(Response{Status: "failed", Message: "Reload needs a node config file"})
+		return
+	}
+	report, err := (*reload)()
+	if err != nil {
+		kv.logger.Error("Reload failed", "Error", err)
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(Response{Status: "failed", Message: err.Error()})
+		return
+	}
+	json.NewEncoder(w).Encode(Response{Status: "success", Reload: &report})
+}
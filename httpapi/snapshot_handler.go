@@ -0,0 +1,48 @@
+package httpapi
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+
+	"github.com/nipuntalukdar/raftdemojson/jsonstore"
+)
+
+// exportSnapshot streams the newest snapshot of this node as it is stored.
+// With fresh=true a snapshot is taken first.
+func (kv *KVStore) exportSnapshot(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		json.NewEncoder(w).Encode(Response{Status: "failed", Message: "Method not allowed"})
+		return
+	}
+	fresh, _ := strconv.ParseBool(r.URL.Query().Get("fresh"))
+	snapshot, file, err := kv.rinf.ExportSnapshot(fresh)
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		if errors.Is(err, jsonstore.ErrNoSnapshot) {
+			w.WriteHeader(http.StatusNotFound)
+		} else {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+		json.NewEncoder(w).Encode(Response{Status: "failed", Message: err.Error()})
+		return
+	}
+	defer file.Close()
+	name := snapshot.ID + ".json"
+	w.Header().Set("Content-Type", "application/json")
+	if snapshot.Codec == jsonstore.CompressionGzip {
+		name += ".gz"
+		w.Header().Set("Content-Type", "application/gzip")
+	}
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", name))
+	kv.logger.Info("Exporting snapshot", "snapshot", snapshot.ID, "index", snapshot.Index,
+		"term", snapshot.Term)
+	if _, err := io.Copy(w, file); err != nil {
+		kv.logger.Error("Snapshot export failed", "snapshot", snapshot.ID, "Error", err)
+	}
+}
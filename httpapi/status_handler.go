@@ -0,0 +1,135 @@
+package httpapi
+
+import (
+	"context"
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/nipuntalukdar/raftdemojson/jsonstore"
+)
+
+// Time allowed for a node to report its status
+const statusTimeout = 2 * time.Second
+
+//go:embed dashboard.html
+var dashboardPage []byte
+
+// State of one server of the cluster
+type ServerStatus struct {
+	jsonstore.Server
+
+	// Status reported by the node, nil if it could not be reached
+	Status *jsonstore.NodeStatus `json:"status,omitempty"`
+
+	// Log entries the node is behind the leader
+	Lag uint64 `json:"lag"`
+
+	// Why the status of the node is missing
+	Error string `json:"error,omitempty"`
+}
+
+// State of the cluster as seen by the node serving the request
+type ClusterStatus struct {
+	Leader  string         `json:"leader"`
+	Servers []ServerStatus `json:"servers"`
+}
+
+// nodeStatus serves the status of this node
+func (kv *KVStore) nodeStatus(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(kv.rinf.NodeStatus())
+}
+
+// raftStats serves the RAFT statistics of this node
+func (kv *KVStore) raftStats(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	stats := kv.rinf.Stats()
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(Response{Status: "success", Raft: &stats})
+}
+
+// clusterStatus collects the status of every server of the cluster
+func (kv *KVStore) clusterStatus(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	servers, err := kv.rinf.GetServers()
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(Response{Status: "failed", Message: err.Error()})
+		return
+	}
+	self := kv.rinf.NodeStatus()
+	status := ClusterStatus{Leader: self.Leader, Servers: make([]ServerStatus, len(servers))}
+	var wg sync.WaitGroup
+	for i, server := range servers {
+		server.HttpAddress = kv.httpAddress(server.Id)
+		status.Servers[i].Server = server
+		if server.Id == self.ID {
+			status.Servers[i].Status = &self
+			continue
+		}
+		wg.Add(1)
+		go func(serverstatus *ServerStatus) {
+			defer wg.Done()
+			nodestatus, err := kv.fetchNodeStatus(r.Context(), serverstatus.HttpAddress)
+			if err != nil {
+				serverstatus.Error = err.Error()
+				return
+			}
+			serverstatus.Status = nodestatus
+		}(&status.Servers[i])
+	}
+	wg.Wait()
+
+	// Replication lag relative to the last log index of the leader
+	var leaderindex uint64
+	for _, server := range status.Servers {
+		if server.Leader && server.Status != nil {
+			leaderindex = server.Status.LastLogIndex
+		}
+	}
+	for i := range status.Servers {
+		if nodestatus := status.Servers[i].Status; nodestatus != nil && leaderindex > nodestatus.LastLogIndex {
+			status.Servers[i].Lag = leaderindex - nodestatus.LastLogIndex
+		}
+	}
+	json.NewEncoder(w).Encode(status)
+}
+
+func (kv *KVStore) fetchNodeStatus(ctx context.Context, httpaddress string) (*jsonstore.NodeStatus, error) {
+	if httpaddress == "" {
+		return nil, fmt.Errorf("http address not known")
+	}
+	ctx, cancel := context.WithTimeout(ctx, statusTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet,
+		fmt.Sprintf("%s://%s/status/node", kv.scheme, httpaddress), nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("node returned %s", resp.Status)
+	}
+	var nodestatus jsonstore.NodeStatus
+	if err := json.NewDecoder(resp.Body).Decode(&nodestatus); err != nil {
+		return nil, err
+	}
+	return &nodestatus, nil
+}
+
+// dashboard serves the status page, which polls /status
+func (kv *KVStore) dashboard(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write(dashboardPage)
+}
@@ -0,0 +1,87 @@
+package httpapi_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/nipuntalukdar/raftdemojson/httpapi"
+	"github.com/nipuntalukdar/raftdemojson/testcluster"
+)
+
+func TestClusterStatus(t *testing.T) {
+	cluster := testcluster.New(t, 3)
+	leader, err := cluster.WaitForLeader(10 * time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var status httpapi.ClusterStatus
+	for i := 0; i < 50; i++ {
+		resp, err := http.Get("http://" + cluster.Nodes[0].HttpAddr + "/status")
+		if err != nil {
+			t.Fatal(err)
+		}
+		status = httpapi.ClusterStatus{}
+		err = json.NewDecoder(resp.Body).Decode(&status)
+		resp.Body.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		reachable := 0
+		for _, server := range status.Servers {
+			if server.Status != nil {
+				reachable++
+			}
+		}
+		if status.Leader == leader.ID && reachable == len(cluster.Nodes) {
+			break
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	if status.Leader != leader.ID || len(status.Servers) != len(cluster.Nodes) {
+		t.Fatalf("Unexpected cluster status %+v", status)
+	}
+	for _, server := range status.Servers {
+		if server.Status == nil {
+			t.Fatalf("No status for %s: %s", server.Id, server.Error)
+		}
+		if server.Status.ID != server.Id || server.Status.Term == 0 {
+			t.Fatalf("Wrong status for %s: %+v", server.Id, server.Status)
+		}
+	}
+
+	resp, err := http.Get("http://" + cluster.Nodes[0].HttpAddr + "/dashboard")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatal("Dashboard returned", resp.Status)
+	}
+}
+
+func TestRaftStats(t *testing.T) {
+	cluster := testcluster.New(t, 3)
+	leader, err := cluster.WaitForLeader(10 * time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := http.Get("http://" + leader.HttpAddr + "/admin/raft")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var body httpapi.Response
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		t.Fatal(err)
+	}
+	stats := body.Raft
+	if stats == nil || stats.State != "Leader" || stats.Term == 0 || stats.NumPeers != 2 ||
+		stats.ProtocolVersion == 0 || stats.LastContact != nil {
+		t.Fatalf("Unexpected stats %+v", stats)
+	}
+	if stats.LastLogIndex < stats.CommitIndex || stats.LogStoreLastIndex != stats.LastLogIndex {
+		t.Fatalf("Inconsistent indexes %+v", stats)
+	}
+}
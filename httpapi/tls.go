@@ -0,0 +1,44 @@
+package httpapi
+
+import (
+	"bytes"
+	"crypto/tls"
+	"sync/atomic"
+)
+
+// Certificate holds the TLS certificate of the http listener. It can be
+// replaced while the listener is serving.
+type Certificate struct {
+	cert atomic.Pointer[tls.Certificate]
+}
+
+// LoadCertificate reads a certificate and its key in PEM format
+func LoadCertificate(certfile, keyfile string) (*Certificate, error) {
+	certificate := &Certificate{}
+	if _, err := certificate.Reload(certfile, keyfile); err != nil {
+		return nil, err
+	}
+	return certificate, nil
+}
+
+// Reload reads the certificate and its key again. It reports whether the
+// certificate changed. The old certificate is kept on errors.
+func (certificate *Certificate) Reload(certfile, keyfile string) (bool, error) {
+	cert, err := tls.LoadX509KeyPair(certfile, keyfile)
+	if err != nil {
+		return false, err
+	}
+	return certificate.Store(&cert), nil
+}
+
+// Store replaces the certificate and reports whether it changed
+func (certificate *Certificate) Store(cert *tls.Certificate) bool {
+	old := certificate.cert.Swap(cert)
+	return old == nil || !bytes.Equal(old.Certificate[0], cert.Certificate[0])
+}
+
+// GetCertificate serves the current certificate, it is meant for
+// tls.Config.GetCertificate
+func (certificate *Certificate) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	return certificate.cert.Load(), nil
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/nipuntalukdar/raftdemojson/jsonstore"
+)
+
+const inspectUsage = `Usage: raftdemojson inspect <file>...
+
+Prints the files of the stores of a node, the log segments, the stable
+store, snapshots or meta.json, as indented JSON. Compressed files are
+decompressed and msgpack store documents are printed as JSON. For a
+directory, e.g. the log store, the files in it are printed.
+`
+
+// runInspect prints store files and returns the exit code
+func runInspect(args []string) int {
+	flags := flag.NewFlagSet("inspect", flag.ContinueOnError)
+	flags.Usage = func() {
+		fmt.Fprint(flags.Output(), inspectUsage)
+	}
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if flags.NArg() == 0 {
+		flags.Usage()
+		return 2
+	}
+	out := bufio.NewWriter(os.Stdout)
+	defer out.Flush()
+	var names []string
+	for _, name := range flags.Args() {
+		if info, err := os.Stat(name); err == nil && info.IsDir() {
+			entries, err := os.ReadDir(name)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				return 1
+			}
+			for _, entry := range entries {
+				if !entry.IsDir() {
+					names = append(names, filepath.Join(name, entry.Name()))
+				}
+			}
+			continue
+		}
+		names = append(names, name)
+	}
+	for _, name := range names {
+		file, err := os.Open(name)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		err = jsonstore.Inspect(out, file)
+		file.Close()
+		if err != nil {
+			out.Flush()
+			fmt.Fprintf(os.Stderr, "%s: %v\n", name, err)
+			return 1
+		}
+	}
+	return 0
+}
@@ -0,0 +1,178 @@
+package jsonstore
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	hclog "github.com/hashicorp/go-hclog"
+	metrics "github.com/hashicorp/go-metrics/compat"
+	"github.com/hashicorp/raft"
+)
+
+// WriteFuture is a client write sent to the apply pipeline. Error waits
+// until the write is committed and returns its outcome.
+type WriteFuture struct {
+	err  error
+	done chan error
+}
+
+// Error returns nil once the write is applied, or why it failed. It must
+// not be called from several goroutines.
+func (future *WriteFuture) Error() error {
+	if future.done != nil {
+		future.err = <-future.done
+		future.done = nil
+	}
+	return future.err
+}
+
+// A client write waiting in the apply pipeline
+type pendingWrite struct {
+	command string
+	done    chan error
+
+	// Called once the outcome is known, before it is delivered
+	finish func()
+}
+
+// applyPipeline coalesces the concurrent client writes into log entries.
+// A single write is applied as it is, several are sent as one M entry and
+// each one gets the response of its own command. Writes are collected
+// until there are size of them, maxBatchBytes of commands, or linger has
+// passed since the first one.
+type applyPipeline struct {
+	raft    *raft.Raft
+	writes  chan *pendingWrite
+	size    int
+	linger  time.Duration
+	timeout time.Duration
+	logger  hclog.Logger
+
+	stop    chan struct{}
+	stopped chan struct{}
+	once    sync.Once
+}
+
+func newApplyPipeline(r *raft.Raft, size int, linger, timeout time.Duration,
+	logger hclog.Logger) *applyPipeline {
+	pipeline := &applyPipeline{raft: r, writes: make(chan *pendingWrite), size: max(size, 1),
+		linger: linger, timeout: timeout, logger: logger, stop: make(chan struct{}),
+		stopped: make(chan struct{})}
+	go pipeline.run()
+	return pipeline
+}
+
+// submit queues a command, finish is called when its outcome is known
+func (pipeline *applyPipeline) submit(command string, finish func()) *WriteFuture {
+	write := &pendingWrite{command: command, done: make(chan error, 1), finish: finish}
+	select {
+	case pipeline.writes <- write:
+	case <-pipeline.stop:
+		write.complete(raft.ErrRaftShutdown)
+	}
+	return &WriteFuture{done: write.done}
+}
+
+// close stops collecting writes, the batch being collected is still applied
+func (pipeline *applyPipeline) close() {
+	pipeline.once.Do(func() {
+		close(pipeline.stop)
+	})
+	<-pipeline.stopped
+}
+
+func (pipeline *applyPipeline) run() {
+	defer close(pipeline.stopped)
+	for {
+		select {
+		case write := <-pipeline.writes:
+			pipeline.apply(pipeline.collect(write))
+		case <-pipeline.stop:
+			return
+		}
+	}
+}
+
+// collect gathers the writes queued after first, waiting up to linger for
+// more of them
+func (pipeline *applyPipeline) collect(first *pendingWrite) []*pendingWrite {
+	batch := []*pendingWrite{first}
+	batchbytes := len(first.command)
+	var linger <-chan time.Time
+	if pipeline.linger > 0 {
+		timer := time.NewTimer(pipeline.linger)
+		defer timer.Stop()
+		linger = timer.C
+	}
+	for len(batch) < pipeline.size && batchbytes < maxBatchBytes {
+		if linger == nil {
+			select {
+			case write := <-pipeline.writes:
+				batch = append(batch, write)
+				batchbytes += len(write.command)
+				continue
+			default:
+				return batch
+			}
+		}
+		select {
+		case write := <-pipeline.writes:
+			batch = append(batch, write)
+			batchbytes += len(write.command)
+		case <-linger:
+			return batch
+		case <-pipeline.stop:
+			return batch
+		}
+	}
+	return batch
+}
+
+// apply appends the batch as one log entry and completes every write with
+// its own response once the entry is applied
+func (pipeline *applyPipeline) apply(batch []*pendingWrite) {
+	metrics.AddSample([]string{"apply", "batch_size"}, float32(len(batch)))
+	command := batch[0].command
+	if len(batch) > 1 {
+		var multi strings.Builder
+		multi.WriteString("M:")
+		for _, write := range batch {
+			fmt.Fprintf(&multi, "%d:%s", len(write.command), write.command)
+		}
+		command = multi.String()
+	}
+	future := pipeline.raft.Apply([]byte(command), pipeline.timeout)
+	go func() {
+		if err := leaderError(future.Error()); err != nil {
+			for _, write := range batch {
+				write.complete(err)
+			}
+			return
+		}
+		response := future.Response()
+		responses, ok := response.([]interface{})
+		if len(batch) == 1 || !ok || len(responses) != len(batch) {
+			if len(batch) > 1 && response == nil {
+				pipeline.logger.Error("Unexpected response to a batch", "writes", len(batch))
+				response = ErrIncorrectLog
+			}
+			responses = make([]interface{}, len(batch))
+			for i := range responses {
+				responses[i] = response
+			}
+		}
+		for i, write := range batch {
+			err, _ := responses[i].(error)
+			write.complete(err)
+		}
+	}()
+}
+
+func (write *pendingWrite) complete(err error) {
+	if write.finish != nil {
+		write.finish()
+	}
+	write.done <- err
+}
@@ -0,0 +1,148 @@
+package jsonstore_test
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/raft"
+	"github.com/nipuntalukdar/raftdemojson/jsonstore"
+	"github.com/nipuntalukdar/raftdemojson/testcluster"
+)
+
+func newLeader(t *testing.T) *jsonstore.RaftInterface {
+	cluster := testcluster.New(t, 1)
+	leader, err := cluster.WaitForLeader(10 * time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return leader.Raft
+}
+
+// submitAll submits the commands concurrently and returns their futures
+// along with the number of log entries used for them
+func submitAll(t *testing.T, raftin *jsonstore.RaftInterface, pipeline *jsonstore.ApplyPipeline,
+	commands []string) ([]*jsonstore.WriteFuture, uint64) {
+	before := raftin.Stats().LastLogIndex
+	futures := make([]*jsonstore.WriteFuture, len(commands))
+	var wg sync.WaitGroup
+	for i, command := range commands {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			futures[i] = pipeline.Submit(command)
+		}()
+	}
+	wg.Wait()
+	for _, future := range futures {
+		future.Error()
+	}
+	return futures, raftin.Stats().LastLogIndex - before
+}
+
+func addCommand(key, value string) string {
+	return fmt.Sprintf("A:%d:%d:%s%s", len(key), len(value), key, value)
+}
+
+func TestApplyPipelineCoalescesWrites(t *testing.T) {
+	raftin := newLeader(t)
+	pipeline := raftin.NewApplyPipeline(64, 50*time.Millisecond)
+	defer pipeline.Close()
+
+	// Each write gets its own outcome, the delete of a missing key fails
+	// while the writes sharing its entry succeed
+	var commands []string
+	for i := 0; i < 40; i++ {
+		commands = append(commands, addCommand(fmt.Sprintf("key%d", i), fmt.Sprintf("value%d", i)))
+	}
+	commands = append(commands, "D:missing")
+	futures, entries := submitAll(t, raftin, pipeline, commands)
+	if entries == 0 || entries >= uint64(len(commands)) {
+		t.Fatalf("Expected fewer entries than the %d writes, got %d", len(commands), entries)
+	}
+	for i, future := range futures {
+		err := future.Error()
+		if i == len(futures)-1 && !errors.Is(err, jsonstore.ErrKeyNotFound) {
+			t.Fatal("Expected ErrKeyNotFound, got", err)
+		}
+		if i < len(futures)-1 && err != nil {
+			t.Fatalf("Write %d failed: %v", i, err)
+		}
+	}
+	for i := 0; i < 40; i++ {
+		if value, err := raftin.Get(fmt.Sprintf("key%d", i)); err != nil || value != fmt.Sprintf("value%d", i) {
+			t.Fatalf("Unexpected value %q for key%d: %v", value, i, err)
+		}
+	}
+}
+
+func TestApplyPipelineSizeAndLinger(t *testing.T) {
+	raftin := newLeader(t)
+
+	// Batches are cut at size writes, the last one once linger has passed
+	pipeline := raftin.NewApplyPipeline(4, 300*time.Millisecond)
+	var commands []string
+	for i := 0; i < 10; i++ {
+		commands = append(commands, addCommand(fmt.Sprintf("size%d", i), "v"))
+	}
+	start := time.Now()
+	futures, entries := submitAll(t, raftin, pipeline, commands)
+	if entries != 3 {
+		t.Fatalf("Expected 3 entries of at most 4 writes, got %d", entries)
+	}
+	if elapsed := time.Since(start); elapsed < 300*time.Millisecond {
+		t.Fatalf("Expected the last batch to linger, took %s", elapsed)
+	}
+	for _, future := range futures {
+		if err := future.Error(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	pipeline.Close()
+
+	// Without linger only the writes already waiting are coalesced, one at
+	// a time they take an entry each
+	pipeline = raftin.NewApplyPipeline(64, 0)
+	defer pipeline.Close()
+	before := raftin.Stats().LastLogIndex
+	for i := 0; i < 5; i++ {
+		if err := pipeline.Submit(addCommand(fmt.Sprintf("nolinger%d", i), "v")).Error(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if entries := raftin.Stats().LastLogIndex - before; entries != 5 {
+		t.Fatalf("Expected 5 entries, got %d", entries)
+	}
+}
+
+func TestApplyPipelineClose(t *testing.T) {
+	raftin := newLeader(t)
+	pipeline := raftin.NewApplyPipeline(64, 5*time.Second)
+	futures := make(chan *jsonstore.WriteFuture, 3)
+	for i := 0; i < 3; i++ {
+		go func() {
+			futures <- pipeline.Submit(addCommand(fmt.Sprintf("close%d", i), "v"))
+		}()
+	}
+	// Submit returns once the pipeline has taken the write, the batch being
+	// collected is applied right away when closing
+	var collected []*jsonstore.WriteFuture
+	for i := 0; i < 3; i++ {
+		collected = append(collected, <-futures)
+	}
+	start := time.Now()
+	pipeline.Close()
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Fatalf("Close waited for the linger, took %s", elapsed)
+	}
+	for _, future := range collected {
+		if err := future.Error(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := pipeline.Submit(addCommand("late", "v")).Error(); err != raft.ErrRaftShutdown {
+		t.Fatal("Expected ErrRaftShutdown, got", err)
+	}
+}
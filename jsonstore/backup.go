@@ -0,0 +1,416 @@
+package jsonstore
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	hclog "github.com/hashicorp/go-hclog"
+	metrics "github.com/hashicorp/go-metrics/compat"
+	"github.com/hashicorp/raft"
+	"github.com/robfig/cron"
+)
+
+var (
+	ErrBackupsDisabled = errors.New("backups are not configured")
+	ErrBackupRunning   = errors.New("a backup is already running")
+	ErrBackupsStopped  = errors.New("backups are stopped")
+)
+
+// How often the age of the last backup is published
+const backupAgeInterval = "@every 15s"
+
+// Files of a backup are named backup-<UTC time>.json, or .json.gz when the
+// snapshot is compressed, with the manifest in backup-<UTC time>.manifest.json
+const (
+	backupPrefix     = "backup-"
+	backupTimeFormat = "20060102T150405.000Z"
+	manifestSuffix   = ".manifest.json"
+)
+
+// Settings of the scheduled backups, they are disabled when Dir is empty
+type BackupConfig struct {
+	// Directory the leader writes the backups to
+	Dir string `json:"dir,omitempty"`
+
+	// When to take a backup, a cron pattern with seconds like the rolling
+	// time pattern of the log file, or a descriptor like @hourly
+	Schedule string `json:"schedule"`
+
+	// Number of days and of hours for which the newest backup is kept
+	Daily  int `json:"daily"`
+	Hourly int `json:"hourly"`
+}
+
+// DefaultBackupConfig takes a backup every hour and keeps a week of daily
+// backups and a day of hourly ones
+func DefaultBackupConfig() BackupConfig {
+	return BackupConfig{Schedule: "0 0 * * * *", Daily: 7, Hourly: 24}
+}
+
+// Enabled tells whether backups are taken
+func (config BackupConfig) Enabled() bool {
+	return config.Dir != ""
+}
+
+// Validate checks the schedule and the retention of enabled backups
+func (config BackupConfig) Validate() error {
+	if !config.Enabled() {
+		return nil
+	}
+	if _, err := cron.Parse(config.Schedule); err != nil {
+		return fmt.Errorf("backup schedule %q: %w", config.Schedule, err)
+	}
+	if config.Daily < 0 || config.Hourly < 0 || config.Daily+config.Hourly == 0 {
+		return fmt.Errorf("backup daily and hourly must not be negative and keep at least one backup")
+	}
+	return nil
+}
+
+// BackupManifest describes a backup, it is written next to the backup file
+type BackupManifest struct {
+	// Name of the backup file in the backup directory
+	File string `json:"file"`
+
+	Created time.Time `json:"created"`
+
+	// Snapshot the backup is a copy of, with the index and term of the
+	// last log entry it includes
+	Snapshot string `json:"snapshot"`
+	Index    uint64 `json:"index"`
+	Term     uint64 `json:"term"`
+
+	// Number of keys in the backup
+	Keys int64 `json:"keys"`
+
+	// Size and SHA-256 of the backup file
+	Size     int64  `json:"size"`
+	Checksum string `json:"checksum"`
+
+	// Compression of the backup file, none if empty
+	Codec Compression `json:"codec,omitempty"`
+}
+
+// BackupStatus reports the scheduled backups of the node
+type BackupStatus struct {
+	Enabled  bool   `json:"enabled"`
+	Dir      string `json:"dir,omitempty"`
+	Schedule string `json:"schedule,omitempty"`
+
+	// A backup is being taken
+	Running bool `json:"running"`
+
+	// Next scheduled run, only the leader takes the backup
+	NextRun *time.Time `json:"nextrun,omitempty"`
+
+	LastAttempt *time.Time `json:"lastattempt,omitempty"`
+	LastSuccess *time.Time `json:"lastsuccess,omitempty"`
+
+	// Why the last attempt failed, empty if it succeeded
+	LastError string `json:"lasterror,omitempty"`
+
+	// Newest backup in the directory and number of backups kept
+	Latest *BackupManifest `json:"latest,omitempty"`
+	Kept   int             `json:"kept"`
+}
+
+// Backups takes a backup on the leader on a schedule and prunes the old
+// ones. A backup is a copy of a snapshot taken for it, so it can be
+// restored with SeedDataDir.
+type Backups struct {
+	raftin   *RaftInterface
+	config   BackupConfig
+	schedule cron.Schedule
+	logger   hclog.Logger
+	cron     *cron.Cron
+	running  sync.WaitGroup
+
+	lock    sync.Mutex
+	stopped bool
+	status  BackupStatus
+}
+
+// NewBackups prepares the backups of the node, which start with Start.
+// The status starts from the backups found in the directory.
+func NewBackups(raftin *RaftInterface, config BackupConfig, logger hclog.Logger) (*Backups, error) {
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+	backups := &Backups{raftin: raftin, config: config, logger: logger,
+		status: BackupStatus{Enabled: config.Enabled(), Dir: config.Dir}}
+	if !config.Enabled() {
+		return backups, nil
+	}
+	backups.status.Schedule = config.Schedule
+	backups.schedule, _ = cron.Parse(config.Schedule)
+	if err := os.MkdirAll(config.Dir, 0755); err != nil {
+		return nil, err
+	}
+	manifests, err := backups.manifests()
+	if err != nil {
+		return nil, err
+	}
+	backups.status.Kept = len(manifests)
+	if len(manifests) > 0 {
+		backups.status.Latest = &manifests[0]
+		created := manifests[0].Created
+		backups.status.LastSuccess = &created
+	}
+	return backups, nil
+}
+
+// Start runs the schedule
+func (backups *Backups) Start() {
+	if !backups.config.Enabled() {
+		return
+	}
+	backups.cron = cron.New()
+	backups.cron.Schedule(backups.schedule, cron.FuncJob(backups.scheduled))
+	backups.cron.AddFunc(backupAgeInterval, backups.publishAge)
+	backups.cron.Start()
+}
+
+// Stop ends the schedule and waits for a backup being taken. Backups
+// started after Stop fail with ErrBackupsStopped.
+func (backups *Backups) Stop() {
+	if backups.cron != nil {
+		backups.cron.Stop()
+	}
+	backups.lock.Lock()
+	backups.stopped = true
+	backups.lock.Unlock()
+	backups.running.Wait()
+}
+
+// scheduled takes a backup if the node is the leader
+func (backups *Backups) scheduled() {
+	if backups.raftin.raftinterface.State() != raft.Leader {
+		backups.logger.Debug("Skipping backup, not the leader")
+		return
+	}
+	backups.Run()
+}
+
+// Run takes a backup now and prunes the old ones. It fails with
+// LeaderDifferent on a follower.
+func (backups *Backups) Run() (*BackupManifest, error) {
+	if !backups.config.Enabled() {
+		return nil, ErrBackupsDisabled
+	}
+	backups.lock.Lock()
+	if backups.stopped {
+		backups.lock.Unlock()
+		return nil, ErrBackupsStopped
+	}
+	if backups.raftin.raftinterface.State() != raft.Leader {
+		backups.lock.Unlock()
+		return nil, LeaderDifferent
+	}
+	if backups.status.Running {
+		backups.lock.Unlock()
+		return nil, ErrBackupRunning
+	}
+	backups.status.Running = true
+	backups.running.Add(1)
+	backups.lock.Unlock()
+	defer backups.running.Done()
+
+	start := time.Now()
+	manifest, err := backups.take(start)
+	var kept int
+	if err == nil {
+		kept, err = backups.prune()
+	}
+
+	backups.lock.Lock()
+	defer backups.lock.Unlock()
+	backups.status.Running = false
+	backups.status.LastAttempt = &start
+	if err != nil {
+		backups.status.LastError = err.Error()
+		metrics.IncrCounter([]string{"backup", "failure"}, 1)
+		backups.logger.Error("Backup failed", "Error", err)
+		return nil, err
+	}
+	backups.status.LastError = ""
+	backups.status.LastSuccess = &manifest.Created
+	backups.status.Latest = manifest
+	backups.status.Kept = kept
+	metrics.IncrCounter([]string{"backup", "success"}, 1)
+	metrics.MeasureSince([]string{"backup", "duration"}, start)
+	metrics.SetGauge([]string{"backup", "size_bytes"}, float32(manifest.Size))
+	metrics.SetGauge([]string{"backup", "keys"}, float32(manifest.Keys))
+	metrics.SetGauge([]string{"backup", "last_success_age_seconds"}, 0)
+	backups.logger.Info("Backup taken", "file", manifest.File, "index", manifest.Index,
+		"term", manifest.Term, "keys", manifest.Keys, "size", manifest.Size)
+	return manifest, nil
+}
+
+// take copies a fresh snapshot to the backup directory and writes its
+// manifest, the backup is complete once the manifest exists
+func (backups *Backups) take(created time.Time) (*BackupManifest, error) {
+	snapshot, file, err := backups.raftin.ExportSnapshot(true)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	keys, err := backups.countKeys(snapshot.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	name := backupPrefix + created.UTC().Format(backupTimeFormat)
+	manifest := &BackupManifest{File: name + jsonSuffix, Created: created.UTC(), Snapshot: snapshot.ID,
+		Index: snapshot.Index, Term: snapshot.Term, Keys: keys, Codec: snapshot.Codec}
+	if snapshot.Codec == CompressionGzip {
+		manifest.File = name + gzipSuffix
+	}
+	hash := sha256.New()
+	var size countWriter
+	err = WriteFileAtomic(filepath.Join(backups.config.Dir, manifest.File), func(w io.Writer) error {
+		_, err := io.Copy(io.MultiWriter(w, hash, &size), file)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	manifest.Size = int64(size)
+	manifest.Checksum = "sha256:" + hex.EncodeToString(hash.Sum(nil))
+
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	err = WriteFileAtomic(filepath.Join(backups.config.Dir, name+manifestSuffix), func(w io.Writer) error {
+		_, err := w.Write(append(data, '\n'))
+		return err
+	})
+	if err != nil {
+		os.Remove(filepath.Join(backups.config.Dir, manifest.File))
+		return nil, err
+	}
+	return manifest, nil
+}
+
+// countKeys streams the state of a snapshot to count its keys
+func (backups *Backups) countKeys(id string) (int64, error) {
+	_, state, err := backups.raftin.snapshotstore.Open(id)
+	if err != nil {
+		return 0, err
+	}
+	defer state.Close()
+	var keys int64
+	err = decodeState(state, func(key, value string) { keys++ }, func(id, address string) {})
+	return keys, err
+}
+
+// manifests reads the manifests of the backup directory, newest first
+func (backups *Backups) manifests() ([]BackupManifest, error) {
+	entries, err := os.ReadDir(backups.config.Dir)
+	if err != nil {
+		return nil, err
+	}
+	var manifests []BackupManifest
+	for _, entry := range entries {
+		if !strings.HasPrefix(entry.Name(), backupPrefix) || !strings.HasSuffix(entry.Name(), manifestSuffix) {
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(backups.config.Dir, entry.Name()))
+		var manifest BackupManifest
+		if err == nil {
+			err = json.Unmarshal(data, &manifest)
+		}
+		if err != nil {
+			backups.logger.Warn("Skipping unreadable backup manifest", "file", entry.Name(), "Error", err)
+			continue
+		}
+		manifests = append(manifests, manifest)
+	}
+	sort.Slice(manifests, func(i, j int) bool {
+		return manifests[i].Created.After(manifests[j].Created)
+	})
+	return manifests, nil
+}
+
+// prune removes the backups which are not retained and returns the number
+// of backups kept
+func (backups *Backups) prune() (int, error) {
+	manifests, err := backups.manifests()
+	if err != nil {
+		return 0, err
+	}
+	keep := retainBackups(manifests, backups.config.Daily, backups.config.Hourly)
+	kept := 0
+	for i, manifest := range manifests {
+		if keep[i] {
+			kept++
+			continue
+		}
+		name := strings.TrimSuffix(strings.TrimSuffix(manifest.File, gzipSuffix), jsonSuffix)
+		// The manifest goes first, a backup without one is not listed
+		err := os.Remove(filepath.Join(backups.config.Dir, name+manifestSuffix))
+		if err == nil {
+			err = os.Remove(filepath.Join(backups.config.Dir, manifest.File))
+		}
+		if err != nil && !os.IsNotExist(err) {
+			return 0, err
+		}
+		backups.logger.Info("Removed backup", "file", manifest.File)
+	}
+	return kept, nil
+}
+
+// retainBackups tells which of the backups, newest first, are kept: the
+// newest backup of each of the last daily days and of each of the last
+// hourly hours that have a backup
+func retainBackups(manifests []BackupManifest, daily, hourly int) []bool {
+	keep := make([]bool, len(manifests))
+	days := make(map[string]bool)
+	hours := make(map[time.Time]bool)
+	for i, manifest := range manifests {
+		created := manifest.Created.UTC()
+		day := created.Format(time.DateOnly)
+		if !days[day] && len(days) < daily {
+			days[day] = true
+			keep[i] = true
+		}
+		hour := created.Truncate(time.Hour)
+		if !hours[hour] && len(hours) < hourly {
+			hours[hour] = true
+			keep[i] = true
+		}
+	}
+	return keep
+}
+
+// publishAge sets the gauge with the seconds since the last successful
+// backup
+func (backups *Backups) publishAge() {
+	backups.lock.Lock()
+	last := backups.status.LastSuccess
+	backups.lock.Unlock()
+	if last != nil {
+		metrics.SetGauge([]string{"backup", "last_success_age_seconds"}, float32(time.Since(*last).Seconds()))
+	}
+}
+
+// Status reports the backups of the node
+func (backups *Backups) Status() BackupStatus {
+	backups.lock.Lock()
+	defer backups.lock.Unlock()
+	status := backups.status
+	if backups.schedule != nil {
+		next := backups.schedule.Next(time.Now())
+		status.NextRun = &next
+	}
+	return status
+}
@@ -0,0 +1,69 @@
+package jsonstore
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	hclog "github.com/hashicorp/go-hclog"
+)
+
+func TestRetainBackups(t *testing.T) {
+	now := time.Date(2026, 3, 10, 12, 30, 0, 0, time.UTC)
+	created := []time.Time{
+		now,                        // hourly and daily for the 10th
+		now.Add(-10 * time.Minute), // same hour, dropped
+		now.Add(-time.Hour),        // hourly
+		now.Add(-2 * time.Hour),    // beyond the hourly ones, dropped
+		now.Add(-24 * time.Hour),   // daily for the 9th
+		now.Add(-25 * time.Hour),   // same day, dropped
+		now.Add(-48 * time.Hour),   // daily for the 8th
+		now.Add(-72 * time.Hour),   // beyond the daily ones, dropped
+	}
+	var manifests []BackupManifest
+	for _, c := range created {
+		manifests = append(manifests, BackupManifest{Created: c})
+	}
+	keep := retainBackups(manifests, 3, 2)
+	expected := []bool{true, false, true, false, true, false, true, false}
+	for i := range expected {
+		if keep[i] != expected[i] {
+			t.Fatalf("Backup %d created at %s: expected kept %t", i, created[i], expected[i])
+		}
+	}
+}
+
+func TestBackupConfigValidate(t *testing.T) {
+	config := DefaultBackupConfig()
+	if err := config.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	config.Dir = "backups"
+	if err := config.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	config.Schedule = "every hour"
+	if err := config.Validate(); err == nil {
+		t.Fatal("Expected an invalid schedule to be refused")
+	}
+	config = DefaultBackupConfig()
+	config.Dir = "backups"
+	config.Daily, config.Hourly = 0, 0
+	if err := config.Validate(); err == nil {
+		t.Fatal("Expected a retention keeping nothing to be refused")
+	}
+}
+
+func TestBackupAfterStop(t *testing.T) {
+	config := DefaultBackupConfig()
+	config.Dir = t.TempDir()
+	backups, err := NewBackups(nil, config, hclog.NewNullLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+	backups.Start()
+	backups.Stop()
+	if _, err := backups.Run(); !errors.Is(err, ErrBackupsStopped) {
+		t.Fatal("Expected ErrBackupsStopped, got", err)
+	}
+}
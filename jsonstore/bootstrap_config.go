@@ -1,26 +1,82 @@
 package jsonstore
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"github.com/hashicorp/raft"
-	"io"
 	"os"
+	"sort"
 )
 
+var (
+	ErrClusterIDMismatch = errors.New("node has state from a different cluster")
+)
+
+// How a node bootstraps the cluster when it has no RAFT state yet
+type BootstrapMode string
+
+const (
+	// Bootstrap with all the servers in the configuration file
+	BootstrapFromConfig BootstrapMode = "config"
+
+	// Bootstrap a cluster with only this node, the seed. Other nodes
+	// join it later.
+	BootstrapSelf BootstrapMode = "self"
+
+	// Do not bootstrap, the node waits to be added to an existing cluster
+	BootstrapNone BootstrapMode = "none"
+)
+
+// Stable store key for the id of the cluster
+const clusterIDKey = "ClusterID"
+
+// BootstrapConfig reads the servers to bootstrap the cluster with
 func BootstrapConfig(configfile string) (*raft.Configuration, error) {
 
 	var configuration raft.Configuration
 
-	file, err := os.OpenFile(configfile, os.O_RDONLY, 0600)
+	data, err := os.ReadFile(configfile)
 	if err != nil {
-		panic(err)
+		return nil, fmt.Errorf("reading bootstrap configuration: %w", err)
 	}
-	data, err := io.ReadAll(file)
 	err = json.Unmarshal(data, &configuration.Servers)
 	if err != nil {
-		panic(err)
+		return nil, fmt.Errorf("invalid bootstrap configuration %s: %w", configfile, err)
+	}
+	if len(configuration.Servers) == 0 {
+		return nil, fmt.Errorf("bootstrap configuration %s has no servers", configfile)
 	}
 
 	return &configuration, nil
 
 }
+
+// ConfigurationClusterID derives a cluster id from the bootstrap
+// configuration, so that nodes bootstrapping from the same configuration
+// file get the same id without coordinating
+func ConfigurationClusterID(configuration *raft.Configuration) string {
+	var servers []string
+	for _, server := range configuration.Servers {
+		servers = append(servers, fmt.Sprintf("%s@%s", server.ID, server.Address))
+	}
+	sort.Strings(servers)
+	hash := sha256.New()
+	for _, server := range servers {
+		hash.Write([]byte(server))
+		hash.Write([]byte{0})
+	}
+	return hex.EncodeToString(hash.Sum(nil))[:16]
+}
+
+// checkClusterID fails if the stable store belongs to a cluster other than
+// clusterid
+func checkClusterID(stablestore *JsonStableStore, clusterid string) error {
+	existing, err := stablestore.Get([]byte(clusterIDKey))
+	if err != nil || clusterid == "" || string(existing) == clusterid {
+		return nil
+	}
+	return fmt.Errorf("%w: found %s, expected %s", ErrClusterIDMismatch, existing, clusterid)
+}
@@ -1,7 +1,13 @@
 package jsonstore
 
 import (
+	"errors"
+	"io"
+	"path/filepath"
 	"testing"
+
+	hclog "github.com/hashicorp/go-hclog"
+	"github.com/hashicorp/raft"
 )
 
 func TestBootstrap(t *testing.T) {
@@ -13,3 +19,70 @@ func TestBootstrap(t *testing.T) {
 	t.Log(*configuration)
 
 }
+
+func TestBootstrapClusterIDMismatch(t *testing.T) {
+	dir := t.TempDir()
+	stablestore, err := NewJsonStableStore(filepath.Join(dir, "stablestore.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	stablestore.Set([]byte(clusterIDKey), []byte("cluster1"))
+
+	_, err = NewRaftInterfaceWithOptions(RaftOptions{ConfigFile: "../sampleconfig/config.json",
+		LogStoreFile: filepath.Join(dir, "logstore.json"), StableStoreFile: filepath.Join(dir, "stablestore.json"),
+		SnapshotDir: filepath.Join(dir, "snapshot"), Transport: "127.0.0.1:0", ServerID: "id1",
+		ClusterID: "cluster2"}, hclog.NewNullLogger(), io.Discard)
+	if !errors.Is(err, ErrClusterIDMismatch) {
+		t.Fatal("Expected ErrClusterIDMismatch, got", err)
+	}
+}
+
+func TestBootstrapRecordsClusterID(t *testing.T) {
+	dir := t.TempDir()
+	options := RaftOptions{ConfigFile: "../sampleconfig/config.json", DataDir: dir,
+		Transport: "127.0.0.1:0", ServerID: "id1", ClusterID: "cluster1"}
+
+	// Bootstrap the stores the way older versions did, without a cluster id
+	datadir, err := OpenDataDir(dir, "id1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	configuration, err := BootstrapConfig(options.ConfigFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	logstore, err := NewJsonLogStore(datadir.LogStoreDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	stablestore, err := NewJsonStableStore(datadir.StableStoreFile())
+	if err != nil {
+		t.Fatal(err)
+	}
+	snapshotstore, err := NewJsonSnapshotStore(datadir.SnapshotDir(), 1, hclog.NewNullLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+	conf := raft.DefaultConfig()
+	conf.LocalID = "id1"
+	_, transport := raft.NewInmemTransport("")
+	if err := raft.BootstrapCluster(conf, logstore, stablestore, snapshotstore, transport,
+		*configuration); err != nil {
+		t.Fatal(err)
+	}
+	logstore.Close()
+	stablestore.Close()
+	datadir.Close()
+
+	raftin, err := NewRaftInterfaceWithOptions(options, hclog.NewNullLogger(), io.Discard)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer raftin.Shutdown()
+	if id := raftin.ClusterID(); id != "cluster1" {
+		t.Fatalf("Expected cluster id cluster1, got %q", id)
+	}
+	if id := raftin.datadir.Meta().ClusterID; id != "cluster1" {
+		t.Fatalf("Expected cluster id cluster1 in the data directory, got %q", id)
+	}
+}
@@ -0,0 +1,174 @@
+package jsonstore
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/hashicorp/raft"
+)
+
+// Key-values per RAFT entry of an import, by default and at most. An entry
+// is also cut when its key-values reach maxBatchBytes.
+const (
+	DefaultImportBatch = 1000
+	MaxImportBatch     = 10000
+	maxBatchBytes      = 1 << 20
+)
+
+// A key and its value, one record of an import or an export
+type KeyValue struct {
+	Key   string `json:"key"`
+	Value string `json:"value"`
+}
+
+// ImportProgress tells how far an import went
+type ImportProgress struct {
+	// Records of the stream committed, counting the skipped ones. An
+	// import which failed is resumed by skipping them.
+	Committed int64 `json:"committed"`
+
+	// Key-values added by this import and RAFT entries used for them
+	Added   int64 `json:"added"`
+	Entries int64 `json:"entries"`
+}
+
+// AddKVs adds key-values with a single RAFT entry. It will return an error
+// if the node serving the request is not the current leader.
+func (raftin *RaftInterface) AddKVs(kvs []KeyValue) error {
+	if len(kvs) == 0 {
+		return nil
+	}
+	if err := raftin.beginWrite(); err != nil {
+		return err
+	}
+	defer raftin.endWrite()
+	var cmd strings.Builder
+	cmd.WriteString("B:")
+	for _, kv := range kvs {
+		fmt.Fprintf(&cmd, "%d:%d:%s%s", len(kv.Key), len(kv.Value), kv.Key, kv.Value)
+	}
+	future := raftin.raftinterface.Apply([]byte(cmd.String()), raftin.applytimeout)
+	err := future.Error()
+	if err == raft.ErrNotLeader {
+		err = LeaderDifferent
+	}
+	return err
+}
+
+// Import reads key-values from r, given as JSON Lines or as a JSON array of
+// {"key": ..., "value": ...} records, and adds them with entries of batch
+// key-values. The first skip records are skipped. progress is called after
+// every committed entry. On failure the progress tells where to resume.
+func (raftin *RaftInterface) Import(r io.Reader, skip int64, batch int,
+	progress func(ImportProgress)) (ImportProgress, error) {
+	if batch <= 0 {
+		batch = DefaultImportBatch
+	}
+	batch = min(batch, MaxImportBatch)
+	status := ImportProgress{Committed: skip}
+	records, err := newRecordReader(r)
+	if err != nil {
+		return status, err
+	}
+	var pending []KeyValue
+	pendingbytes := 0
+	flush := func() error {
+		if err := raftin.AddKVs(pending); err != nil {
+			return err
+		}
+		status.Committed += int64(len(pending))
+		status.Added += int64(len(pending))
+		status.Entries++
+		pending, pendingbytes = pending[:0], 0
+		progress(status)
+		return nil
+	}
+	for read := int64(0); ; read++ {
+		record, err := records.next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return status, fmt.Errorf("record %d: %w", read+1, err)
+		}
+		if read < skip {
+			continue
+		}
+		pending = append(pending, record)
+		pendingbytes += len(record.Key) + len(record.Value)
+		if len(pending) >= batch || pendingbytes >= maxBatchBytes {
+			if err := flush(); err != nil {
+				return status, err
+			}
+		}
+	}
+	if len(pending) > 0 {
+		if err := flush(); err != nil {
+			return status, err
+		}
+	}
+	return status, nil
+}
+
+// recordReader decodes the records of a JSON array or of JSON Lines
+type recordReader struct {
+	decoder *json.Decoder
+	array   bool
+}
+
+func newRecordReader(r io.Reader) (*recordReader, error) {
+	buffered := bufio.NewReader(r)
+	for {
+		c, err := buffered.Peek(1)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if c[0] != ' ' && c[0] != '\t' && c[0] != '\r' && c[0] != '\n' {
+			break
+		}
+		buffered.ReadByte()
+	}
+	records := &recordReader{decoder: json.NewDecoder(buffered)}
+	if c, err := buffered.Peek(1); err == nil && c[0] == '[' {
+		records.array = true
+		records.decoder.Token()
+	}
+	return records, nil
+}
+
+// next returns the next record, io.EOF after the last one
+func (records *recordReader) next() (KeyValue, error) {
+	var record struct {
+		Key   *string `json:"key"`
+		Value string  `json:"value"`
+	}
+	if records.array && !records.decoder.More() {
+		if err := expectDelim(records.decoder, ']'); err != nil {
+			return KeyValue{}, err
+		}
+		if _, err := records.decoder.Token(); err != io.EOF {
+			return KeyValue{}, errors.New("data after the array")
+		}
+		return KeyValue{}, io.EOF
+	}
+	if err := records.decoder.Decode(&record); err != nil {
+		return KeyValue{}, err
+	}
+	if record.Key == nil {
+		return KeyValue{}, errors.New("missing key")
+	}
+	return KeyValue{Key: *record.Key, Value: record.Value}, nil
+}
+
+// Export writes the keys starting with prefix as JSON Lines from a
+// consistent view of this node's FSM, which followers may have behind
+func (raftin *RaftInterface) Export(w io.Writer, prefix string) (int64, error) {
+	return raftin.fsm.Export(w, prefix)
+}
@@ -0,0 +1,47 @@
+package jsonstore
+
+import (
+	"io"
+	"strings"
+	"testing"
+)
+
+func readRecords(input string) ([]KeyValue, error) {
+	records, err := newRecordReader(strings.NewReader(input))
+	if err != nil {
+		return nil, err
+	}
+	var read []KeyValue
+	for {
+		record, err := records.next()
+		if err == io.EOF {
+			return read, nil
+		}
+		if err != nil {
+			return read, err
+		}
+		read = append(read, record)
+	}
+}
+
+func TestRecordReader(t *testing.T) {
+	for _, input := range []string{
+		"{\"key\": \"a\", \"value\": \"1\"}\n{\"key\": \"b\", \"value\": \"2\"}\n",
+		"\n [{\"key\": \"a\", \"value\": \"1\"},\n {\"key\": \"b\", \"value\": \"2\"}]\n",
+	} {
+		read, err := readRecords(input)
+		if err != nil || len(read) != 2 || read[1] != (KeyValue{Key: "b", Value: "2"}) {
+			t.Fatalf("Unexpected records %v from %q: %v", read, input, err)
+		}
+	}
+	for _, input := range []string{
+		`{"value": "1"}`,
+		`[{"key": "a", "value": "1"}`,
+		`[{"key": "a", "value": "1"}] {}`,
+		`{"key": "a", "value": 1}`,
+	} {
+		if _, err := readRecords(input); err == nil {
+			t.Fatalf("Expected %q to be refused", input)
+		}
+	}
+}
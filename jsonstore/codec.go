@@ -0,0 +1,189 @@
+package jsonstore
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+
+	msgpack "github.com/hashicorp/go-msgpack/v2/codec"
+)
+
+// Codec of the documents of the log store and the stable store
+type Codec string
+
+const (
+	CodecJSON    Codec = "json"
+	CodecMsgpack Codec = "msgpack"
+)
+
+// Validate checks the codec, empty means json
+func (codec Codec) Validate() error {
+	switch codec {
+	case "", CodecJSON, CodecMsgpack:
+		return nil
+	}
+	return fmt.Errorf("unknown codec %q, expected %s or %s", codec, CodecJSON, CodecMsgpack)
+}
+
+// Names the header line of the store files
+const storeFormat = "raftdemojson-store"
+
+// storeHeader is the first line of the log store and stable store files,
+// the document encoded with Codec follows it. Files written before the
+// header was added hold only a JSON document.
+type storeHeader struct {
+	Format string `json:"format"`
+	Codec  Codec  `json:"codec"`
+
+	// Set for the files holding a part of a store, e.g. log segments
+	Kind string `json:"kind,omitempty"`
+}
+
+// headerPrefix starts the header line, the fields are written in order
+var headerPrefix = []byte(`{"format":"` + storeFormat + `"`)
+
+// Store documents are written with the msgpack format which tells strings
+// from bytes. msgpackInspect reads any of them with string keyed maps so
+// that they can be printed as JSON.
+var (
+	msgpackHandle  = &msgpack.MsgpackHandle{WriteExt: true}
+	msgpackInspect = &msgpack.MsgpackHandle{WriteExt: true,
+		BasicHandle: msgpack.BasicHandle{DecodeOptions: msgpack.DecodeOptions{
+			MapType: reflect.TypeOf(map[string]interface{}(nil))}}}
+)
+
+func (codec Codec) marshal(v interface{}) ([]byte, error) {
+	if codec == CodecMsgpack {
+		var data []byte
+		err := msgpack.NewEncoderBytes(&data, msgpackHandle).Encode(v)
+		return data, err
+	}
+	return json.Marshal(v)
+}
+
+func (codec Codec) unmarshal(data []byte, v interface{}) error {
+	if codec == CodecMsgpack {
+		return msgpack.NewDecoderBytes(data, msgpackHandle).Decode(v)
+	}
+	return json.Unmarshal(data, v)
+}
+
+// encodeStore lays out a store file, the header line then the document.
+// The whole file is compressed if asked.
+func encodeStore(codec Codec, compression Compression, document []byte) ([]byte, error) {
+	if codec == "" {
+		codec = CodecJSON
+	}
+	header, err := json.Marshal(storeHeader{Format: storeFormat, Codec: codec})
+	if err != nil {
+		return nil, err
+	}
+	var out bytes.Buffer
+	writer := compressor(&out, compression)
+	writer.Write(header)
+	writer.Write([]byte{'\n'})
+	writer.Write(document)
+	if err = writer.Close(); err != nil {
+		return nil, err
+	}
+	return out.Bytes(), nil
+}
+
+// readStore reads a store file and returns its document, the codec of the
+// document and the compression of the file. The document is nil if the
+// file does not exist.
+func readStore(path string) ([]byte, Codec, Compression, error) {
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, "", "", nil
+	}
+	if err != nil {
+		return nil, "", "", err
+	}
+	defer file.Close()
+	reader, compression, err := Decompress(file)
+	if err != nil {
+		return nil, "", "", fmt.Errorf("%s: %w", path, err)
+	}
+	data, err := io.ReadAll(reader)
+	if err != nil {
+		return nil, "", "", fmt.Errorf("%s: %w", path, err)
+	}
+	if !bytes.HasPrefix(data, headerPrefix) {
+		return data, CodecJSON, compression, nil
+	}
+	line, document, _ := bytes.Cut(data, []byte{'\n'})
+	var header storeHeader
+	if err = json.Unmarshal(line, &header); err != nil {
+		return nil, "", "", fmt.Errorf("%s: invalid header: %w", path, err)
+	}
+	if err = header.Codec.Validate(); err != nil {
+		return nil, "", "", fmt.Errorf("%s: %w", path, err)
+	}
+	return document, header.Codec, compression, nil
+}
+
+// inspectStore prints the header and the document of a store file read
+// from reader as indented JSON, whatever the codec of the document
+func inspectStore(w io.Writer, reader *bufio.Reader) error {
+	line, err := reader.ReadBytes('\n')
+	if err != nil {
+		return fmt.Errorf("invalid header: %w", err)
+	}
+	var header storeHeader
+	if err = json.Unmarshal(line, &header); err != nil {
+		return fmt.Errorf("invalid header: %w", err)
+	}
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	if err = encoder.Encode(header); err != nil {
+		return err
+	}
+	if header.Kind == segmentKind {
+		return inspectSegment(encoder, header.Codec, reader)
+	}
+	switch header.Codec {
+	case CodecJSON:
+		indented := newJSONFormatter(w, "  ", 0)
+		if _, err = io.Copy(indented, reader); err != nil {
+			return err
+		}
+		indented.w.WriteByte('\n')
+		return indented.Flush()
+	case CodecMsgpack:
+		var document interface{}
+		if err = msgpack.NewDecoder(reader, msgpackInspect).Decode(&document); err != nil {
+			return err
+		}
+		encoder.SetEscapeHTML(false)
+		return encoder.Encode(document)
+	}
+	return header.Codec.Validate()
+}
+
+// inspectSegment prints the entries of a log segment one after the other
+func inspectSegment(encoder *json.Encoder, codec Codec, reader io.Reader) error {
+	content, err := io.ReadAll(reader)
+	if err != nil {
+		return err
+	}
+	for len(content) > 0 {
+		n := recordLength(codec, content)
+		if n == 0 {
+			return fmt.Errorf("torn entry at the end")
+		}
+		log, err := decodeRecord(codec, content[:n])
+		if err != nil {
+			return err
+		}
+		if err = encoder.Encode(log); err != nil {
+			return err
+		}
+		content = content[n:]
+	}
+	return nil
+}
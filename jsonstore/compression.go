@@ -0,0 +1,65 @@
+package jsonstore
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+)
+
+// Compression of the files written by the stores
+type Compression string
+
+const (
+	CompressionNone Compression = "none"
+	CompressionGzip Compression = "gzip"
+)
+
+// Validate checks the compression, empty means none
+func (compression Compression) Validate() error {
+	switch compression {
+	case "", CompressionNone, CompressionGzip:
+		return nil
+	}
+	return fmt.Errorf("unknown compression %q, expected %s or %s", compression, CompressionNone,
+		CompressionGzip)
+}
+
+// gzipMagic starts every gzip stream
+var gzipMagic = []byte{0x1f, 0x8b}
+
+// compressor wraps w so that what is written gets compressed. Close
+// finishes the stream without closing w.
+func compressor(w io.Writer, compression Compression) io.WriteCloser {
+	if compression == CompressionGzip {
+		return gzip.NewWriter(w)
+	}
+	return nopWriteCloser{w}
+}
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error {
+	return nil
+}
+
+// Decompress returns a reader for data that may be compressed, which is
+// told by its first bytes. JSON text never starts like a gzip stream.
+func Decompress(r io.Reader) (io.Reader, Compression, error) {
+	buffered := bufio.NewReader(r)
+	magic, err := buffered.Peek(len(gzipMagic))
+	if err != nil && err != io.EOF {
+		return nil, "", err
+	}
+	if !bytes.Equal(magic, gzipMagic) {
+		return buffered, CompressionNone, nil
+	}
+	reader, err := gzip.NewReader(buffered)
+	if err != nil {
+		return nil, "", err
+	}
+	return reader, CompressionGzip, nil
+}
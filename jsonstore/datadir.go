@@ -0,0 +1,207 @@
+package jsonstore
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+var (
+	ErrServerIDMismatch = errors.New("data directory belongs to a different server")
+	ErrDataDirLocked    = errors.New("data directory is used by another process")
+)
+
+// Version of the data directory layout written by this code. Version 2
+// keeps the log store in segments under logstore/ instead of logstore.json.
+const DataDirVersion = 2
+
+const (
+	metaFile        = "meta.json"
+	lockFile        = "LOCK"
+	logStoreDir     = "logstore"
+	stableStoreFile = "stablestore.json"
+
+	// Log store file of the versions before the log segments
+	legacyLogStoreFile = "logstore.json"
+)
+
+// Contents of the metadata file of a data directory
+type DataDirMeta struct {
+	// Layout version of the data directory
+	Version int `json:"version"`
+
+	// Id of the server owning the directory
+	ServerID string `json:"server_id"`
+
+	// Id of the cluster, empty until the node bootstraps or joins
+	ClusterID string `json:"cluster_id,omitempty"`
+}
+
+// DataDir keeps all the state of a node under one directory:
+//
+//	meta.json         version, server id and cluster id
+//	LOCK              held with an exclusive lock while the node runs
+//	logstore/         RAFT log entries, in segment files
+//	stablestore.json  RAFT stable store
+//	snapshots/        snapshots
+type DataDir struct {
+	path string
+	lock *os.File
+	meta DataDirMeta
+	mu   sync.Mutex
+}
+
+// OpenDataDir creates or opens the data directory of serverid and locks it.
+// It fails if another process holds the lock or if the directory belongs to
+// another server.
+func OpenDataDir(path string, serverid string) (*DataDir, error) {
+	if err := os.MkdirAll(path, 0755); err != nil {
+		return nil, err
+	}
+	lock, err := os.OpenFile(filepath.Join(path, lockFile), os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+	if err = lockExclusive(lock); err != nil {
+		lock.Close()
+		return nil, fmt.Errorf("%w: %s: %v", ErrDataDirLocked, path, err)
+	}
+	datadir := &DataDir{path: path, lock: lock}
+	if err = datadir.load(serverid); err != nil {
+		datadir.Close()
+		return nil, err
+	}
+	if err = datadir.upgrade(); err != nil {
+		datadir.Close()
+		return nil, err
+	}
+	return datadir, nil
+}
+
+// upgrade moves the log store file of a version 1 directory to the path of
+// the segments, which the log store turns into segments when it opens it,
+// and records the new version so that older versions refuse the directory
+func (datadir *DataDir) upgrade() error {
+	if datadir.meta.Version >= DataDirVersion {
+		return nil
+	}
+	legacy := filepath.Join(datadir.path, legacyLogStoreFile)
+	_, err := os.Stat(legacy)
+	if err == nil {
+		if _, err = os.Stat(datadir.LogStoreDir()); os.IsNotExist(err) {
+			err = os.Rename(legacy, datadir.LogStoreDir())
+		}
+	} else if os.IsNotExist(err) {
+		err = nil
+	}
+	if err != nil {
+		return err
+	}
+	datadir.meta.Version = DataDirVersion
+	return datadir.save()
+}
+
+// load reads the metadata file, or writes it for a new directory
+func (datadir *DataDir) load(serverid string) error {
+	data, err := os.ReadFile(filepath.Join(datadir.path, metaFile))
+	if os.IsNotExist(err) {
+		datadir.meta = DataDirMeta{Version: DataDirVersion, ServerID: serverid}
+		return datadir.save()
+	}
+	if err != nil {
+		return err
+	}
+	if err = json.Unmarshal(data, &datadir.meta); err != nil {
+		return fmt.Errorf("invalid %s in %s: %w", metaFile, datadir.path, err)
+	}
+	if datadir.meta.Version > DataDirVersion {
+		return fmt.Errorf("data directory %s has version %d, only up to %d is supported",
+			datadir.path, datadir.meta.Version, DataDirVersion)
+	}
+	if datadir.meta.ServerID != serverid {
+		return fmt.Errorf("%w: %s belongs to %s, not %s", ErrServerIDMismatch, datadir.path,
+			datadir.meta.ServerID, serverid)
+	}
+	return nil
+}
+
+// save writes the metadata file through a temporary file
+func (datadir *DataDir) save() error {
+	data, err := json.MarshalIndent(datadir.meta, "", "  ")
+	if err != nil {
+		return err
+	}
+	return WriteFileAtomic(filepath.Join(datadir.path, metaFile), func(w io.Writer) error {
+		_, err := w.Write(data)
+		return err
+	})
+}
+
+// Meta returns the contents of the metadata file
+func (datadir *DataDir) Meta() DataDirMeta {
+	datadir.mu.Lock()
+	defer datadir.mu.Unlock()
+	return datadir.meta
+}
+
+// SetClusterID records the cluster of the node. It fails if the directory
+// already belongs to a different cluster.
+func (datadir *DataDir) SetClusterID(clusterid string) error {
+	datadir.mu.Lock()
+	defer datadir.mu.Unlock()
+	if err := datadir.checkClusterID(clusterid); err != nil || clusterid == "" ||
+		datadir.meta.ClusterID == clusterid {
+		return err
+	}
+	datadir.meta.ClusterID = clusterid
+	return datadir.save()
+}
+
+// verifyClusterID fails if the directory belongs to a cluster other than
+// clusterid
+func (datadir *DataDir) verifyClusterID(clusterid string) error {
+	datadir.mu.Lock()
+	defer datadir.mu.Unlock()
+	return datadir.checkClusterID(clusterid)
+}
+
+func (datadir *DataDir) checkClusterID(clusterid string) error {
+	if clusterid == "" || datadir.meta.ClusterID == "" || datadir.meta.ClusterID == clusterid {
+		return nil
+	}
+	return fmt.Errorf("%w: %s has %s, expected %s", ErrClusterIDMismatch, datadir.path,
+		datadir.meta.ClusterID, clusterid)
+}
+
+// Directory of the RAFT log store
+func (datadir *DataDir) LogStoreDir() string {
+	return filepath.Join(datadir.path, logStoreDir)
+}
+
+// Path of the RAFT stable store
+func (datadir *DataDir) StableStoreFile() string {
+	return filepath.Join(datadir.path, stableStoreFile)
+}
+
+// Directory for the snapshot store, which keeps the snapshots in a
+// snapshots subdirectory
+func (datadir *DataDir) SnapshotDir() string {
+	return datadir.path
+}
+
+// Close releases the lock
+func (datadir *DataDir) Close() error {
+	datadir.mu.Lock()
+	defer datadir.mu.Unlock()
+	if datadir.lock == nil {
+		return nil
+	}
+	unlock(datadir.lock)
+	err := datadir.lock.Close()
+	datadir.lock = nil
+	return err
+}
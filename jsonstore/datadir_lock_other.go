@@ -0,0 +1,13 @@
+//go:build !unix
+
+package jsonstore
+
+import "os"
+
+// lockExclusive does nothing on platforms without flock, the data
+// directory is not protected against a second process there
+func lockExclusive(file *os.File) error {
+	return nil
+}
+
+func unlock(file *os.File) {}
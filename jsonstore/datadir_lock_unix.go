@@ -0,0 +1,17 @@
+//go:build unix
+
+package jsonstore
+
+import (
+	"os"
+	"syscall"
+)
+
+// lockExclusive takes an exclusive lock on file without waiting
+func lockExclusive(file *os.File) error {
+	return syscall.Flock(int(file.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+}
+
+func unlock(file *os.File) {
+	syscall.Flock(int(file.Fd()), syscall.LOCK_UN)
+}
@@ -0,0 +1,101 @@
+package jsonstore
+
+import (
+	"errors"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	hclog "github.com/hashicorp/go-hclog"
+)
+
+func TestDataDir(t *testing.T) {
+	dir := t.TempDir()
+	datadir, err := OpenDataDir(dir, "id1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := OpenDataDir(dir, "id1"); !errors.Is(err, ErrDataDirLocked) {
+		t.Fatal("Expected ErrDataDirLocked, got", err)
+	}
+	if err := datadir.SetClusterID("cluster1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := datadir.SetClusterID("cluster2"); !errors.Is(err, ErrClusterIDMismatch) {
+		t.Fatal("Expected ErrClusterIDMismatch, got", err)
+	}
+	datadir.Close()
+
+	if _, err := OpenDataDir(dir, "id2"); !errors.Is(err, ErrServerIDMismatch) {
+		t.Fatal("Expected ErrServerIDMismatch, got", err)
+	}
+	datadir, err = OpenDataDir(dir, "id1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	meta := datadir.Meta()
+	if meta.Version != DataDirVersion || meta.ServerID != "id1" || meta.ClusterID != "cluster1" {
+		t.Fatalf("Unexpected metadata %+v", meta)
+	}
+	datadir.Close()
+
+	// The lock is released when a node fails to start
+	_, err = NewRaftInterfaceWithOptions(RaftOptions{ConfigFile: "../sampleconfig/config.json", DataDir: dir,
+		Transport: "127.0.0.1:0", ServerID: "id1", ClusterID: "cluster2"}, hclog.NewNullLogger(), io.Discard)
+	if !errors.Is(err, ErrClusterIDMismatch) {
+		t.Fatal("Expected ErrClusterIDMismatch, got", err)
+	}
+	datadir, err = OpenDataDir(dir, "id1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	datadir.Close()
+}
+
+func TestDataDirStartFailure(t *testing.T) {
+	dir := t.TempDir()
+	busy, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer busy.Close()
+
+	// The stores are open when binding the transport fails
+	options := RaftOptions{ConfigFile: "../sampleconfig/config.json", DataDir: dir,
+		Transport: busy.Addr().String(), ServerID: "id1"}
+	if _, err := NewRaftInterfaceWithOptions(options, hclog.NewNullLogger(), io.Discard); err == nil {
+		t.Fatal("Expected the start to fail on a busy address")
+	}
+	options.Transport = "127.0.0.1:0"
+	raftin, err := NewRaftInterfaceWithOptions(options, hclog.NewNullLogger(), io.Discard)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := raftin.Shutdown(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDataDirUpgrade(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, metaFile), []byte(`{"version":1,"server_id":"id1"}`), 0600)
+	os.WriteFile(filepath.Join(dir, legacyLogStoreFile), []byte(`{}`), 0600)
+	datadir, err := OpenDataDir(dir, "id1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer datadir.Close()
+	if meta := datadir.Meta(); meta.Version != DataDirVersion {
+		t.Fatalf("Expected version %d, got %+v", DataDirVersion, meta)
+	}
+	if _, err := os.Stat(filepath.Join(dir, legacyLogStoreFile)); !os.IsNotExist(err) {
+		t.Fatal("Expected the log store file to be moved, got", err)
+	}
+	data, _ := os.ReadFile(filepath.Join(dir, metaFile))
+	if !strings.Contains(string(data), `"version": 2`) {
+		t.Fatalf("Expected the new version in %s", data)
+	}
+}
@@ -0,0 +1,111 @@
+package jsonstore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/hashicorp/raft"
+)
+
+var (
+	ErrDraining = errors.New("node is draining")
+)
+
+// DrainStatus tells whether a drained node can be stopped
+type DrainStatus struct {
+	// Drain mode is on, client writes are refused
+	Draining bool `json:"draining"`
+
+	// The node is still the leader
+	Leader bool `json:"leader"`
+
+	// Client writes which have not completed yet
+	Inflight int64 `json:"inflight"`
+
+	// The node is draining, is not the leader and has no writes in flight
+	SafeToStop bool `json:"safetostop"`
+}
+
+// LeadershipTransfer hands over the leadership to the most up to date
+// follower. It can only be called on the leader.
+func (raftin *RaftInterface) LeadershipTransfer() error {
+	return leaderError(raftin.raftinterface.LeadershipTransfer().Error())
+}
+
+// LeadershipTransferToServer hands over the leadership to the server id
+func (raftin *RaftInterface) LeadershipTransferToServer(id string) error {
+	configfuture := raftin.raftinterface.GetConfiguration()
+	if err := configfuture.Error(); err != nil {
+		return err
+	}
+	for _, server := range configfuture.Configuration().Servers {
+		if string(server.ID) == id {
+			future := raftin.raftinterface.LeadershipTransferToServer(server.ID, server.Address)
+			return leaderError(future.Error())
+		}
+	}
+	return fmt.Errorf("server %s: %w", id, ErrKeyNotFound)
+}
+
+// Drain prepares the node to be stopped. Client writes are refused from
+// now on and, if the node is the leader, the leadership is handed over to
+// another server.
+func (raftin *RaftInterface) Drain() (DrainStatus, error) {
+	raftin.draining.Store(true)
+	raftin.logger.Info("Draining")
+	var err error
+	if raftin.raftinterface.State() == raft.Leader {
+		err = raftin.LeadershipTransfer()
+		if err != nil {
+			raftin.logger.Error("Leadership transfer failed", "Error", err)
+		}
+	}
+	return raftin.DrainStatus(), err
+}
+
+// Undrain accepts client writes again
+func (raftin *RaftInterface) Undrain() {
+	raftin.draining.Store(false)
+	raftin.logger.Info("Drain stopped")
+}
+
+// DrainStatus reports the progress of draining
+func (raftin *RaftInterface) DrainStatus() DrainStatus {
+	status := DrainStatus{Draining: raftin.draining.Load(),
+		Leader:   raftin.raftinterface.State() == raft.Leader,
+		Inflight: raftin.inflight.Load()}
+	status.SafeToStop = status.Draining && !status.Leader && status.Inflight == 0
+	return status
+}
+
+// WaitInflight waits until the client writes in flight have completed
+func (raftin *RaftInterface) WaitInflight(ctx context.Context) error {
+	for raftin.inflight.Load() > 0 {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+	return nil
+}
+
+// beginWrite counts a client write as in flight unless the node is
+// draining. A draining follower sends the client to the leader.
+func (raftin *RaftInterface) beginWrite() error {
+	raftin.inflight.Add(1)
+	if raftin.draining.Load() {
+		raftin.inflight.Add(-1)
+		if raftin.raftinterface.State() == raft.Leader {
+			return ErrDraining
+		}
+		return LeaderDifferent
+	}
+	return nil
+}
+
+func (raftin *RaftInterface) endWrite() {
+	raftin.inflight.Add(-1)
+}
@@ -0,0 +1,205 @@
+package jsonstore
+
+import (
+	"sync"
+	"time"
+
+	hclog "github.com/hashicorp/go-hclog"
+	metrics "github.com/hashicorp/go-metrics/compat"
+	"github.com/hashicorp/raft"
+)
+
+// Types of the events published by a node
+const (
+	EventLeaderChange     = "leader-change"
+	EventPeer             = "peer"
+	EventFailedHeartbeat  = "failed-heartbeat"
+	EventResumedHeartbeat = "resumed-heartbeat"
+	EventRequestVote      = "request-vote"
+)
+
+// Observations kept while the events are being published
+const observationBuffer = 64
+
+// Event is a change seen by the RAFT node. Only the fields of its type are
+// set.
+type Event struct {
+	Type string    `json:"type"`
+	Time time.Time `json:"time"`
+
+	// New leader for leader-change, empty when the leader is lost
+	Leader        string `json:"leader,omitempty"`
+	LeaderAddress string `json:"leaderaddress,omitempty"`
+
+	// Server added or removed for peer, server not reachable for
+	// failed-heartbeat and reachable again for resumed-heartbeat
+	Peer        string `json:"peer,omitempty"`
+	PeerAddress string `json:"peeraddress,omitempty"`
+	Removed     bool   `json:"removed,omitempty"`
+
+	// Last time the leader heard from the peer, for failed-heartbeat
+	LastContact *time.Time `json:"lastcontact,omitempty"`
+
+	// Candidate asking for a vote and its term, for request-vote
+	Candidate          string `json:"candidate,omitempty"`
+	Term               uint64 `json:"term,omitempty"`
+	LeadershipTransfer bool   `json:"leadershiptransfer,omitempty"`
+}
+
+// logArgs returns the fields of the event for a structured log line
+func (event Event) logArgs() []interface{} {
+	args := []interface{}{"type", event.Type}
+	switch event.Type {
+	case EventLeaderChange:
+		args = append(args, "leader", event.Leader, "leader-address", event.LeaderAddress)
+	case EventPeer:
+		args = append(args, "peer", event.Peer, "peer-address", event.PeerAddress, "removed", event.Removed)
+	case EventFailedHeartbeat:
+		args = append(args, "peer", event.Peer, "last-contact", event.LastContact)
+	case EventResumedHeartbeat:
+		args = append(args, "peer", event.Peer)
+	case EventRequestVote:
+		args = append(args, "candidate", event.Candidate, "term", event.Term,
+			"leadership-transfer", event.LeadershipTransfer)
+	}
+	return args
+}
+
+// eventFromObservation converts the observations having an event type
+func eventFromObservation(observation *raft.Observation) (Event, bool) {
+	event := Event{Time: time.Now()}
+	switch data := observation.Data.(type) {
+	case raft.LeaderObservation:
+		event.Type = EventLeaderChange
+		event.Leader = string(data.LeaderID)
+		event.LeaderAddress = string(data.LeaderAddr)
+	case raft.PeerObservation:
+		event.Type = EventPeer
+		event.Peer = string(data.Peer.ID)
+		event.PeerAddress = string(data.Peer.Address)
+		event.Removed = data.Removed
+	case raft.FailedHeartbeatObservation:
+		event.Type = EventFailedHeartbeat
+		event.Peer = string(data.PeerID)
+		lastcontact := data.LastContact
+		event.LastContact = &lastcontact
+	case raft.ResumedHeartbeatObservation:
+		event.Type = EventResumedHeartbeat
+		event.Peer = string(data.PeerID)
+	case raft.RequestVoteRequest:
+		event.Type = EventRequestVote
+		event.Candidate = string(data.ID)
+		if event.Candidate == "" {
+			event.Candidate = string(data.Addr)
+		}
+		event.Term = data.Term
+		event.LeadershipTransfer = data.LeadershipTransfer
+	default:
+		return event, false
+	}
+	return event, true
+}
+
+// eventHub registers an observer on the RAFT node and publishes its
+// observations to the subscribers
+type eventHub struct {
+	raft        *raft.Raft
+	logger      hclog.Logger
+	observer    *raft.Observer
+	done        chan struct{}
+	stopped     sync.WaitGroup
+	lock        sync.Mutex
+	subscribers map[chan Event]struct{}
+	closed      bool
+}
+
+func newEventHub(raftobj *raft.Raft, logger hclog.Logger) *eventHub {
+	hub := &eventHub{raft: raftobj, logger: logger, done: make(chan struct{}),
+		subscribers: make(map[chan Event]struct{})}
+	observations := make(chan raft.Observation, observationBuffer)
+	hub.observer = raft.NewObserver(observations, false, func(observation *raft.Observation) bool {
+		_, ok := eventFromObservation(observation)
+		return ok
+	})
+	raftobj.RegisterObserver(hub.observer)
+	hub.stopped.Add(1)
+	go hub.run(observations)
+	return hub
+}
+
+func (hub *eventHub) run(observations chan raft.Observation) {
+	defer hub.stopped.Done()
+	for {
+		select {
+		case <-hub.done:
+			return
+		case observation := <-observations:
+			if event, ok := eventFromObservation(&observation); ok {
+				hub.logger.Info("Raft event", event.logArgs()...)
+				hub.publish(event)
+			}
+		}
+	}
+}
+
+// publish hands the event to every subscriber. A subscriber which is not
+// keeping up misses the event.
+func (hub *eventHub) publish(event Event) {
+	hub.lock.Lock()
+	defer hub.lock.Unlock()
+	for subscriber := range hub.subscribers {
+		select {
+		case subscriber <- event:
+		default:
+			metrics.IncrCounter([]string{"events", "dropped"}, 1)
+		}
+	}
+}
+
+func (hub *eventHub) subscribe(buffer int) (<-chan Event, func()) {
+	events := make(chan Event, buffer)
+	hub.lock.Lock()
+	defer hub.lock.Unlock()
+	if hub.closed {
+		close(events)
+		return events, func() {}
+	}
+	hub.subscribers[events] = struct{}{}
+	return events, func() {
+		hub.lock.Lock()
+		defer hub.lock.Unlock()
+		if _, ok := hub.subscribers[events]; ok {
+			delete(hub.subscribers, events)
+			close(events)
+		}
+	}
+}
+
+// close stops the observer and closes the channels of the subscribers
+func (hub *eventHub) close() {
+	hub.lock.Lock()
+	if hub.closed {
+		hub.lock.Unlock()
+		return
+	}
+	hub.closed = true
+	hub.lock.Unlock()
+
+	hub.raft.DeregisterObserver(hub.observer)
+	close(hub.done)
+	hub.stopped.Wait()
+
+	hub.lock.Lock()
+	defer hub.lock.Unlock()
+	for subscriber := range hub.subscribers {
+		delete(hub.subscribers, subscriber)
+		close(subscriber)
+	}
+}
+
+// Subscribe returns a channel receiving the events of the node, with room
+// for buffer events. Events are dropped while the channel is full. The
+// channel is closed by the returned function or when the node shuts down.
+func (raftin *RaftInterface) Subscribe(buffer int) (<-chan Event, func()) {
+	return raftin.events.subscribe(buffer)
+}
@@ -0,0 +1,25 @@
+package jsonstore
+
+import (
+	"time"
+
+	hclog "github.com/hashicorp/go-hclog"
+)
+
+// ApplyPipeline lets the tests of jsonstore_test drive an apply pipeline
+// with their own settings
+type ApplyPipeline = applyPipeline
+
+// NewApplyPipeline starts an apply pipeline writing to the raft node of
+// raftin, next to the one of the node
+func (raftin *RaftInterface) NewApplyPipeline(size int, linger time.Duration) *ApplyPipeline {
+	return newApplyPipeline(raftin.raftinterface, size, linger, raftin.applytimeout, hclog.NewNullLogger())
+}
+
+func (pipeline *applyPipeline) Submit(command string) *WriteFuture {
+	return pipeline.submit(command, nil)
+}
+
+func (pipeline *applyPipeline) Close() {
+	pipeline.close()
+}
@@ -0,0 +1,95 @@
+package jsonstore
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/hashicorp/raft"
+)
+
+// Health is the state of a node as seen by the health checks
+type Health struct {
+	// RAFT state, Leader, Follower, Candidate or Shutdown
+	State string `json:"state"`
+
+	// Id and RAFT address of the leader known to the node
+	Leader        string `json:"leader,omitempty"`
+	LeaderAddress string `json:"leaderaddress,omitempty"`
+
+	// Index of the last committed log entry known to the node
+	CommitIndex uint64 `json:"commitindex"`
+
+	// Index of the last log entry applied to the FSM
+	AppliedIndex uint64 `json:"appliedindex"`
+
+	// Time since the last contact with the leader, for followers
+	LastContact *Duration `json:"lastcontact,omitempty"`
+
+	// Files can be created in the directories of the stores
+	StoresWritable bool `json:"storeswritable"`
+
+	// The node has a leader, has applied the committed entries, can write
+	// its stores and is not draining
+	Ready bool `json:"ready"`
+
+	// Why the node is not ready
+	Problems []string `json:"problems,omitempty"`
+}
+
+// Health checks whether the node can serve requests
+func (raftin *RaftInterface) Health() Health {
+	state := raftin.raftinterface.State()
+	address, id := raftin.raftinterface.LeaderWithID()
+	health := Health{State: state.String(), Leader: string(id), LeaderAddress: string(address),
+		AppliedIndex: raftin.raftinterface.AppliedIndex()}
+	health.CommitIndex, _ = strconv.ParseUint(raftin.raftinterface.Stats()["commit_index"], 10, 64)
+	if state != raft.Leader {
+		if lastcontact := raftin.raftinterface.LastContact(); !lastcontact.IsZero() {
+			since := Duration(time.Since(lastcontact))
+			health.LastContact = &since
+		}
+	}
+
+	if address == "" {
+		health.Problems = append(health.Problems, "no known leader")
+	}
+	if health.AppliedIndex < health.CommitIndex {
+		health.Problems = append(health.Problems, fmt.Sprintf("fsm is behind, applied %d of %d",
+			health.AppliedIndex, health.CommitIndex))
+	}
+	health.StoresWritable = true
+	for _, dir := range []string{raftin.logstorefile, filepath.Dir(raftin.stablestore.jsonfilepath),
+		raftin.snapshotdir} {
+		if err := CheckWritable(dir); err != nil {
+			health.StoresWritable = false
+			health.Problems = append(health.Problems, err.Error())
+		}
+	}
+	if raftin.draining.Load() {
+		health.Problems = append(health.Problems, "node is draining")
+	}
+	health.Ready = len(health.Problems) == 0
+	return health
+}
+
+// IsLeader tells whether this node is the leader
+func (raftin *RaftInterface) IsLeader() bool {
+	return raftin.raftinterface.State() == raft.Leader
+}
+
+// CheckWritable creates the directory if needed and checks that files can
+// be created in it
+func CheckWritable(dir string) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("directory %s is not writable: %w", dir, err)
+	}
+	file, err := os.CreateTemp(dir, ".writable")
+	if err != nil {
+		return fmt.Errorf("directory %s is not writable: %w", dir, err)
+	}
+	file.Close()
+	return os.Remove(file.Name())
+}
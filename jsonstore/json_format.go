@@ -0,0 +1,241 @@
+package jsonstore
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// jsonFormatter copies JSON text byte by byte, dropping the whitespace
+// outside strings like json.Compact. With an indent it lays the text out
+// like json.Indent instead, starting at the given depth. Documents of any
+// size go through it without being held in memory.
+type jsonFormatter struct {
+	w        *bufio.Writer
+	indent   string
+	depth    int
+	instring bool
+	escaped  bool
+	opened   bool
+}
+
+func newJSONFormatter(w io.Writer, indent string, depth int) *jsonFormatter {
+	return &jsonFormatter{w: bufio.NewWriter(w), indent: indent, depth: depth}
+}
+
+func (f *jsonFormatter) Write(data []byte) (int, error) {
+	for _, c := range data {
+		if f.instring {
+			f.w.WriteByte(c)
+			switch {
+			case f.escaped:
+				f.escaped = false
+			case c == '\\':
+				f.escaped = true
+			case c == '"':
+				f.instring = false
+			}
+			continue
+		}
+		switch c {
+		case ' ', '\t', '\n', '\r':
+			continue
+		}
+		if f.opened {
+			f.opened = false
+			// Empty objects and arrays stay on one line
+			if c == '}' || c == ']' {
+				f.depth--
+				f.w.WriteByte(c)
+				continue
+			}
+			f.newline()
+		}
+		switch c {
+		case '"':
+			f.instring = true
+			f.w.WriteByte(c)
+		case '{', '[':
+			f.w.WriteByte(c)
+			f.depth++
+			f.opened = true
+		case '}', ']':
+			f.depth--
+			f.newline()
+			f.w.WriteByte(c)
+		case ',':
+			f.w.WriteByte(c)
+			f.newline()
+		case ':':
+			f.w.WriteByte(c)
+			if f.indent != "" {
+				f.w.WriteByte(' ')
+			}
+		default:
+			f.w.WriteByte(c)
+		}
+	}
+	return len(data), nil
+}
+
+func (f *jsonFormatter) newline() {
+	if f.indent != "" {
+		f.w.WriteByte('\n')
+		f.w.WriteString(strings.Repeat(f.indent, f.depth))
+	}
+}
+
+// Flush writes out the buffered text and returns the first write error
+func (f *jsonFormatter) Flush() error {
+	return f.w.Flush()
+}
+
+// skipValue reads the next value from decoder one token at a time
+func skipValue(decoder *json.Decoder) error {
+	depth := 0
+	for {
+		token, err := decoder.Token()
+		if err != nil {
+			return err
+		}
+		switch token {
+		case json.Delim('{'), json.Delim('['):
+			depth++
+		case json.Delim('}'), json.Delim(']'):
+			depth--
+		}
+		if depth == 0 {
+			return nil
+		}
+	}
+}
+
+// expectDelim reads the next token, which must be delim
+func expectDelim(decoder *json.Decoder, delim json.Delim) error {
+	token, err := decoder.Token()
+	if err != nil {
+		return err
+	}
+	if token != delim {
+		return fmt.Errorf("expected %v, got %v", delim, token)
+	}
+	return nil
+}
+
+// countWriter counts the bytes written to it
+type countWriter int64
+
+func (cw *countWriter) Write(data []byte) (int, error) {
+	*cw += countWriter(len(data))
+	return len(data), nil
+}
+
+// valueReader reads one JSON object or array from r and stops at its end,
+// leaving what follows it unread
+type valueReader struct {
+	r        *bufio.Reader
+	depth    int
+	instring bool
+	escaped  bool
+	done     bool
+}
+
+func newValueReader(r io.Reader) *valueReader {
+	return &valueReader{r: bufio.NewReader(r)}
+}
+
+func (vr *valueReader) Read(data []byte) (int, error) {
+	n := 0
+	for n < len(data) && !vr.done {
+		c, err := vr.r.ReadByte()
+		if err == io.EOF {
+			return n, io.ErrUnexpectedEOF
+		}
+		if err != nil {
+			return n, err
+		}
+		data[n] = c
+		n++
+		switch {
+		case vr.instring:
+			switch {
+			case vr.escaped:
+				vr.escaped = false
+			case c == '\\':
+				vr.escaped = true
+			case c == '"':
+				vr.instring = false
+			}
+		case c == '"':
+			vr.instring = true
+		case c == '{', c == '[':
+			vr.depth++
+		case c == '}', c == ']':
+			vr.depth--
+			vr.done = vr.depth == 0
+		case c == ' ', c == '\t', c == '\n', c == '\r':
+		default:
+			if vr.depth == 0 {
+				return n, fmt.Errorf("expected an object or an array, got %q", c)
+			}
+		}
+	}
+	if n == 0 && vr.done {
+		return 0, io.EOF
+	}
+	return n, nil
+}
+
+// holdLastWriter writes to w all but the last byte written to it, which is
+// only written by Release. A reader of w cannot see the end of the text
+// before the writer has decided it is valid.
+type holdLastWriter struct {
+	w    io.Writer
+	last []byte
+}
+
+func (hw *holdLastWriter) Write(data []byte) (int, error) {
+	if len(data) == 0 {
+		return 0, nil
+	}
+	if _, err := hw.w.Write(hw.last); err != nil {
+		return 0, err
+	}
+	if _, err := hw.w.Write(data[:len(data)-1]); err != nil {
+		return 0, err
+	}
+	hw.last = append(hw.last[:0], data[len(data)-1])
+	return len(data), nil
+}
+
+// Release writes the held byte
+func (hw *holdLastWriter) Release() error {
+	_, err := hw.w.Write(hw.last)
+	hw.last = hw.last[:0]
+	return err
+}
+
+// Inspect writes the JSON text read from r to w indented, decompressing it
+// if needed. It works for the files of all the stores, the documents of the
+// stable store and the entries of log segments are printed after their
+// header whatever their codec.
+func Inspect(w io.Writer, r io.Reader) error {
+	reader, _, err := Decompress(r)
+	if err != nil {
+		return err
+	}
+	buffered := bufio.NewReader(reader)
+	if prefix, _ := buffered.Peek(len(headerPrefix)); bytes.Equal(prefix, headerPrefix) {
+		return inspectStore(w, buffered)
+	}
+	reader = buffered
+	indented := newJSONFormatter(w, "  ", 0)
+	if _, err := io.Copy(indented, reader); err != nil {
+		return err
+	}
+	indented.w.WriteByte('\n')
+	return indented.Flush()
+}
@@ -1,14 +1,19 @@
 package jsonstore
 
 import (
+	"bufio"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"io"
 	"strconv"
 	"strings"
 	"sync"
+	"time"
 
 	hclog "github.com/hashicorp/go-hclog"
+	iradix "github.com/hashicorp/go-immutable-radix"
+	metrics "github.com/hashicorp/go-metrics/compat"
 	"github.com/hashicorp/raft"
 )
 
@@ -17,92 +22,383 @@ var (
 	ErrIncorrectLog = errors.New("Incorrect log")
 )
 
+// Fsm is the replicated state machine. Besides the key-values it keeps
+// the http listener address of every server so that any node can redirect
+// clients to the leader.
+//
+// Log entries are plain strings:
+//
+//	A:<key length>:<value length>:<key><value>   add a key-value
+//	B:<key length>:<value length>:<key><value>...
+//	                                             add key-values at once
+//	D:<key>                                      delete a key
+//	H:<id length>:<address length>:<id><address> set the http address of a server
+//	R:<id>                                       remove the http address of a server
+//	M:<command length>:<command>...              apply commands at once, each one
+//	                                             with its own response
+//
+// The key-values are kept in an immutable radix tree. Every batch of
+// entries replaces the tree, so a snapshot only has to keep the current
+// tree. Snapshots are laid out as:
+//
+//	{"keyvals": {"<key>": "<value>", ...}, "httplisteners": {"<id>": "<address>", ...}}
 type Fsm struct {
-	kv     map[string]string
-	lock   *sync.Mutex
-	logger hclog.Logger
+	kv            *iradix.Tree
+	httplisteners map[string]string
+	lock          *sync.Mutex
+	logger        hclog.Logger
+
+	// Total size of the values
+	valuebytes int64
 }
 
 func NewFsm(logger hclog.Logger) (fsm *Fsm, err error) {
-	kv := make(map[string]string)
-	fsm = &Fsm{kv: kv, lock: &sync.Mutex{}, logger: logger}
+	fsm = &Fsm{kv: iradix.New(), httplisteners: make(map[string]string), lock: &sync.Mutex{}, logger: logger}
 	err = nil
 	return
 }
 
 func (fsm *Fsm) Apply(log *raft.Log) interface{} {
-	ds := string(log.Data)
+	return fsm.ApplyBatch([]*raft.Log{log})[0]
+}
+
+// ApplyBatch applies committed entries in order with a single new tree, so
+// readers never see part of a batch. The response of an entry is nil or an
+// error, or the responses of its commands for an M entry.
+func (fsm *Fsm) ApplyBatch(logs []*raft.Log) []interface{} {
+	fsm.lock.Lock()
+	defer fsm.lock.Unlock()
+	responses := make([]interface{}, len(logs))
+	txn := fsm.kv.Txn()
+	for i, log := range logs {
+		// Configuration changes are kept by RAFT itself
+		if log.Type != raft.LogCommand {
+			continue
+		}
+		ds := string(log.Data)
+		if first, second, found := strings.Cut(ds, ":"); found && first == "M" {
+			commands, ok := splitCommands(second)
+			if !ok {
+				responses[i] = ErrIncorrectLog
+				continue
+			}
+			multi := make([]interface{}, len(commands))
+			for j, command := range commands {
+				multi[j] = fsm.applyCommand(txn, command)
+			}
+			responses[i] = multi
+			continue
+		}
+		responses[i] = fsm.applyCommand(txn, ds)
+	}
+	fsm.kv = txn.Commit()
+	fsm.emitSize()
+	return responses
+}
+
+// applyCommand applies a command to txn, the lock must be held
+func (fsm *Fsm) applyCommand(txn *iradix.Txn, ds string) interface{} {
 	first, second, found := strings.Cut(ds, ":")
 	if !found || second == "" {
 		return ErrIncorrectLog
 	}
-	if first == "A" {
-		kvs := strings.SplitN(second, ":", 3)
-		if len(kvs) != 3 {
+	start := time.Now()
+	var op string
+	switch first {
+	case "A":
+		op = "add"
+		key, value, ok := splitPair(second)
+		if !ok {
 			return ErrIncorrectLog
 		}
-		len1, err := strconv.Atoi(kvs[0])
-		if err != nil {
+		fsm.insert(txn, key, value)
+	case "B":
+		op = "batch"
+		pairs, ok := splitPairs(second)
+		if !ok {
 			return ErrIncorrectLog
 		}
-		len2, err := strconv.Atoi(kvs[1])
-		if err != nil || len(kvs[2]) != (len1+len2) {
+		for _, pair := range pairs {
+			fsm.insert(txn, pair[0], pair[1])
+		}
+	case "D":
+		op = "delete"
+		value, exists := txn.Delete([]byte(second))
+		if !exists {
+			fsm.logger.Info("Delete", "Key not found", second)
+			return ErrKeyNotFound
+		}
+		fsm.logger.Debug("Delete", "Found Key", second)
+		fsm.valuebytes -= int64(len(value.(string)))
+	case "H":
+		op = "httplistener"
+		id, address, ok := splitPair(second)
+		if !ok {
 			return ErrIncorrectLog
 		}
-		fsm.add(kvs[2][:len1], kvs[2][len1:])
-	} else if first == "D" {
-		return fsm.delete(second)
-	} else {
+		fsm.httplisteners[id] = address
+	case "R":
+		op = "removehttplistener"
+		delete(fsm.httplisteners, second)
+	default:
 		return ErrIncorrectLog
 	}
+	metrics.MeasureSinceWithLabels([]string{"fsm", "apply"}, start, []metrics.Label{{Name: "op", Value: op}})
 	return nil
 }
 
+// insert adds a key-value to txn and keeps the size of the values, the
+// lock must be held
+func (fsm *Fsm) insert(txn *iradix.Txn, key, value string) {
+	old, _ := txn.Insert([]byte(key), value)
+	fsm.valuebytes += int64(len(value))
+	if old != nil {
+		fsm.valuebytes -= int64(len(old.(string)))
+	}
+}
+
+// splitPair parses <length1>:<length2>:<first><second>
+func splitPair(data string) (string, string, bool) {
+	kvs := strings.SplitN(data, ":", 3)
+	if len(kvs) != 3 {
+		return "", "", false
+	}
+	len1, err := strconv.Atoi(kvs[0])
+	if err != nil {
+		return "", "", false
+	}
+	len2, err := strconv.Atoi(kvs[1])
+	if err != nil || len1 < 0 || len2 < 0 || len(kvs[2]) != (len1+len2) {
+		return "", "", false
+	}
+	return kvs[2][:len1], kvs[2][len1:], true
+}
+
+// splitPairs parses the pairs of a batch, each one laid out like for
+// splitPair
+func splitPairs(data string) ([][2]string, bool) {
+	var pairs [][2]string
+	for data != "" {
+		lengths := strings.SplitN(data, ":", 3)
+		if len(lengths) != 3 {
+			return nil, false
+		}
+		len1, err := strconv.Atoi(lengths[0])
+		if err != nil {
+			return nil, false
+		}
+		len2, err := strconv.Atoi(lengths[1])
+		if err != nil || len1 < 0 || len2 < 0 || len(lengths[2]) < len1+len2 {
+			return nil, false
+		}
+		rest := lengths[2]
+		pairs = append(pairs, [2]string{rest[:len1], rest[len1 : len1+len2]})
+		data = rest[len1+len2:]
+	}
+	return pairs, len(pairs) > 0
+}
+
+// splitCommands parses the commands of an M entry, each one laid out as
+// <length>:<command>. M entries are not nested.
+func splitCommands(data string) ([]string, bool) {
+	var commands []string
+	for data != "" {
+		length, rest, found := strings.Cut(data, ":")
+		if !found {
+			return nil, false
+		}
+		n, err := strconv.Atoi(length)
+		if err != nil || n <= 0 || len(rest) < n || strings.HasPrefix(rest[:n], "M:") {
+			return nil, false
+		}
+		commands = append(commands, rest[:n])
+		data = rest[n:]
+	}
+	return commands, len(commands) > 0
+}
+
+// Snapshot captures the current tree. The copying happens in Persist,
+// without holding the lock.
 func (fsm *Fsm) Snapshot() (raft.FSMSnapshot, error) {
+	defer metrics.MeasureSince([]string{"fsm", "snapshot"}, time.Now())
 	fsm.lock.Lock()
 	defer fsm.lock.Unlock()
-	data, err := json.Marshal(fsm.kv)
-	if err == nil {
-		return NewSnapshot(data), nil
+	httplisteners := make(map[string]string, len(fsm.httplisteners))
+	for id, address := range fsm.httplisteners {
+		httplisteners[id] = address
 	}
-	return NewSnapshot(nil), err
+	return &Snapshot{keyvals: fsm.kv, httplisteners: httplisteners}, nil
 }
 
+// Restore decodes the snapshot entry by entry into a new tree, then
+// replaces the state. Compressed snapshots are decompressed.
 func (fsm *Fsm) Restore(inp io.ReadCloser) error {
+	defer inp.Close()
+	reader, _, err := Decompress(inp)
+	if err != nil {
+		return err
+	}
+	txn := iradix.New().Txn()
+	httplisteners := make(map[string]string)
+	var valuebytes int64
+	addkv := func(key, value string) {
+		txn.Insert([]byte(key), value)
+		valuebytes += int64(len(value))
+	}
+	err = decodeState(reader, addkv, func(id, address string) {
+		httplisteners[id] = address
+	})
+	if err != nil {
+		return err
+	}
 	fsm.lock.Lock()
 	defer fsm.lock.Unlock()
-	data, err := io.ReadAll(inp)
-	if err != nil {
+	fsm.kv = txn.Commit()
+	fsm.httplisteners = httplisteners
+	fsm.valuebytes = valuebytes
+	fsm.emitSize()
+	return nil
+}
+
+// decodeState reads a snapshot with a streaming decoder. Snapshots taken
+// before the http listeners were replicated hold only the map of
+// key-values, so keyvals and httplisteners are sections only when they
+// hold objects.
+func decodeState(inp io.Reader, addkv func(key, value string), addlistener func(id, address string)) error {
+	decoder := json.NewDecoder(inp)
+	if err := expectDelim(decoder, '{'); err != nil {
 		return err
 	}
-	return json.Unmarshal(data, &fsm.kv)
+	for decoder.More() {
+		name, err := stringToken(decoder)
+		if err != nil {
+			return err
+		}
+		token, err := decoder.Token()
+		if err != nil {
+			return err
+		}
+		switch token := token.(type) {
+		case string:
+			addkv(name, token)
+		case json.Delim:
+			if token != '{' || (name != "keyvals" && name != "httplisteners") {
+				return fmt.Errorf("snapshot: unexpected %v for %q", token, name)
+			}
+			add := addkv
+			if name == "httplisteners" {
+				add = addlistener
+			}
+			if err := decodeObject(decoder, add); err != nil {
+				return err
+			}
+		case nil:
+			// Sections saved as null
+			if name != "keyvals" && name != "httplisteners" {
+				return fmt.Errorf("snapshot: null value for %q", name)
+			}
+		default:
+			return fmt.Errorf("snapshot: unexpected %v for %q", token, name)
+		}
+	}
+	return expectDelim(decoder, '}')
 }
 
-func (fsm *Fsm) add(key string, value string) {
-	fsm.lock.Lock()
-	defer fsm.lock.Unlock()
-	fsm.kv[key] = value
+// decodeObject reads the string members of an object whose opening brace
+// has been read
+func decodeObject(decoder *json.Decoder, add func(name, value string)) error {
+	for decoder.More() {
+		name, err := stringToken(decoder)
+		if err != nil {
+			return err
+		}
+		value, err := stringToken(decoder)
+		if err != nil {
+			return err
+		}
+		add(name, value)
+	}
+	return expectDelim(decoder, '}')
+}
+
+func stringToken(decoder *json.Decoder) (string, error) {
+	token, err := decoder.Token()
+	if err != nil {
+		return "", err
+	}
+	value, ok := token.(string)
+	if !ok {
+		return "", fmt.Errorf("snapshot: expected a string, got %v", token)
+	}
+	return value, nil
+}
+
+// emitSize sets the gauges for the number of keys and the size of the
+// values, the lock must be held
+func (fsm *Fsm) emitSize() {
+	metrics.SetGauge([]string{"fsm", "keys"}, float32(fsm.kv.Len()))
+	metrics.SetGauge([]string{"fsm", "value_bytes"}, float32(fsm.valuebytes))
 }
 
 func (fsm *Fsm) Get(key string) (value string, err error) {
+	found, exists := fsm.tree().Get([]byte(key))
+	if !exists {
+		return "", ErrKeyNotFound
+	}
+	return found.(string), nil
+}
+
+// tree returns the current tree of key-values, which is not modified by
+// later writes
+func (fsm *Fsm) tree() *iradix.Tree {
 	fsm.lock.Lock()
 	defer fsm.lock.Unlock()
-	value, exists := fsm.kv[key]
-	if !exists {
-		err = ErrKeyNotFound
+	return fsm.kv
+}
+
+// Scan returns the keys starting with prefix along with their values.
+// At most limit keys, in sorted order, are returned unless limit is 0.
+func (fsm *Fsm) Scan(prefix string, limit int) map[string]string {
+	found := make(map[string]string)
+	fsm.tree().Root().WalkPrefix([]byte(prefix), func(key []byte, value interface{}) bool {
+		found[string(key)] = value.(string)
+		return limit > 0 && len(found) >= limit
+	})
+	return found
+}
+
+// Export writes the keys starting with prefix as JSON Lines, in sorted
+// order, and returns the number of keys. The keys are read from the tree
+// current when called, so writes going on meanwhile are not seen.
+func (fsm *Fsm) Export(w io.Writer, prefix string) (int64, error) {
+	out := bufio.NewWriter(w)
+	encoder := json.NewEncoder(out)
+	encoder.SetEscapeHTML(false)
+	var count int64
+	var err error
+	fsm.tree().Root().WalkPrefix([]byte(prefix), func(key []byte, value interface{}) bool {
+		err = encoder.Encode(KeyValue{Key: string(key), Value: value.(string)})
+		count++
+		return err != nil
+	})
+	if err != nil {
+		return 0, err
 	}
-	return
+	return count, out.Flush()
 }
 
-func (fsm *Fsm) delete(key string) (err error) {
+func (fsm *Fsm) removeHttpListener(id string) {
 	fsm.lock.Lock()
 	defer fsm.lock.Unlock()
-	_, exists := fsm.kv[key]
-	if exists {
-		fsm.logger.Debug("Delete", "Found Key", key)
-		delete(fsm.kv, key)
-	} else {
-		fsm.logger.Info("Delete", "Key not found", key)
+	delete(fsm.httplisteners, id)
+}
+
+// HttpListener returns the replicated http address of a server
+func (fsm *Fsm) HttpListener(id string) (address string, err error) {
+	fsm.lock.Lock()
+	defer fsm.lock.Unlock()
+	address, exists := fsm.httplisteners[id]
+	if !exists {
 		err = ErrKeyNotFound
 	}
 	return
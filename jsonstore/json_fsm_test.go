@@ -1,14 +1,18 @@
 package jsonstore
 
 import (
+	"bytes"
+	"io"
+	"strings"
 	"testing"
 	"time"
 
+	hclog "github.com/hashicorp/go-hclog"
 	"github.com/hashicorp/raft"
 )
 
 func TestFsm(t *testing.T) {
-	fsm, err := NewFsm()
+	fsm, err := NewFsm(hclog.NewNullLogger())
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -36,3 +40,151 @@ func TestFsm(t *testing.T) {
 	}
 
 }
+
+func TestFsmHttpListeners(t *testing.T) {
+	fsm, err := NewFsm(hclog.NewNullLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+	log := &raft.Log{Index: 1, Term: 1, Type: raft.LogCommand, Data: []byte("H:3:14:id4127.0.0.1:8003")}
+	if resp := fsm.Apply(log); resp != nil {
+		t.Fatal(resp)
+	}
+	address, err := fsm.HttpListener("id4")
+	if err != nil || address != "127.0.0.1:8003" {
+		t.Fatal("Http listener not found", address, err)
+	}
+	log = &raft.Log{Index: 2, Term: 1, Type: raft.LogCommand, Data: []byte("R:id4")}
+	fsm.Apply(log)
+	if _, err = fsm.HttpListener("id4"); err != ErrKeyNotFound {
+		t.Fatal("Http listener was not removed")
+	}
+}
+
+func TestFsmRestoreKeyValuesOnly(t *testing.T) {
+	fsm, err := NewFsm(hclog.NewNullLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Snapshots used to hold just the map of key-values
+	err = fsm.Restore(io.NopCloser(strings.NewReader(`{"Hello":"World","keyvals":"x"}`)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	value, err := fsm.Get("keyvals")
+	if err != nil || value != "x" {
+		t.Fatal("Key not found")
+	}
+}
+
+// memorySink collects a snapshot in memory
+type memorySink struct {
+	bytes.Buffer
+}
+
+func (sink *memorySink) ID() string    { return "memory" }
+func (sink *memorySink) Cancel() error { return nil }
+func (sink *memorySink) Close() error  { return nil }
+
+func TestFsmSnapshotIsPointInTime(t *testing.T) {
+	fsm, err := NewFsm(hclog.NewNullLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+	fsm.Apply(&raft.Log{Index: 1, Term: 1, Type: raft.LogCommand, Data: []byte("A:1:3:a<&>")})
+	fsm.Apply(&raft.Log{Index: 2, Term: 1, Type: raft.LogCommand, Data: []byte("A:1:1:b2")})
+	fsm.Apply(&raft.Log{Index: 3, Term: 1, Type: raft.LogCommand, Data: []byte("H:3:14:id1127.0.0.1:8000")})
+	snapshot, err := fsm.Snapshot()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Writes after the snapshot was taken are not part of it
+	fsm.Apply(&raft.Log{Index: 4, Term: 1, Type: raft.LogCommand, Data: []byte("A:1:1:c3")})
+	fsm.Apply(&raft.Log{Index: 5, Term: 1, Type: raft.LogCommand, Data: []byte("D:a")})
+
+	sink := &memorySink{}
+	if err := snapshot.Persist(sink); err != nil {
+		t.Fatal(err)
+	}
+	expected := `{"keyvals":{"a":"<&>","b":"2"},"httplisteners":{"id1":"127.0.0.1:8000"}}`
+	if sink.String() != expected {
+		t.Fatalf("Unexpected snapshot %s", sink.String())
+	}
+
+	restored, _ := NewFsm(hclog.NewNullLogger())
+	if err := restored.Restore(io.NopCloser(&sink.Buffer)); err != nil {
+		t.Fatal(err)
+	}
+	if value, err := restored.Get("a"); err != nil || value != "<&>" {
+		t.Fatal("Key not restored", value, err)
+	}
+	if _, err := restored.Get("c"); err != ErrKeyNotFound {
+		t.Fatal("Key written after the snapshot was restored")
+	}
+	if address, err := restored.HttpListener("id1"); err != nil || address != "127.0.0.1:8000" {
+		t.Fatal("Http listener not restored", address, err)
+	}
+	if found := restored.Scan("", 1); len(found) != 1 || found["a"] != "<&>" {
+		t.Fatal("Unexpected scan", found)
+	}
+}
+
+func TestFsmRestoreInvalid(t *testing.T) {
+	fsm, _ := NewFsm(hclog.NewNullLogger())
+	fsm.Apply(&raft.Log{Index: 1, Term: 1, Type: raft.LogCommand, Data: []byte("A:1:1:a1")})
+	for _, snapshot := range []string{`[]`, `{"keyvals":{"a":1}}`, `{"other":{}}`, `{"keyvals":{"a":"1"}`} {
+		if err := fsm.Restore(io.NopCloser(strings.NewReader(snapshot))); err == nil {
+			t.Fatal("Invalid snapshot restored", snapshot)
+		}
+	}
+	// A failed restore leaves the state alone
+	if value, err := fsm.Get("a"); err != nil || value != "1" {
+		t.Fatal("State changed by a failed restore")
+	}
+}
+
+func TestFsmBatch(t *testing.T) {
+	fsm, _ := NewFsm(hclog.NewNullLogger())
+	log := &raft.Log{Index: 1, Term: 1, Type: raft.LogCommand, Data: []byte("B:1:3:a1:21:2:bb23:0:c:d")}
+	if response := fsm.Apply(log); response != nil {
+		t.Fatal(response)
+	}
+	var out bytes.Buffer
+	if count, err := fsm.Export(&out, ""); err != nil || count != 3 {
+		t.Fatalf("Expected 3 keys, got %d: %v", count, err)
+	}
+	if out.String() != `{"key":"a","value":"1:2"}`+"\n"+`{"key":"b","value":"b2"}`+"\n"+
+		`{"key":"c:d","value":""}`+"\n" {
+		t.Fatalf("Unexpected export %s", out.String())
+	}
+	for _, data := range []string{"B:1:3:a1", "B:x:1:ab", "B:"} {
+		log.Data = []byte(data)
+		if fsm.Apply(log) != ErrIncorrectLog {
+			t.Fatalf("Expected %q to be refused", data)
+		}
+	}
+}
+
+func TestFsmApplyBatch(t *testing.T) {
+	fsm, _ := NewFsm(hclog.NewNullLogger())
+	logs := []*raft.Log{
+		{Index: 1, Term: 1, Type: raft.LogCommand, Data: []byte("M:8:A:1:1:ab3:D:x8:A:1:1:cd")},
+		{Index: 2, Term: 1, Type: raft.LogConfiguration, Data: []byte("not a command")},
+		{Index: 3, Term: 1, Type: raft.LogCommand, Data: []byte("D:a")},
+		{Index: 4, Term: 1, Type: raft.LogCommand, Data: []byte("M:7:M:3:D:c")},
+	}
+	responses := fsm.ApplyBatch(logs)
+	multi, ok := responses[0].([]interface{})
+	if !ok || len(multi) != 3 || multi[0] != nil || multi[1] != ErrKeyNotFound || multi[2] != nil {
+		t.Fatalf("Unexpected responses %v", responses[0])
+	}
+	if responses[1] != nil || responses[2] != nil || responses[3] != ErrIncorrectLog {
+		t.Fatalf("Unexpected responses %v", responses[1:])
+	}
+	if _, err := fsm.Get("a"); err != ErrKeyNotFound {
+		t.Fatal("Key was not deleted")
+	}
+	if value, err := fsm.Get("c"); err != nil || value != "d" {
+		t.Fatal("Key not found")
+	}
+}
@@ -1,121 +1,912 @@
 package jsonstore
 
 import (
+	"bytes"
+	"encoding/binary"
 	"encoding/json"
-	"errors"
 	"fmt"
 	"io"
 	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
 	"sync"
+	"time"
 
 	"github.com/emirpasic/gods/v2/maps/treemap"
+	metrics "github.com/hashicorp/go-metrics/compat"
 	"github.com/hashicorp/raft"
 )
 
+// Default bounds of a log store, see SetLimits
+const (
+	DefaultLogSegmentBytes = 4 << 20
+	DefaultLogCacheBytes   = 8 << 20
+)
+
+const (
+	// Kind of the segment files in their header
+	segmentKind = "logsegment"
+	segmentExt  = ".seg"
+
+	// Memory taken by the offset of an entry
+	offsetBytes = 8
+)
+
+// JsonLogStore keeps the RAFT log in a directory of segment files, each one
+// holding consecutive entries from the index in its name on. A segment
+// starts with a store header line, the entries follow as JSON lines or as
+// msgpack records prefixed with their length. Entries are appended to the
+// last segment, a new one is started once it reaches the segment size and
+// the full one is compressed if asked.
+//
+// Only the offsets of the entries and an LRU cache of the recent ones are
+// kept in memory, the other entries are read from their segment when asked
+// for.
 type JsonLogStore struct {
-	jsonfilepath string
-	kv           *treemap.Map[uint64, *raft.Log]
-	lock         sync.Mutex
+	dir      string
+	segments []*logSegment
+	cache    *logCache
+	lock     sync.Mutex
+
+	// Compression of the full segments, they are read whatever they were
+	// written with
+	compression Compression
+
+	// Codec of the entries of new segments, the header of every segment
+	// tells its own
+	codec Codec
+
+	// Size from which a new segment is started
+	segmentbytes int64
+
+	// Content of the compressed segment read last, its next entries are
+	// likely to be read next
+	unpacked     *logSegment
+	unpackeddata []byte
+
+	// Reads served by the cache and from the segments
+	hits, misses uint64
+}
+
+// logSegment is a segment file holding the entries from first on
+type logSegment struct {
+	path  string
+	first uint64
+	codec Codec
+
+	// Offsets of the entries in the uncompressed content, and its size
+	offsets []int64
+	size    int64
+
+	// Compressed segments are only read, the others are kept open
+	compressed bool
+	disksize   int64
+	file       *os.File
+}
+
+// last returns the index of the last entry of the segment
+func (segment *logSegment) last() uint64 {
+	return segment.first + uint64(len(segment.offsets)) - 1
 }
 
+// record returns where the entry at index starts and ends in the content
+func (segment *logSegment) record(index uint64) (int64, int64) {
+	i := index - segment.first
+	end := segment.size
+	if i+1 < uint64(len(segment.offsets)) {
+		end = segment.offsets[i+1]
+	}
+	return segment.offsets[i], end
+}
+
+func (segment *logSegment) close() error {
+	if segment.file == nil {
+		return nil
+	}
+	err := segment.file.Sync()
+	if cerr := segment.file.Close(); err == nil {
+		err = cerr
+	}
+	segment.file = nil
+	return err
+}
+
+// NewJsonLogStore opens the log store in the directory jsonfilepath. A log
+// store file written before the entries were kept in segments is turned
+// into a directory of segments at the same path.
 func NewJsonLogStore(jsonfilepath string) (js *JsonLogStore, err error) {
-	kv := treemap.New[uint64, *raft.Log]()
-	_, err = os.Stat(jsonfilepath)
-	if err == nil {
-		file, err := os.OpenFile(jsonfilepath, os.O_RDONLY, 0600)
+	js = &JsonLogStore{dir: jsonfilepath, cache: newLogCache(DefaultLogCacheBytes), codec: CodecJSON,
+		compression: CompressionNone, segmentbytes: DefaultLogSegmentBytes}
+	if err = adoptLegacyLogStore(jsonfilepath); err != nil {
+		return nil, err
+	}
+	if err = migrateLogStoreFile(jsonfilepath); err != nil {
+		return nil, err
+	}
+	entries, err := os.ReadDir(jsonfilepath)
+	if os.IsNotExist(err) {
+		return js, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	// Sorted by name, so by first index
+	for _, entry := range entries {
+		path := filepath.Join(jsonfilepath, entry.Name())
+		if strings.HasSuffix(entry.Name(), ".tmp") {
+			os.Remove(path)
+			continue
+		}
+		if !strings.HasSuffix(entry.Name(), segmentExt) {
+			continue
+		}
+		segment, err := openSegment(path)
 		if err != nil {
-			panic(err)
+			js.Close()
+			return nil, err
+		}
+		if segment == nil {
+			continue
+		}
+		// A segment cut at its start is written before the old one is
+		// removed, the old one is left over if the node stopped meanwhile
+		if n := len(js.segments); n > 0 && segment.first <= js.segments[n-1].last() {
+			js.segments[n-1].close()
+			os.Remove(js.segments[n-1].path)
+			js.segments = js.segments[:n-1]
+		}
+		js.segments = append(js.segments, segment)
+		js.codec = segment.codec
+		if segment.compressed {
+			js.compression = CompressionGzip
+		}
+	}
+	js.publish()
+	return js, nil
+}
+
+func segmentPath(dir string, first uint64) string {
+	return filepath.Join(dir, fmt.Sprintf("%020d%s", first, segmentExt))
+}
+
+// segmentHeader returns the header line of a segment with codec
+func segmentHeader(codec Codec) []byte {
+	header, _ := json.Marshal(storeHeader{Format: storeFormat, Codec: codec, Kind: segmentKind})
+	return append(header, '\n')
+}
+
+// openSegment reads the offsets of the entries of a segment file. A torn
+// entry at the end of an uncompressed segment, left by a crash, is cut off.
+// An empty segment is removed and nil is returned.
+func openSegment(path string) (*logSegment, error) {
+	first, err := strconv.ParseUint(strings.TrimSuffix(filepath.Base(path), segmentExt), 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("%s: not a segment name", path)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	content, compression, err := decompressAll(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	header, offsets, size, err := scanSegment(content)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	segment := &logSegment{path: path, first: first, codec: header.Codec, offsets: offsets, size: size,
+		compressed: compression == CompressionGzip, disksize: int64(len(data))}
+	for len(segment.offsets) > 0 {
+		start, end := segment.record(segment.last())
+		log, err := decodeRecord(segment.codec, content[start:end])
+		if err == nil && log.Index == segment.last() {
+			break
+		}
+		if segment.compressed {
+			return nil, fmt.Errorf("%s: entry %d is corrupt", path, segment.last())
+		}
+		segment.offsets = segment.offsets[:len(segment.offsets)-1]
+		segment.size = start
+	}
+	if len(segment.offsets) == 0 {
+		return nil, os.Remove(path)
+	}
+	start, end := segment.record(first)
+	if log, err := decodeRecord(segment.codec, content[start:end]); err != nil || log.Index != first {
+		return nil, fmt.Errorf("%s: does not start with entry %d", path, first)
+	}
+	if segment.compressed {
+		return segment, nil
+	}
+	if segment.size < int64(len(content)) {
+		if err = os.Truncate(path, segment.size); err != nil {
+			return nil, err
+		}
+		segment.disksize = segment.size
+	}
+	segment.file, err = os.OpenFile(path, os.O_RDWR, 0600)
+	return segment, err
+}
+
+// decompressAll returns the content of a file which may be compressed
+func decompressAll(data []byte) ([]byte, Compression, error) {
+	reader, compression, err := Decompress(bytes.NewReader(data))
+	if err != nil || compression == CompressionNone {
+		return data, compression, err
+	}
+	content, err := io.ReadAll(reader)
+	return content, compression, err
+}
+
+// scanSegment reads the header of a segment and the offsets of its entries
+func scanSegment(content []byte) (storeHeader, []int64, int64, error) {
+	var header storeHeader
+	line, _, found := bytes.Cut(content, []byte{'\n'})
+	if !found || !bytes.HasPrefix(line, headerPrefix) {
+		return header, nil, 0, fmt.Errorf("missing header")
+	}
+	if err := json.Unmarshal(line, &header); err != nil {
+		return header, nil, 0, fmt.Errorf("invalid header: %w", err)
+	}
+	if header.Kind != segmentKind {
+		return header, nil, 0, fmt.Errorf("not a log segment but %q", header.Kind)
+	}
+	if err := header.Codec.Validate(); err != nil {
+		return header, nil, 0, err
+	}
+	var offsets []int64
+	offset := int64(len(line) + 1)
+	for {
+		n := recordLength(header.Codec, content[offset:])
+		if n == 0 {
+			return header, offsets, offset, nil
+		}
+		offsets = append(offsets, offset)
+		offset += n
+	}
+}
+
+// recordLength returns the length of the entry data starts with, 0 if it
+// does not hold a whole one
+func recordLength(codec Codec, data []byte) int64 {
+	if codec == CodecMsgpack {
+		if len(data) < 4 {
+			return 0
 		}
-		data, err := io.ReadAll(file)
-		err = json.Unmarshal(data, &kv)
+		length := 4 + int64(binary.BigEndian.Uint32(data))
+		if length > int64(len(data)) {
+			return 0
+		}
+		return length
+	}
+	return int64(bytes.IndexByte(data, '\n') + 1)
+}
+
+// encodeRecord lays out an entry in a segment
+func encodeRecord(codec Codec, log *raft.Log) ([]byte, error) {
+	if codec == CodecMsgpack {
+		data, err := codec.marshal(packLog(log))
 		if err != nil {
-			panic(err)
+			return nil, err
 		}
-	} else {
-		err = nil
+		record := binary.BigEndian.AppendUint32(make([]byte, 0, 4+len(data)), uint32(len(data)))
+		return append(record, data...), nil
+	}
+	data, err := json.Marshal(log)
+	return append(data, '\n'), err
+}
+
+func decodeRecord(codec Codec, record []byte) (*raft.Log, error) {
+	if codec == CodecMsgpack {
+		var packed packedLog
+		if len(record) < 4 {
+			return nil, ErrIncorrectLog
+		}
+		if err := codec.unmarshal(record[4:], &packed); err != nil {
+			return nil, err
+		}
+		return packed.unpack(), nil
+	}
+	log := &raft.Log{}
+	return log, json.Unmarshal(record, log)
+}
+
+// packedLog is a log entry in msgpack documents, with short field names
+type packedLog struct {
+	Index      uint64       `codec:"i"`
+	Term       uint64       `codec:"t"`
+	Type       raft.LogType `codec:"y"`
+	Data       []byte       `codec:"d,omitempty"`
+	Extensions []byte       `codec:"x,omitempty"`
+	AppendedAt time.Time    `codec:"a"`
+}
+
+func packLog(log *raft.Log) packedLog {
+	return packedLog{Index: log.Index, Term: log.Term, Type: log.Type, Data: log.Data,
+		Extensions: log.Extensions, AppendedAt: log.AppendedAt}
+}
+
+func (packed *packedLog) unpack() *raft.Log {
+	return &raft.Log{Index: packed.Index, Term: packed.Term, Type: packed.Type, Data: packed.Data,
+		Extensions: packed.Extensions, AppendedAt: packed.AppendedAt}
+}
+
+// decodeLogs reads the entries of a log store file written before the
+// segments. JSON documents are objects keyed by index, msgpack ones arrays
+// of packed entries.
+func decodeLogs(kv *treemap.Map[uint64, *raft.Log], codec Codec, document []byte) error {
+	if codec != CodecMsgpack {
+		return codec.unmarshal(document, &kv)
+	}
+	var logs []packedLog
+	if err := codec.unmarshal(document, &logs); err != nil {
+		return err
+	}
+	for _, packed := range logs {
+		kv.Put(packed.Index, packed.unpack())
+	}
+	return nil
+}
+
+// adoptLegacyLogStore moves the file of a log store written at path.json,
+// the former default path of the store, to path when there are no
+// segments there yet. Starting afresh next to it would lose the committed
+// entries, so having both is refused.
+func adoptLegacyLogStore(path string) error {
+	legacy := filepath.Clean(path) + ".json"
+	info, err := os.Stat(legacy)
+	if os.IsNotExist(err) {
+		return nil
 	}
-	js = &JsonLogStore{jsonfilepath: jsonfilepath, kv: kv, lock: sync.Mutex{}}
-	return
+	if err != nil || info.IsDir() {
+		return err
+	}
+	entries, err := os.ReadDir(path)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	for _, entry := range entries {
+		if strings.HasSuffix(entry.Name(), segmentExt) {
+			return fmt.Errorf("both %s and the log store file %s exist, remove the stale one", path, legacy)
+		}
+	}
+	if err == nil {
+		// A directory without segments, e.g. left by a start with the new
+		// path before this check
+		for _, entry := range entries {
+			if strings.HasSuffix(entry.Name(), ".tmp") {
+				os.Remove(filepath.Join(path, entry.Name()))
+			}
+		}
+		if err = os.Remove(path); err != nil {
+			return err
+		}
+	}
+	return os.Rename(legacy, path)
 }
 
-func (js *JsonLogStore) FirstIndex() (index uint64, err error) {
-	err = nil
-	index = 0
+// migrateLogStoreFile turns a log store file written before the segments
+// into a directory of segments at the same path. The segments are written
+// aside and renamed once the file is removed.
+func migrateLogStoreFile(path string) error {
+	migrated := path + ".migrate"
+	info, err := os.Stat(path)
+	if os.IsNotExist(err) {
+		if _, err := os.Stat(migrated); err == nil {
+			return os.Rename(migrated, path)
+		}
+		return nil
+	}
+	if err != nil || info.IsDir() {
+		return err
+	}
+	document, codec, compression, err := readStore(path)
+	if err != nil {
+		return err
+	}
+	kv := treemap.New[uint64, *raft.Log]()
+	if err = decodeLogs(kv, codec, document); err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	if err = os.RemoveAll(migrated); err != nil {
+		return err
+	}
+	store := &JsonLogStore{dir: migrated, cache: newLogCache(0), codec: codec, compression: compression,
+		segmentbytes: DefaultLogSegmentBytes}
+	err = store.StoreLogs(kv.Values())
+	if cerr := store.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.MkdirAll(migrated, 0755)
+	}
+	if err == nil {
+		err = os.Remove(path)
+	}
+	if err != nil {
+		return fmt.Errorf("%s: moving the entries to segments: %w", path, err)
+	}
+	return os.Rename(migrated, path)
+}
+
+func (js *JsonLogStore) FirstIndex() (uint64, error) {
 	js.lock.Lock()
 	defer js.lock.Unlock()
-	index, _, ok := js.kv.Min()
-	if !ok {
-		index = 0
-	}
-	return
+	return js.firstIndex(), nil
 }
 
-func (js *JsonLogStore) LastIndex() (index uint64, err error) {
-	err = nil
-	index = 0
+func (js *JsonLogStore) LastIndex() (uint64, error) {
 	js.lock.Lock()
 	defer js.lock.Unlock()
-	index, _, ok := js.kv.Max()
-	if !ok {
-		index = 0
+	return js.lastIndex(), nil
+}
+
+func (js *JsonLogStore) firstIndex() uint64 {
+	if len(js.segments) == 0 {
+		return 0
 	}
-	return
+	return js.segments[0].first
 }
 
-func (js *JsonLogStore) GetLog(index uint64, log *raft.Log) (err error) {
+func (js *JsonLogStore) lastIndex() uint64 {
+	if len(js.segments) == 0 {
+		return 0
+	}
+	return js.segments[len(js.segments)-1].last()
+}
+
+func (js *JsonLogStore) GetLog(index uint64, log *raft.Log) error {
 	js.lock.Lock()
 	defer js.lock.Unlock()
-	val, ok := js.kv.Get(index)
-	if !ok {
-		log = nil
-		err = errors.New(fmt.Sprintf("Key:%d not found", index))
+	if cached := js.cache.get(index); cached != nil {
+		js.hits++
+		metrics.IncrCounter([]string{"logstore", "cache_hit"}, 1)
+		*log = *cached
+		return nil
+	}
+	segment := js.find(index)
+	if segment == nil {
+		return raft.ErrLogNotFound
+	}
+	js.misses++
+	metrics.IncrCounter([]string{"logstore", "cache_miss"}, 1)
+	read, err := js.read(segment, index)
+	if err != nil {
+		return err
 	}
-	*log = *val
-	return
+	js.cache.add(read)
+	*log = *read
+	return nil
+}
+
+// find returns the segment holding index, nil if no segment does
+func (js *JsonLogStore) find(index uint64) *logSegment {
+	i := sort.Search(len(js.segments), func(i int) bool {
+		return js.segments[i].last() >= index
+	})
+	if i < len(js.segments) && js.segments[i].first <= index {
+		return js.segments[i]
+	}
+	return nil
+}
+
+// read decodes the entry at index from its segment
+func (js *JsonLogStore) read(segment *logSegment, index uint64) (*raft.Log, error) {
+	start, end := segment.record(index)
+	var record []byte
+	if segment.compressed {
+		content, err := js.unpack(segment)
+		if err != nil {
+			return nil, err
+		}
+		record = content[start:end]
+	} else {
+		record = make([]byte, end-start)
+		if _, err := segment.file.ReadAt(record, start); err != nil {
+			return nil, fmt.Errorf("%s: %w", segment.path, err)
+		}
+	}
+	log, err := decodeRecord(segment.codec, record)
+	if err != nil {
+		return nil, fmt.Errorf("%s: entry %d: %w", segment.path, index, err)
+	}
+	return log, nil
+}
+
+// content returns the uncompressed content of a segment
+func (js *JsonLogStore) content(segment *logSegment) ([]byte, error) {
+	if segment.compressed {
+		return js.unpack(segment)
+	}
+	content := make([]byte, segment.size)
+	if _, err := segment.file.ReadAt(content, 0); err != nil {
+		return nil, fmt.Errorf("%s: %w", segment.path, err)
+	}
+	return content, nil
+}
+
+// unpack decompresses a compressed segment, the content of the last one is
+// kept
+func (js *JsonLogStore) unpack(segment *logSegment) ([]byte, error) {
+	if js.unpacked == segment {
+		return js.unpackeddata, nil
+	}
+	data, err := os.ReadFile(segment.path)
+	if err != nil {
+		return nil, err
+	}
+	content, _, err := decompressAll(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", segment.path, err)
+	}
+	js.unpacked, js.unpackeddata = segment, content
+	return content, nil
 }
 
 func (js *JsonLogStore) StoreLog(log *raft.Log) error {
-	js.lock.Lock()
-	defer js.lock.Unlock()
-	js.kv.Put(log.Index, log)
-	return js.save()
+	return js.StoreLogs([]*raft.Log{log})
 }
 
+// StoreLogs appends the entries to the last segment. Entries already in
+// the store are replaced along with the ones after them.
 func (js *JsonLogStore) StoreLogs(logs []*raft.Log) error {
+	defer metrics.MeasureSince([]string{"logstore", "append"}, time.Now())
 	js.lock.Lock()
 	defer js.lock.Unlock()
+	defer js.publish()
+	if len(logs) == 0 {
+		return nil
+	}
+	if last := js.lastIndex(); last != 0 && logs[0].Index <= last {
+		if err := js.deleteRange(logs[0].Index, last); err != nil {
+			return err
+		}
+	}
+	active, err := js.active()
+	if err != nil {
+		return err
+	}
+	var pending []byte
+	for _, log := range logs {
+		if active != nil && (log.Index != active.last()+1 ||
+			active.size+int64(len(pending)) >= js.segmentbytes) {
+			if err := js.flush(active, pending); err != nil {
+				return err
+			}
+			pending = nil
+			if err := js.seal(len(js.segments) - 1); err != nil {
+				return err
+			}
+			active = nil
+		}
+		record, err := encodeRecord(js.codec, log)
+		if err != nil {
+			return err
+		}
+		if active == nil {
+			if active, err = js.createSegment(log.Index); err != nil {
+				return err
+			}
+		}
+		active.offsets = append(active.offsets, active.size+int64(len(pending)))
+		pending = append(pending, record...)
+	}
+	if err := js.flush(active, pending); err != nil {
+		return err
+	}
 	for _, log := range logs {
-		js.kv.Put(log.Index, log)
+		js.cache.add(log)
+	}
+	return nil
+}
+
+// active returns the last segment if entries can be appended to it. A
+// full segment, or one with another codec, is sealed.
+func (js *JsonLogStore) active() (*logSegment, error) {
+	if len(js.segments) == 0 {
+		return nil, nil
 	}
-	return js.save()
+	last := len(js.segments) - 1
+	segment := js.segments[last]
+	if segment.compressed {
+		return nil, nil
+	}
+	if segment.codec == js.codec && segment.size < js.segmentbytes {
+		return segment, nil
+	}
+	return nil, js.seal(last)
 }
 
+// createSegment starts a segment with the entry first
+func (js *JsonLogStore) createSegment(first uint64) (*logSegment, error) {
+	if err := os.MkdirAll(js.dir, 0755); err != nil {
+		return nil, err
+	}
+	path := segmentPath(js.dir, first)
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return nil, err
+	}
+	header := segmentHeader(js.codec)
+	_, err = file.Write(header)
+	if err == nil {
+		err = file.Sync()
+	}
+	if err == nil {
+		err = syncDir(js.dir)
+	}
+	if err != nil {
+		file.Close()
+		os.Remove(path)
+		return nil, err
+	}
+	segment := &logSegment{path: path, first: first, codec: js.codec, size: int64(len(header)),
+		disksize: int64(len(header)), file: file}
+	js.segments = append(js.segments, segment)
+	return segment, nil
+}
+
+// flush writes the entries pending for the last segment and syncs it, raft
+// takes them as durable once StoreLogs returns. On failure the entries are
+// dropped and the segment is cut back.
+func (js *JsonLogStore) flush(segment *logSegment, pending []byte) error {
+	_, err := segment.file.WriteAt(pending, segment.size)
+	if err == nil {
+		err = segment.file.Sync()
+	}
+	if err == nil {
+		segment.size += int64(len(pending))
+		segment.disksize = segment.size
+		return nil
+	}
+	for len(segment.offsets) > 0 && segment.offsets[len(segment.offsets)-1] >= segment.size {
+		segment.offsets = segment.offsets[:len(segment.offsets)-1]
+	}
+	segment.file.Truncate(segment.size)
+	if len(segment.offsets) == 0 {
+		segment.close()
+		os.Remove(segment.path)
+		js.segments = js.segments[:len(js.segments)-1]
+	}
+	return fmt.Errorf("%s: %w", segment.path, err)
+}
+
+// seal compresses the segment i if asked, once no entry is appended to it
+func (js *JsonLogStore) seal(i int) error {
+	segment := js.segments[i]
+	if js.compression != CompressionGzip || segment.compressed {
+		return nil
+	}
+	sealed, err := js.rewrite(segment, segment.first, segment.last(), segment.codec, true)
+	if err != nil {
+		return err
+	}
+	js.segments[i] = sealed
+	return nil
+}
+
+// rewrite writes the entries from..to of a segment to the segment file of
+// from with codec. The segment file is replaced if from is its first entry,
+// it is left alone otherwise.
+func (js *JsonLogStore) rewrite(segment *logSegment, from, to uint64, codec Codec,
+	compress bool) (*logSegment, error) {
+	content, err := js.content(segment)
+	if err != nil {
+		return nil, err
+	}
+	out := segmentHeader(codec)
+	offsets := make([]int64, 0, to-from+1)
+	for index := from; index <= to; index++ {
+		start, end := segment.record(index)
+		record := content[start:end]
+		if codec != segment.codec {
+			log, err := decodeRecord(segment.codec, record)
+			if err != nil {
+				return nil, fmt.Errorf("%s: entry %d: %w", segment.path, index, err)
+			}
+			if record, err = encodeRecord(codec, log); err != nil {
+				return nil, err
+			}
+		}
+		offsets = append(offsets, int64(len(out)))
+		out = append(out, record...)
+	}
+	rewritten := &logSegment{path: segmentPath(js.dir, from), first: from, codec: codec, offsets: offsets,
+		size: int64(len(out)), compressed: compress}
+	data := out
+	if compress {
+		var compressed bytes.Buffer
+		writer := compressor(&compressed, CompressionGzip)
+		writer.Write(out)
+		if err = writer.Close(); err != nil {
+			return nil, err
+		}
+		data = compressed.Bytes()
+	}
+	rewritten.disksize = int64(len(data))
+	if err = writeStoreFile(rewritten.path, data); err != nil {
+		return nil, err
+	}
+	if rewritten.path == segment.path {
+		segment.close()
+	}
+	if js.unpacked == segment {
+		js.unpacked, js.unpackeddata = nil, nil
+	}
+	if !compress {
+		rewritten.file, err = os.OpenFile(rewritten.path, os.O_RDWR, 0600)
+	}
+	return rewritten, err
+}
+
+// DeleteRange removes the entries from min to max. RAFT removes the start
+// of the log once it is in a snapshot, and the end of the log when it
+// conflicts with the leader.
 func (js *JsonLogStore) DeleteRange(min, max uint64) error {
 	if min > max {
 		min, max = max, min
 	}
 	js.lock.Lock()
 	defer js.lock.Unlock()
-	removed := 0
-	for i := min; i <= max; i++ {
-		key, _, ok := js.kv.Ceiling(i)
-		if ok && key <= max {
-			js.kv.Remove(key)
-			removed++
-		} else {
-			break
+	defer js.publish()
+	return js.deleteRange(min, max)
+}
+
+func (js *JsonLogStore) deleteRange(min, max uint64) error {
+	js.cache.removeRange(min, max)
+	var kept []*logSegment
+	for i, segment := range js.segments {
+		if segment.last() < min || segment.first > max {
+			kept = append(kept, segment)
+			continue
+		}
+		// The entries after the range go to a segment of their own, the
+		// ones before it stay in the segment
+		var tail *logSegment
+		var err error
+		if segment.last() > max {
+			tail, err = js.rewrite(segment, max+1, segment.last(), segment.codec, segment.compressed)
+		}
+		switch {
+		case err != nil:
+		case segment.first >= min:
+			segment.close()
+			err = os.Remove(segment.path)
+		case segment.compressed:
+			var head *logSegment
+			if head, err = js.rewrite(segment, segment.first, min-1, segment.codec, true); err == nil {
+				kept = append(kept, head)
+			}
+		default:
+			start, _ := segment.record(min)
+			if err = segment.file.Truncate(start); err == nil {
+				segment.offsets = segment.offsets[:min-segment.first]
+				segment.size, segment.disksize = start, start
+				kept = append(kept, segment)
+			}
+		}
+		if err != nil {
+			js.segments = append(kept, js.segments[i:]...)
+			return err
+		}
+		if tail != nil {
+			kept = append(kept, tail)
 		}
 	}
-	if removed > 0 {
-		return js.save()
-	}
+	js.segments = kept
+	js.unpacked, js.unpackeddata = nil, nil
 	return nil
 }
 
-func (js *JsonLogStore) save() (err error) {
-	data, err := json.Marshal(js.kv)
-	if err == nil {
-		err = os.WriteFile(js.jsonfilepath, data, 0600)
+// Close syncs and closes the segments
+func (js *JsonLogStore) Close() error {
+	js.lock.Lock()
+	defer js.lock.Unlock()
+	var err error
+	for _, segment := range js.segments {
+		if cerr := segment.close(); err == nil {
+			err = cerr
+		}
 	}
-	return
+	return err
+}
+
+// SetCodec sets the codec of the segments started from now on
+func (js *JsonLogStore) SetCodec(codec Codec) {
+	js.lock.Lock()
+	defer js.lock.Unlock()
+	js.codec = codec
+}
+
+// SetCompression sets the compression of the segments filled from now on
+func (js *JsonLogStore) SetCompression(compression Compression) {
+	js.lock.Lock()
+	defer js.lock.Unlock()
+	js.compression = compression
+}
+
+// SetLimits sets the size from which a new segment is started and the
+// memory the cache of entries may use
+func (js *JsonLogStore) SetLimits(segmentbytes, cachebytes int64) {
+	js.lock.Lock()
+	defer js.lock.Unlock()
+	js.segmentbytes = segmentbytes
+	js.cache.setLimit(cachebytes)
+}
+
+// recode rewrites the segments with codec and returns the codec of the
+// last segment
+func (js *JsonLogStore) recode(codec Codec) (Codec, error) {
+	js.lock.Lock()
+	defer js.lock.Unlock()
+	from := js.codec
+	js.codec = codec
+	for i, segment := range js.segments {
+		if segment.codec == codec {
+			continue
+		}
+		rewritten, err := js.rewrite(segment, segment.first, segment.last(), codec, segment.compressed)
+		if err != nil {
+			return from, err
+		}
+		js.segments[i] = rewritten
+	}
+	return from, nil
+}
+
+// LogStoreStats tells how much memory the log store uses and how well its
+// cache works
+type LogStoreStats struct {
+	Segments int
+
+	// Entries in the cache and the memory they take, reads served by the
+	// cache and from the segments
+	CacheEntries int
+	CacheBytes   int64
+	CacheHits    uint64
+	CacheMisses  uint64
+
+	// Share of the reads served by the cache, 0 before the first read
+	CacheHitRate float64
+
+	// Memory taken by the offsets, the cache and the last compressed
+	// segment read
+	MemoryBytes int64
+
+	// Size of the segment files
+	DiskBytes int64
+}
+
+// Stats reports the memory and the cache use of the store
+func (js *JsonLogStore) Stats() LogStoreStats {
+	js.lock.Lock()
+	defer js.lock.Unlock()
+	return js.stats()
+}
+
+func (js *JsonLogStore) stats() LogStoreStats {
+	stats := LogStoreStats{Segments: len(js.segments), CacheEntries: js.cache.order.Len(),
+		CacheBytes: js.cache.size, CacheHits: js.hits, CacheMisses: js.misses}
+	if reads := js.hits + js.misses; reads > 0 {
+		stats.CacheHitRate = float64(js.hits) / float64(reads)
+	}
+	stats.MemoryBytes = js.cache.size + int64(len(js.unpackeddata))
+	for _, segment := range js.segments {
+		stats.MemoryBytes += int64(len(segment.offsets)) * offsetBytes
+		stats.DiskBytes += segment.disksize
+	}
+	return stats
+}
+
+// publish sets the gauges of the store, the lock must be held
+func (js *JsonLogStore) publish() {
+	stats := js.stats()
+	metrics.SetGauge([]string{"logstore", "size_bytes"}, float32(stats.DiskBytes))
+	metrics.SetGauge([]string{"logstore", "segments"}, float32(stats.Segments))
+	metrics.SetGauge([]string{"logstore", "memory_bytes"}, float32(stats.MemoryBytes))
+	metrics.SetGauge([]string{"logstore", "cache_bytes"}, float32(stats.CacheBytes))
+	metrics.SetGauge([]string{"logstore", "cache_hit_rate"}, float32(stats.CacheHitRate))
 }
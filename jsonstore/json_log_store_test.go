@@ -1,9 +1,13 @@
 package jsonstore
 
 import (
-	"github.com/hashicorp/raft"
+	"fmt"
+	"os"
+	"path/filepath"
 	"testing"
 	"time"
+
+	"github.com/hashicorp/raft"
 )
 
 func Test_JsonLogStore(t *testing.T) {
@@ -27,3 +31,112 @@ func Test_JsonLogStore(t *testing.T) {
 	js.DeleteRange(3, 198)
 
 }
+
+func TestJsonLogStoreSegments(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), logStoreDir)
+	js, err := NewJsonLogStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	js.SetCompression(CompressionGzip)
+	js.SetLimits(512, 1024)
+	var logs []*raft.Log
+	for i := uint64(1); i <= 100; i++ {
+		logs = append(logs, &raft.Log{Index: i, Term: 1, Type: raft.LogCommand,
+			Data: []byte(fmt.Sprintf("A:1:%d:value-%d", i%10, i))})
+	}
+	if err := js.StoreLogs(logs); err != nil {
+		t.Fatal(err)
+	}
+	check := func(first, last uint64) {
+		t.Helper()
+		if index, _ := js.FirstIndex(); index != first {
+			t.Fatalf("Expected first index %d, got %d", first, index)
+		}
+		if index, _ := js.LastIndex(); index != last {
+			t.Fatalf("Expected last index %d, got %d", last, index)
+		}
+		var log raft.Log
+		for i := first; i <= last && first != 0; i++ {
+			if err := js.GetLog(i, &log); err != nil || log.Index != i ||
+				string(log.Data) != fmt.Sprintf("A:1:%d:value-%d", i%10, i) {
+				t.Fatalf("Unexpected entry %d %+v: %v", i, log, err)
+			}
+		}
+	}
+	check(1, 100)
+	var log raft.Log
+	js.GetLog(100, &log)
+	stats := js.Stats()
+	if stats.Segments < 3 || stats.CacheBytes > 1024 || stats.CacheMisses == 0 || stats.CacheHits != 1 {
+		t.Fatalf("Unexpected stats %+v", stats)
+	}
+
+	// The start of the log as after a snapshot, then its end as on a conflict
+	js.DeleteRange(1, 10)
+	check(11, 100)
+	js.DeleteRange(91, 100)
+	check(11, 90)
+	js.DeleteRange(60, 90)
+	check(11, 59)
+	if err := js.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if js, err = NewJsonLogStore(dir); err != nil {
+		t.Fatal(err)
+	}
+	check(11, 59)
+
+	// A torn entry at the end of the last segment is cut when opening
+	js.StoreLog(&raft.Log{Index: 60, Term: 1, Type: raft.LogCommand, Data: []byte("A:1:0:value-60")})
+	last := js.segments[len(js.segments)-1].path
+	js.Close()
+	file, _ := os.OpenFile(last, os.O_WRONLY|os.O_APPEND, 0600)
+	file.Write([]byte(`{"Index":61,"Term"`))
+	file.Close()
+	if js, err = NewJsonLogStore(dir); err != nil {
+		t.Fatal(err)
+	}
+	check(11, 60)
+	js.Close()
+
+	// The file of the older log stores is turned into segments
+	legacy := filepath.Join(t.TempDir(), legacyLogStoreFile)
+	os.WriteFile(legacy, []byte(`{"5":{"Index":5,"Term":2,"Type":0,"Data":"QToxOjU6dmFsdWUtNQ=="}}`), 0600)
+	if js, err = NewJsonLogStore(legacy); err != nil {
+		t.Fatal(err)
+	}
+	defer js.Close()
+	check(5, 5)
+	if info, err := os.Stat(legacy); err != nil || !info.IsDir() {
+		t.Fatalf("Expected %s to be a directory: %v", legacy, err)
+	}
+}
+
+func TestJsonLogStoreFormerDefaultPath(t *testing.T) {
+	// Layout left by the former default -logstore log/logstore.json
+	dir := filepath.Join(t.TempDir(), "log")
+	os.MkdirAll(dir, 0755)
+	legacy := filepath.Join(dir, "logstore.json")
+	os.WriteFile(legacy, []byte(`{"5":{"Index":5,"Term":2,"Type":0,"Data":"QToxOjU6dmFsdWUtNQ=="}}`), 0600)
+	os.Mkdir(filepath.Join(dir, "logstore"), 0755)
+
+	js, err := NewJsonLogStore(filepath.Join(dir, "logstore"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var log raft.Log
+	if err := js.GetLog(5, &log); err != nil || string(log.Data) != "A:1:5:value-5" {
+		t.Fatalf("Unexpected entry %+v: %v", log, err)
+	}
+	js.Close()
+	if _, err := os.Stat(legacy); !os.IsNotExist(err) {
+		t.Fatal("Expected the former file to be moved, got", err)
+	}
+
+	// A log store file next to segments is refused
+	os.WriteFile(legacy, []byte(`{}`), 0600)
+	if _, err := NewJsonLogStore(filepath.Join(dir, "logstore")); err == nil {
+		t.Fatal("Expected the store to be refused")
+	}
+}
@@ -0,0 +1,680 @@
+package jsonstore
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	hclog "github.com/hashicorp/go-hclog"
+	"github.com/hashicorp/raft"
+)
+
+// Format of the snapshot documents
+const jsonSnapshotVersion = 1
+
+// Subdirectory of the store directory keeping the snapshots, the same one
+// raft.FileSnapshotStore uses
+const snapshotsDir = "snapshots"
+
+var (
+	ErrSnapshotChecksum = errors.New("snapshot checksum mismatch")
+)
+
+// A server of the configuration recorded with a snapshot
+type SnapshotServer struct {
+	ID       string `json:"id"`
+	Address  string `json:"address"`
+	Suffrage string `json:"suffrage"`
+}
+
+// JsonSnapshot is the metadata of the document written for each snapshot.
+// The document ends with the contents of the FSM under "state", which are
+// streamed and never held in memory. Checksum is the SHA-256 of the state
+// in compact form, so that reformatting the file does not break it, and
+// Size the length of that form.
+type JsonSnapshot struct {
+	Version            int              `json:"version"`
+	ID                 string           `json:"id"`
+	Index              uint64           `json:"index"`
+	Term               uint64           `json:"term"`
+	Configuration      []SnapshotServer `json:"configuration"`
+	ConfigurationIndex uint64           `json:"configurationindex"`
+	Created            time.Time        `json:"created"`
+	Size               int64            `json:"size"`
+	Checksum           string           `json:"checksum"`
+
+	// Compression of the file, none if empty. Compressed documents are kept
+	// in <id>.json.gz files.
+	Codec Compression `json:"codec,omitempty"`
+}
+
+// Name of the member holding the FSM contents
+const stateMember = "state"
+
+// meta converts the metadata of the document for raft
+func (snapshot *JsonSnapshot) meta() (*raft.SnapshotMeta, error) {
+	meta := &raft.SnapshotMeta{Version: raft.SnapshotVersionMax, ID: snapshot.ID, Index: snapshot.Index,
+		Term: snapshot.Term, ConfigurationIndex: snapshot.ConfigurationIndex, Size: snapshot.Size}
+	for _, server := range snapshot.Configuration {
+		var suffrage raft.ServerSuffrage
+		switch server.Suffrage {
+		case raft.Voter.String():
+			suffrage = raft.Voter
+		case raft.Nonvoter.String():
+			suffrage = raft.Nonvoter
+		case raft.Staging.String():
+			suffrage = raft.Staging
+		default:
+			return nil, fmt.Errorf("snapshot %s: invalid suffrage %q", snapshot.ID, server.Suffrage)
+		}
+		meta.Configuration.Servers = append(meta.Configuration.Servers, raft.Server{Suffrage: suffrage,
+			ID: raft.ServerID(server.ID), Address: raft.ServerAddress(server.Address)})
+	}
+	return meta, nil
+}
+
+// checksumState reads the JSON text of a state and returns its checksum and
+// its size in compact form
+func checksumState(state io.Reader) (string, int64, error) {
+	hash := sha256.New()
+	var size countWriter
+	compact := newJSONFormatter(io.MultiWriter(hash, &size), "", 0)
+	if _, err := io.Copy(compact, state); err != nil {
+		return "", 0, err
+	}
+	if err := compact.Flush(); err != nil {
+		return "", 0, err
+	}
+	return "sha256:" + hex.EncodeToString(hash.Sum(nil)), int64(size), nil
+}
+
+// JsonSnapshotStore implements raft.SnapshotStore with one indented JSON
+// document per snapshot, keeping the newest retain snapshots
+type JsonSnapshotStore struct {
+	dir         string
+	retain      int
+	logger      hclog.Logger
+	lock        sync.Mutex
+	compression Compression
+}
+
+// NewJsonSnapshotStore keeps the snapshots in the snapshots subdirectory of
+// dir. The latest snapshot written by raft.FileSnapshotStore in the same
+// directory is converted if there is no JSON snapshot yet.
+func NewJsonSnapshotStore(dir string, retain int, logger hclog.Logger) (*JsonSnapshotStore, error) {
+	if retain < 1 {
+		return nil, fmt.Errorf("must retain at least one snapshot")
+	}
+	store := &JsonSnapshotStore{dir: filepath.Join(dir, snapshotsDir), retain: retain, logger: logger}
+	if err := os.MkdirAll(store.dir, 0755); err != nil {
+		return nil, err
+	}
+	if err := store.removeTemporary(); err != nil {
+		return nil, err
+	}
+	if err := store.convertFileSnapshot(dir); err != nil {
+		return nil, err
+	}
+	return store, nil
+}
+
+// removeTemporary removes the documents and the spooled states left by
+// snapshots which were being written when the node stopped
+func (store *JsonSnapshotStore) removeTemporary() error {
+	entries, err := os.ReadDir(store.dir)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.Contains(entry.Name(), ".tmp") {
+			continue
+		}
+		if err := os.Remove(filepath.Join(store.dir, entry.Name())); err != nil {
+			return err
+		}
+		store.logger.Info("Removed unfinished snapshot file", "file", entry.Name())
+	}
+	return nil
+}
+
+// convertFileSnapshot rewrites the latest snapshot of a FileSnapshotStore
+// as a JSON snapshot. The snapshot directories are left in place.
+func (store *JsonSnapshotStore) convertFileSnapshot(dir string) error {
+	if snapshots, err := store.List(); err != nil || len(snapshots) > 0 {
+		return err
+	}
+	entries, err := os.ReadDir(store.dir)
+	if err != nil {
+		return err
+	}
+	legacy := false
+	for _, entry := range entries {
+		legacy = legacy || entry.IsDir()
+	}
+	if !legacy {
+		return nil
+	}
+	filestore, err := raft.NewFileSnapshotStoreWithLogger(dir, store.retain, store.logger)
+	if err != nil {
+		return err
+	}
+	snapshots, err := filestore.List()
+	if err != nil || len(snapshots) == 0 {
+		return err
+	}
+	meta, source, err := filestore.Open(snapshots[0].ID)
+	if err != nil {
+		return err
+	}
+	defer source.Close()
+	sink, err := store.Create(meta.Version, meta.Index, meta.Term, meta.Configuration, meta.ConfigurationIndex, nil)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(sink, source); err != nil {
+		sink.Cancel()
+		return err
+	}
+	if err := sink.Close(); err != nil {
+		return err
+	}
+	store.logger.Info("Converted snapshot to JSON", "snapshot", meta.ID, "converted", sink.ID())
+	return nil
+}
+
+// SetCompression sets the compression of the snapshots created from now
+// on. Existing snapshots are read whatever they were written with.
+func (store *JsonSnapshotStore) SetCompression(compression Compression) {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+	store.compression = compression
+}
+
+// Suffixes of the snapshot files
+const (
+	jsonSuffix = ".json"
+	gzipSuffix = ".json.gz"
+)
+
+// path returns the file of a snapshot written with compression
+func (store *JsonSnapshotStore) path(id string, compression Compression) string {
+	if compression == CompressionGzip {
+		return filepath.Join(store.dir, id+gzipSuffix)
+	}
+	return filepath.Join(store.dir, id+jsonSuffix)
+}
+
+// find returns the file of an existing snapshot
+func (store *JsonSnapshotStore) find(id string) (string, error) {
+	path := store.path(id, CompressionGzip)
+	if _, err := os.Stat(path); err == nil {
+		return path, nil
+	}
+	path = store.path(id, CompressionNone)
+	_, err := os.Stat(path)
+	return path, err
+}
+
+// openDocument opens the file of a snapshot and decompresses it
+func (store *JsonSnapshotStore) openDocument(id string) (io.Reader, *os.File, error) {
+	path, err := store.find(id)
+	if err != nil {
+		return nil, nil, err
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	reader, _, err := Decompress(file)
+	if err != nil {
+		file.Close()
+		return nil, nil, err
+	}
+	return reader, file, nil
+}
+
+// openState returns the text of the document between start and end,
+// offsets in the decompressed document. The state comes right after the
+// metadata, so skipping to it is cheap.
+func (store *JsonSnapshotStore) openState(id string, start, end int64) (io.Reader, *os.File, error) {
+	reader, file, err := store.openDocument(id)
+	if err != nil {
+		return nil, nil, err
+	}
+	if _, err := io.CopyN(io.Discard, reader, start); err != nil {
+		file.Close()
+		return nil, nil, err
+	}
+	state, err := stateReader(io.LimitReader(reader, end-start))
+	if err != nil {
+		file.Close()
+		return nil, nil, err
+	}
+	return state, file, nil
+}
+
+// Create starts a snapshot, written when the sink is closed
+func (store *JsonSnapshotStore) Create(version raft.SnapshotVersion, index, term uint64,
+	configuration raft.Configuration, configurationIndex uint64, trans raft.Transport) (raft.SnapshotSink, error) {
+	if version != raft.SnapshotVersionMax {
+		return nil, fmt.Errorf("unsupported snapshot version %d", version)
+	}
+	now := time.Now()
+	snapshot := &JsonSnapshot{Version: jsonSnapshotVersion,
+		ID:    fmt.Sprintf("%d-%d-%d", term, index, now.UnixMilli()),
+		Index: index, Term: term, ConfigurationIndex: configurationIndex, Created: now.UTC(),
+		Configuration: []SnapshotServer{}}
+	store.lock.Lock()
+	if store.compression == CompressionGzip {
+		snapshot.Codec = CompressionGzip
+	}
+	store.lock.Unlock()
+	for _, server := range configuration.Servers {
+		snapshot.Configuration = append(snapshot.Configuration, SnapshotServer{ID: string(server.ID),
+			Address: string(server.Address), Suffrage: server.Suffrage.String()})
+	}
+	return &jsonSnapshotSink{store: store, snapshot: snapshot}, nil
+}
+
+// List returns the snapshots, newest first
+func (store *JsonSnapshotStore) List() ([]*raft.SnapshotMeta, error) {
+	snapshots, err := store.read()
+	if err != nil {
+		return nil, err
+	}
+	metas := make([]*raft.SnapshotMeta, 0, len(snapshots))
+	for _, snapshot := range snapshots {
+		meta, err := snapshot.meta()
+		if err != nil {
+			return nil, err
+		}
+		metas = append(metas, meta)
+	}
+	return metas, nil
+}
+
+// read loads the metadata of the snapshots, newest first
+func (store *JsonSnapshotStore) read() ([]*JsonSnapshot, error) {
+	entries, err := os.ReadDir(store.dir)
+	if err != nil {
+		return nil, err
+	}
+	var snapshots []*JsonSnapshot
+	for _, entry := range entries {
+		id, ok := strings.CutSuffix(entry.Name(), jsonSuffix)
+		if !ok {
+			id, ok = strings.CutSuffix(entry.Name(), gzipSuffix)
+		}
+		if !ok || entry.IsDir() {
+			continue
+		}
+		snapshot, _, err := store.load(id)
+		if err != nil {
+			store.logger.Warn("Skipping unreadable snapshot", "snapshot", id, "Error", err)
+			continue
+		}
+		snapshots = append(snapshots, snapshot)
+	}
+	sort.Slice(snapshots, func(i, j int) bool {
+		if snapshots[i].Term != snapshots[j].Term {
+			return snapshots[i].Term > snapshots[j].Term
+		}
+		if snapshots[i].Index != snapshots[j].Index {
+			return snapshots[i].Index > snapshots[j].Index
+		}
+		return snapshots[i].ID > snapshots[j].ID
+	})
+	return snapshots, nil
+}
+
+// load reads the metadata of a snapshot and returns the offset of its
+// state, the colon after the member name. The metadata is written before
+// the state, so reading stops there; the state of a document edited to put
+// members after it is skipped token by token.
+func (store *JsonSnapshotStore) load(id string) (snapshot *JsonSnapshot, start int64, err error) {
+	reader, file, err := store.openDocument(id)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer file.Close()
+	decoder := json.NewDecoder(reader)
+	if err := expectDelim(decoder, '{'); err != nil {
+		return nil, 0, err
+	}
+	members := make(map[string]json.RawMessage)
+	start = -1
+	for decoder.More() {
+		token, err := decoder.Token()
+		if err != nil {
+			return nil, 0, err
+		}
+		name, _ := token.(string)
+		if name == stateMember {
+			start = decoder.InputOffset()
+			if _, ok := members["checksum"]; ok {
+				break
+			}
+			if err := skipValue(decoder); err != nil {
+				return nil, 0, err
+			}
+			continue
+		}
+		var value json.RawMessage
+		if err := decoder.Decode(&value); err != nil {
+			return nil, 0, err
+		}
+		members[name] = value
+	}
+	if start < 0 {
+		return nil, 0, fmt.Errorf("no %s in the snapshot", stateMember)
+	}
+	header, err := json.Marshal(members)
+	if err != nil {
+		return nil, 0, err
+	}
+	snapshot = &JsonSnapshot{}
+	if err := json.Unmarshal(header, snapshot); err != nil {
+		return nil, 0, err
+	}
+	if snapshot.Version != jsonSnapshotVersion {
+		return nil, 0, fmt.Errorf("unsupported snapshot format %d", snapshot.Version)
+	}
+	return snapshot, start, nil
+}
+
+// stateEnd skips the state starting at start token by token and returns
+// the offset of its end
+func (store *JsonSnapshotStore) stateEnd(id string, start int64) (int64, error) {
+	reader, file, err := store.openDocument(id)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+	if _, err := io.CopyN(io.Discard, reader, start); err != nil {
+		return 0, err
+	}
+	state := bufio.NewReader(reader)
+	colon, err := state.ReadString(':')
+	if err != nil {
+		return 0, err
+	}
+	decoder := json.NewDecoder(state)
+	if err := skipValue(decoder); err != nil {
+		return 0, err
+	}
+	return start + int64(len(colon)) + decoder.InputOffset(), nil
+}
+
+// stateReader skips the colon in front of the state
+func stateReader(section io.Reader) (io.Reader, error) {
+	reader := bufio.NewReader(section)
+	if _, err := reader.ReadString(':'); err != nil {
+		return nil, err
+	}
+	return reader, nil
+}
+
+// verify loads the metadata of a snapshot, locates its state, which spans
+// from start to end, and checks its checksum
+func (store *JsonSnapshotStore) verify(id string) (snapshot *JsonSnapshot, start, end int64, err error) {
+	snapshot, start, err = store.load(id)
+	if err != nil {
+		return nil, 0, 0, err
+	}
+	if end, err = store.stateEnd(id, start); err != nil {
+		return nil, 0, 0, err
+	}
+	state, file, err := store.openState(id, start, end)
+	if err != nil {
+		return nil, 0, 0, err
+	}
+	defer file.Close()
+	checksum, size, err := checksumState(state)
+	if err != nil {
+		return nil, 0, 0, err
+	}
+	if checksum != snapshot.Checksum {
+		return nil, 0, 0, fmt.Errorf("snapshot %s: %w", id, ErrSnapshotChecksum)
+	}
+	snapshot.Size = size
+	return snapshot, start, end, nil
+}
+
+// Open returns a reader streaming the state from the file in compact form,
+// Size bytes long as raft expects. The checksum is computed along the way;
+// on a mismatch the reader fails with ErrSnapshotChecksum instead of
+// returning the last byte, so a restore reading it fails too.
+func (store *JsonSnapshotStore) Open(id string) (*raft.SnapshotMeta, io.ReadCloser, error) {
+	snapshot, start, err := store.load(id)
+	if err != nil {
+		return nil, nil, err
+	}
+	meta, err := snapshot.meta()
+	if err != nil {
+		return nil, nil, err
+	}
+	reader, file, err := store.openDocument(id)
+	if err != nil {
+		return nil, nil, err
+	}
+	if _, err := io.CopyN(io.Discard, reader, start); err != nil {
+		file.Close()
+		return nil, nil, err
+	}
+	state, err := stateReader(reader)
+	if err != nil {
+		file.Close()
+		return nil, nil, err
+	}
+	pipereader, pipewriter := io.Pipe()
+	go func() {
+		hash := sha256.New()
+		var size countWriter
+		held := &holdLastWriter{w: pipewriter}
+		compact := newJSONFormatter(io.MultiWriter(held, hash, &size), "", 0)
+		_, err := io.Copy(compact, newValueReader(state))
+		if err == nil {
+			err = compact.Flush()
+		}
+		if err == nil && ("sha256:"+hex.EncodeToString(hash.Sum(nil)) != snapshot.Checksum ||
+			int64(size) != snapshot.Size) {
+			err = fmt.Errorf("snapshot %s: %w", id, ErrSnapshotChecksum)
+		}
+		if err == nil {
+			err = held.Release()
+		}
+		pipewriter.CloseWithError(err)
+	}()
+	return meta, &snapshotReader{PipeReader: pipereader, file: file}, nil
+}
+
+// Latest returns the metadata of the newest snapshot, nil if there is none
+func (store *JsonSnapshotStore) Latest() (*JsonSnapshot, error) {
+	snapshots, err := store.read()
+	if err != nil || len(snapshots) == 0 {
+		return nil, err
+	}
+	return snapshots[0], nil
+}
+
+// OpenFile verifies the checksum of a snapshot, then opens its file as it
+// is stored, compressed or not, to copy it out of the store
+func (store *JsonSnapshotStore) OpenFile(id string) (*JsonSnapshot, io.ReadCloser, error) {
+	snapshot, _, _, err := store.verify(id)
+	if err != nil {
+		return nil, nil, err
+	}
+	path, err := store.find(id)
+	if err != nil {
+		return nil, nil, err
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	return snapshot, file, nil
+}
+
+// snapshotReader closes the file of a snapshot along with the pipe
+// streaming it
+type snapshotReader struct {
+	*io.PipeReader
+	file *os.File
+}
+
+func (reader *snapshotReader) Close() error {
+	reader.PipeReader.Close()
+	return reader.file.Close()
+}
+
+// reap removes the snapshots beyond the retained ones
+func (store *JsonSnapshotStore) reap() error {
+	snapshots, err := store.read()
+	if err != nil {
+		return err
+	}
+	for i := store.retain; i < len(snapshots); i++ {
+		path, err := store.find(snapshots[i].ID)
+		if err == nil {
+			err = os.Remove(path)
+		}
+		if err != nil {
+			return err
+		}
+		store.logger.Info("Removed snapshot", "snapshot", snapshots[i].ID)
+	}
+	return nil
+}
+
+// jsonSnapshotSink spools the FSM contents to a file and writes the
+// document on Close
+type jsonSnapshotSink struct {
+	store    *JsonSnapshotStore
+	snapshot *JsonSnapshot
+	spool    *os.File
+	writer   *bufio.Writer
+	done     bool
+}
+
+func (sink *jsonSnapshotSink) Write(data []byte) (int, error) {
+	if sink.spool == nil {
+		spool, err := os.CreateTemp(sink.store.dir, sink.snapshot.ID+".state.tmp")
+		if err != nil {
+			return 0, err
+		}
+		sink.spool = spool
+		sink.writer = bufio.NewWriter(spool)
+	}
+	return sink.writer.Write(data)
+}
+
+func (sink *jsonSnapshotSink) ID() string {
+	return sink.snapshot.ID
+}
+
+func (sink *jsonSnapshotSink) Cancel() error {
+	sink.done = true
+	sink.removeSpool()
+	return nil
+}
+
+func (sink *jsonSnapshotSink) removeSpool() {
+	if sink.spool != nil {
+		sink.spool.Close()
+		os.Remove(sink.spool.Name())
+		sink.spool = nil
+	}
+}
+
+func (sink *jsonSnapshotSink) Close() error {
+	if sink.done {
+		return nil
+	}
+	sink.done = true
+	defer sink.removeSpool()
+	if sink.spool == nil {
+		return fmt.Errorf("snapshot %s: no fsm state written", sink.snapshot.ID)
+	}
+	if err := sink.writer.Flush(); err != nil {
+		return err
+	}
+
+	// Check that the state is one JSON value while computing its checksum
+	if _, err := sink.spool.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	pipereader, pipewriter := io.Pipe()
+	checksummed := make(chan error, 1)
+	go func() {
+		var err error
+		sink.snapshot.Checksum, sink.snapshot.Size, err = checksumState(pipereader)
+		pipereader.CloseWithError(err)
+		checksummed <- err
+	}()
+	decoder := json.NewDecoder(io.TeeReader(bufio.NewReader(sink.spool), pipewriter))
+	err := skipValue(decoder)
+	if err == nil {
+		if _, terr := decoder.Token(); terr != io.EOF {
+			err = errors.New("trailing data")
+		}
+	}
+	pipewriter.CloseWithError(err)
+	if cerr := <-checksummed; err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return fmt.Errorf("snapshot %s: fsm state is not JSON: %w", sink.snapshot.ID, err)
+	}
+
+	header, err := json.MarshalIndent(sink.snapshot, "", "  ")
+	if err != nil {
+		return err
+	}
+	store := sink.store
+	store.lock.Lock()
+	defer store.lock.Unlock()
+	// A partial file is never listed
+	err = WriteFileAtomic(store.path(sink.snapshot.ID, sink.snapshot.Codec), func(w io.Writer) error {
+		return sink.writeDocument(w, header)
+	})
+	if err != nil {
+		return err
+	}
+	return store.reap()
+}
+
+// writeDocument writes the metadata followed by the indented state,
+// compressed with the codec of the snapshot
+func (sink *jsonSnapshotSink) writeDocument(file io.Writer, header []byte) error {
+	if _, err := sink.spool.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	compressed := compressor(file, sink.snapshot.Codec)
+	writer := bufio.NewWriter(compressed)
+	// Reopen the object closed by MarshalIndent
+	writer.Write(bytes.TrimSuffix(header, []byte("\n}")))
+	fmt.Fprintf(writer, ",\n  %q: ", stateMember)
+	indented := newJSONFormatter(writer, "  ", 1)
+	if _, err := io.Copy(indented, sink.spool); err != nil {
+		return err
+	}
+	if err := indented.Flush(); err != nil {
+		return err
+	}
+	writer.WriteString("\n}\n")
+	if err := writer.Flush(); err != nil {
+		return err
+	}
+	return compressed.Close()
+}
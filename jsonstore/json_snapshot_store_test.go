@@ -0,0 +1,228 @@
+package jsonstore
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	hclog "github.com/hashicorp/go-hclog"
+	"github.com/hashicorp/raft"
+)
+
+func writeSnapshot(t *testing.T, store raft.SnapshotStore, index uint64, state string) string {
+	configuration := raft.Configuration{Servers: []raft.Server{
+		{Suffrage: raft.Voter, ID: "id1", Address: "127.0.0.1:7000"},
+		{Suffrage: raft.Nonvoter, ID: "id2", Address: "127.0.0.1:7001"}}}
+	_, transport := raft.NewInmemTransport("")
+	sink, err := store.Create(raft.SnapshotVersionMax, index, 2, configuration, 1, transport)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := sink.Write([]byte(state)); err != nil {
+		t.Fatal(err)
+	}
+	if err := sink.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return sink.ID()
+}
+
+// readState opens a snapshot and reads its state
+func readState(store *JsonSnapshotStore, id string) ([]byte, error) {
+	_, reader, err := store.Open(id)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+	return io.ReadAll(reader)
+}
+
+func TestJsonSnapshotStore(t *testing.T) {
+	dir := t.TempDir()
+	store, err := NewJsonSnapshotStore(dir, 2, hclog.NewNullLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for index := uint64(10); index <= 30; index += 10 {
+		writeSnapshot(t, store, index, `{"keyvals": {"a": "1"}, "httplisteners": {}}`)
+	}
+	snapshots, err := store.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(snapshots) != 2 || snapshots[0].Index != 30 || snapshots[1].Index != 20 {
+		t.Fatalf("Expected the 2 newest snapshots, got %+v", snapshots)
+	}
+	meta, reader, err := store.Open(snapshots[0].ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ := io.ReadAll(reader)
+	if string(data) != `{"keyvals":{"a":"1"},"httplisteners":{}}` || meta.Size != int64(len(data)) {
+		t.Fatalf("Unexpected state %s", data)
+	}
+	if len(meta.Configuration.Servers) != 2 || meta.Configuration.Servers[1].Suffrage != raft.Nonvoter ||
+		meta.ConfigurationIndex != 1 {
+		t.Fatalf("Unexpected configuration %+v", meta.Configuration)
+	}
+
+	// Editing the state is detected while it is read, restoring it fails
+	path, _ := store.find(snapshots[0].ID)
+	document, _ := os.ReadFile(path)
+	os.WriteFile(path, []byte(strings.Replace(string(document), `"1"`, `"2"`, 1)), 0600)
+	if _, err := readState(store, snapshots[0].ID); !errors.Is(err, ErrSnapshotChecksum) {
+		t.Fatal("Expected ErrSnapshotChecksum, got", err)
+	}
+	fsm, err := NewFsm(hclog.NewNullLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, reader, err := store.Open(snapshots[0].ID); err != nil {
+		t.Fatal(err)
+	} else if err := fsm.Restore(reader); !errors.Is(err, ErrSnapshotChecksum) {
+		t.Fatal("Expected the restore to fail with ErrSnapshotChecksum, got", err)
+	}
+
+	// Listing reads the metadata only, a broken state is found when opening
+	path, _ = store.find(snapshots[1].ID)
+	document, _ = os.ReadFile(path)
+	os.WriteFile(path, document[:len(document)-20], 0600)
+	if snapshots, err := store.List(); err != nil || len(snapshots) != 2 {
+		t.Fatalf("Expected the 2 snapshots to be listed, got %+v: %v", snapshots, err)
+	}
+	if _, err := readState(store, snapshots[1].ID); err == nil {
+		t.Fatal("Truncated snapshot read")
+	}
+
+	// Members after the state are still read
+	id := writeSnapshot(t, store, 40, `{"keyvals": {"b": "2"}, "httplisteners": {}}`)
+	path, _ = store.find(id)
+	var members map[string]json.RawMessage
+	document, _ = os.ReadFile(path)
+	json.Unmarshal(document, &members)
+	reordered := `{"state": ` + string(members[stateMember]) + `, "checksum": ` + string(members["checksum"])
+	delete(members, stateMember)
+	delete(members, "checksum")
+	for name, value := range members {
+		reordered += `, "` + name + `": ` + string(value)
+	}
+	os.WriteFile(path, []byte(reordered+"}"), 0600)
+	if _, reader, err := store.Open(id); err != nil {
+		t.Fatal(err)
+	} else {
+		data, _ := io.ReadAll(reader)
+		reader.Close()
+		if string(data) != `{"keyvals":{"b":"2"},"httplisteners":{}}` {
+			t.Fatalf("Unexpected state %s", data)
+		}
+	}
+}
+
+func TestJsonSnapshotStoreRemovesTemporaryFiles(t *testing.T) {
+	dir := t.TempDir()
+	store, err := NewJsonSnapshotStore(dir, 2, hclog.NewNullLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+	id := writeSnapshot(t, store, 10, `{"keyvals": {}}`)
+	// Left by a crash while writing the document and while spooling the state
+	for _, name := range []string{"2-20-1.json.tmp", "2-20-1.json.gz.tmp", "2-30-1.state.tmp123456"} {
+		os.WriteFile(filepath.Join(dir, snapshotsDir, name), []byte("{"), 0600)
+	}
+	if _, err := NewJsonSnapshotStore(dir, 2, hclog.NewNullLogger()); err != nil {
+		t.Fatal(err)
+	}
+	entries, _ := os.ReadDir(filepath.Join(dir, snapshotsDir))
+	if len(entries) != 1 || entries[0].Name() != id+jsonSuffix {
+		t.Fatalf("Expected only the snapshot to be left, got %v", entries)
+	}
+}
+
+func TestJsonSnapshotStoreCompression(t *testing.T) {
+	dir := t.TempDir()
+	store, err := NewJsonSnapshotStore(dir, 3, hclog.NewNullLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+	plain := writeSnapshot(t, store, 10, `{"keyvals": {"a": "1"}}`)
+	store.SetCompression(CompressionGzip)
+	compressed := writeSnapshot(t, store, 20, `{"keyvals": {"a": "2"}}`)
+	if path, err := store.find(compressed); err != nil || !strings.HasSuffix(path, ".json.gz") {
+		t.Fatal("Compressed snapshot not found", path, err)
+	}
+
+	// Both are read whatever the current setting
+	store.SetCompression(CompressionNone)
+	for id, expected := range map[string]string{plain: `{"keyvals":{"a":"1"}}`,
+		compressed: `{"keyvals":{"a":"2"}}`} {
+		meta, reader, err := store.Open(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, _ := io.ReadAll(reader)
+		reader.Close()
+		if string(data) != expected || meta.Size != int64(len(data)) {
+			t.Fatalf("Unexpected state %s for %s", data, id)
+		}
+	}
+	snapshots, err := store.List()
+	if err != nil || len(snapshots) != 2 || snapshots[0].ID != compressed {
+		t.Fatalf("Unexpected snapshots %+v", snapshots)
+	}
+}
+
+func TestJsonSnapshotStoreConvertsFileSnapshots(t *testing.T) {
+	dir := t.TempDir()
+	filestore, err := raft.NewFileSnapshotStoreWithLogger(dir, 2, hclog.NewNullLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeSnapshot(t, filestore, 5, `{"keyvals": {"b": "2"}}`)
+
+	store, err := NewJsonSnapshotStore(dir, 2, hclog.NewNullLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+	snapshots, err := store.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(snapshots) != 1 || snapshots[0].Index != 5 || snapshots[0].Term != 2 {
+		t.Fatalf("Snapshot not converted: %+v", snapshots)
+	}
+	_, reader, err := store.Open(snapshots[0].ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ := io.ReadAll(reader)
+	if string(data) != `{"keyvals":{"b":"2"}}` {
+		t.Fatalf("Unexpected state %s", data)
+	}
+}
+
+func TestJsonFormatter(t *testing.T) {
+	input := `{ "a \"}{ ": [1, 2.5e3, true, null, {}, [ ]],` + "\n\t" + `"b\\": {"c": "  x  ", "d": {"e": []}}}`
+	for _, indent := range []string{"", "  "} {
+		var expected bytes.Buffer
+		if indent == "" {
+			json.Compact(&expected, []byte(input))
+		} else {
+			json.Indent(&expected, []byte(input), "", indent)
+		}
+		var formatted bytes.Buffer
+		formatter := newJSONFormatter(&formatted, indent, 0)
+		// Byte by byte, the formatter must keep its state across writes
+		for i := range input {
+			formatter.Write([]byte{input[i]})
+		}
+		formatter.Flush()
+		if formatted.String() != expected.String() {
+			t.Fatalf("Expected\n%s\ngot\n%s", expected.String(), formatted.String())
+		}
+	}
+}
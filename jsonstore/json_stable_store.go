@@ -1,9 +1,7 @@
 package jsonstore
 
 import (
-	"encoding/json"
-	"io"
-	"os"
+	"fmt"
 	"strconv"
 	"sync"
 )
@@ -12,41 +10,48 @@ type JsonStableStore struct {
 	jsonfilepath string
 	kv           map[string]string
 	lock         sync.Mutex
+
+	// Codec of the file, it is told by the header of the file
+	codec Codec
 }
 
 func NewJsonStableStore(jsonfilepath string) (js *JsonStableStore, err error) {
 	kv := make(map[string]string)
-	_, err = os.Stat(jsonfilepath)
-	if err == nil {
-		file, err := os.OpenFile(jsonfilepath, os.O_RDONLY, 0600)
-		if err != nil {
-			panic(err)
-		}
-		data, err := io.ReadAll(file)
-		err = json.Unmarshal(data, &kv)
-		if err != nil {
-			panic(err)
+	document, codec, _, err := readStore(jsonfilepath)
+	if err != nil {
+		return nil, err
+	}
+	if document != nil {
+		if err = codec.unmarshal(document, &kv); err != nil {
+			return nil, fmt.Errorf("%s: %w", jsonfilepath, err)
 		}
 	}
-	js = &JsonStableStore{jsonfilepath: jsonfilepath, kv: kv, lock: sync.Mutex{}}
-	err = nil
+	js = &JsonStableStore{jsonfilepath: jsonfilepath, kv: kv, lock: sync.Mutex{}, codec: codec}
 	return
 }
 
 func (js *JsonStableStore) Set(key []byte, value []byte) error {
-	js.lock.Lock()
-	defer js.lock.Unlock()
-	js.kv[string(key)] = string(value)
-	js.save()
-	return nil
+	return js.set(string(key), string(value))
 }
 
 func (js *JsonStableStore) SetUint64(key []byte, value uint64) error {
+	return js.set(string(key), strconv.FormatUint(value, 10))
+}
+
+// set saves the store with the new value, the previous one is kept if the
+// file can not be written
+func (js *JsonStableStore) set(key, value string) error {
 	js.lock.Lock()
 	defer js.lock.Unlock()
-	js.kv[string(key)] = strconv.FormatUint(value, 10)
-	js.save()
-	return nil
+	previous, existed := js.kv[key]
+	js.kv[key] = value
+	err := js.save()
+	if err != nil && existed {
+		js.kv[key] = previous
+	} else if err != nil {
+		delete(js.kv, key)
+	}
+	return err
 }
 
 func (js *JsonStableStore) Get(key []byte) (value []byte, err error) {
@@ -78,10 +83,35 @@ func (js *JsonStableStore) GetUint64(key []byte) (value uint64, err error) {
 	return
 }
 
-func (js *JsonStableStore) save() (err error) {
-	data, err := json.Marshal(js.kv)
-	if err == nil {
-		err = os.WriteFile(js.jsonfilepath, data, 0600)
+// Close writes the store to its file a last time
+func (js *JsonStableStore) Close() error {
+	js.lock.Lock()
+	defer js.lock.Unlock()
+	return js.save()
+}
+
+// SetCodec sets the codec used from the next write on
+func (js *JsonStableStore) SetCodec(codec Codec) {
+	js.lock.Lock()
+	defer js.lock.Unlock()
+	js.codec = codec
+}
+
+// save writes the file through a temporary file, so that a crash never
+// leaves a truncated store
+func (js *JsonStableStore) save() error {
+	data, err := js.encode()
+	if err != nil {
+		return err
 	}
-	return
+	return writeStoreFile(js.jsonfilepath, data)
+}
+
+// encode lays out the file with the codec of the store, uncompressed
+func (js *JsonStableStore) encode() ([]byte, error) {
+	document, err := js.codec.marshal(js.kv)
+	if err != nil {
+		return nil, err
+	}
+	return encodeStore(js.codec, CompressionNone, document)
 }
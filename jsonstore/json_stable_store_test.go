@@ -0,0 +1,40 @@
+package jsonstore
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestJsonStableStore(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, stableStoreFile)
+	store, err := NewJsonStableStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.SetUint64([]byte(currentTermKey), 3); err != nil {
+		t.Fatal(err)
+	}
+	if store, err = NewJsonStableStore(path); err != nil {
+		t.Fatal(err)
+	}
+	if term, err := store.GetUint64([]byte(currentTermKey)); err != nil || term != 3 {
+		t.Fatalf("Unexpected term %d: %v", term, err)
+	}
+
+	// A failed write is reported and leaves the store as it was
+	os.Mkdir(path+".tmp", 0755)
+	if err := store.SetUint64([]byte(currentTermKey), 4); err == nil {
+		t.Fatal("Expected the write to fail")
+	}
+	if term, err := store.GetUint64([]byte(currentTermKey)); err != nil || term != 3 {
+		t.Fatalf("Unexpected term %d: %v", term, err)
+	}
+	if err := store.Set([]byte("other"), []byte("x")); err == nil {
+		t.Fatal("Expected the write to fail")
+	}
+	if _, err := store.Get([]byte("other")); err != ErrKeyNotFound {
+		t.Fatal("Expected ErrKeyNotFound, got", err)
+	}
+}
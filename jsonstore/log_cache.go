@@ -0,0 +1,80 @@
+package jsonstore
+
+import (
+	"container/list"
+
+	"github.com/hashicorp/raft"
+)
+
+// Memory taken by a cached entry besides its data
+const cachedLogOverhead = 128
+
+// logCache keeps the recently used log entries, as long as their total
+// size stays within the limit
+type logCache struct {
+	limit int64
+	size  int64
+
+	// Most recently used first
+	order   *list.List
+	entries map[uint64]*list.Element
+}
+
+func newLogCache(limit int64) *logCache {
+	return &logCache{limit: limit, order: list.New(), entries: make(map[uint64]*list.Element)}
+}
+
+func logCost(log *raft.Log) int64 {
+	return int64(len(log.Data)+len(log.Extensions)) + cachedLogOverhead
+}
+
+// get returns the entry at index, nil if it is not cached
+func (cache *logCache) get(index uint64) *raft.Log {
+	element, found := cache.entries[index]
+	if !found {
+		return nil
+	}
+	cache.order.MoveToFront(element)
+	return element.Value.(*raft.Log)
+}
+
+func (cache *logCache) add(log *raft.Log) {
+	if element, found := cache.entries[log.Index]; found {
+		cache.size -= logCost(element.Value.(*raft.Log))
+		element.Value = log
+		cache.order.MoveToFront(element)
+	} else {
+		cache.entries[log.Index] = cache.order.PushFront(log)
+	}
+	cache.size += logCost(log)
+	cache.evict()
+}
+
+// removeRange drops the entries from min to max
+func (cache *logCache) removeRange(min, max uint64) {
+	for element := cache.order.Front(); element != nil; {
+		next := element.Next()
+		if log := element.Value.(*raft.Log); log.Index >= min && log.Index <= max {
+			cache.remove(element)
+		}
+		element = next
+	}
+}
+
+func (cache *logCache) setLimit(limit int64) {
+	cache.limit = limit
+	cache.evict()
+}
+
+// evict drops the least recently used entries until the cache fits
+func (cache *logCache) evict() {
+	for cache.size > cache.limit && cache.order.Len() > 0 {
+		cache.remove(cache.order.Back())
+	}
+}
+
+func (cache *logCache) remove(element *list.Element) {
+	log := cache.order.Remove(element).(*raft.Log)
+	delete(cache.entries, log.Index)
+	cache.size -= logCost(log)
+}
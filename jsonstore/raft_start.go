@@ -1,9 +1,11 @@
 package jsonstore
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"io"
+	"sync/atomic"
 	"time"
 
 	hclog "github.com/hashicorp/go-hclog"
@@ -23,6 +25,12 @@ type Server struct {
 
 	//Is the node the leader
 	Leader  bool
+
+	//Address of the http listener of the node
+	HttpAddress string `json:",omitempty"`
+
+	//Voter or Nonvoter
+	Suffrage string `json:",omitempty"`
 }
 
 // Application interface to play with RAFT
@@ -55,7 +63,7 @@ type RaftInterface struct {
 	logstore      *JsonLogStore
 
 	// JSON file based snapshotstore provider
-	snapshotstore *raft.FileSnapshotStore
+	snapshotstore *JsonSnapshotStore
 
 	// State machine for the Key and Values
 	fsm           *Fsm
@@ -63,70 +71,255 @@ type RaftInterface struct {
 	// RAFT configurations
 	config        *raft.Config
 
+	// Time allowed for writes and membership changes to commit
+	applytimeout time.Duration
+
 	//Logger for application logs
 	logger        hclog.Logger
+
+	// Set while the node is drained for maintenance
+	draining atomic.Bool
+
+	// Number of client writes waiting for raft
+	inflight atomic.Int64
+
+	// Locked data directory, nil if the stores have their own paths
+	datadir *DataDir
+
+	// Publishes the observations of the RAFT node
+	events *eventHub
+
+	// Coalesces the client writes into log entries
+	pipeline *applyPipeline
+}
+
+// Settings for creating a RaftInterface
+type RaftOptions struct {
+	// Configuration of the RAFT nodes, used when bootstrapping from config
+	ConfigFile string
+
+	// Servers to bootstrap from config with, read from ConfigFile if nil
+	Configuration *raft.Configuration
+
+	// Directory where the RAFT logs are stored in segment files. A log
+	// store file of older versions at this path is turned into segments.
+	LogStoreFile string
+
+	// File where the stable store keeps the RAFT configs
+	StableStoreFile string
+
+	// Directory where the snapshots will be dumped
+	SnapshotDir string
+
+	// Directory for all the state of the node, see DataDir. It replaces
+	// LogStoreFile, StableStoreFile and SnapshotDir when set.
+	DataDir string
+
+	// Address to be used for RAFT traffic
+	Transport string
+
+	// Id assigned to this RAFT node
+	ServerID string
+
+	// How the cluster is bootstrapped, BootstrapFromConfig if empty
+	Bootstrap BootstrapMode
+
+	// Id of the cluster, derived from the bootstrap configuration if empty
+	ClusterID string
+
+	// RAFT and transport settings, DefaultTuning() if nil
+	Tuning *Tuning
 }
 
 //  Creates a new RaftInterface object
 func NewRaftInterface(configfile, logstorefile, stablestorefile, snapshotstoredir,
 	transport string, serverid string, logger hclog.Logger, writer io.Writer) (*RaftInterface, error) {
-	configuration, err := BootstrapConfig(configfile)
+	return NewRaftInterfaceWithOptions(RaftOptions{ConfigFile: configfile, LogStoreFile: logstorefile,
+		StableStoreFile: stablestorefile, SnapshotDir: snapshotstoredir, Transport: transport,
+		ServerID: serverid}, logger, writer)
+}
+
+// Creates a new RaftInterface object from options
+func NewRaftInterfaceWithOptions(options RaftOptions, logger hclog.Logger,
+	writer io.Writer) (*RaftInterface, error) {
+	if options.DataDir == "" {
+		return newRaftInterface(options, nil, logger, writer)
+	}
+	datadir, err := OpenDataDir(options.DataDir, options.ServerID)
 	if err != nil {
 		return nil, err
 	}
-	stablestore, err := NewJsonStableStore(stablestorefile)
+	options.LogStoreFile = datadir.LogStoreDir()
+	options.StableStoreFile = datadir.StableStoreFile()
+	options.SnapshotDir = datadir.SnapshotDir()
+	raftin, err := newRaftInterface(options, datadir, logger, writer)
 	if err != nil {
+		datadir.Close()
 		return nil, err
 	}
+	return raftin, nil
+}
 
-	logstore, err := NewJsonLogStore(logstorefile)
+func newRaftInterface(options RaftOptions, datadir *DataDir, logger hclog.Logger,
+	writer io.Writer) (_ *RaftInterface, err error) {
+	// What is opened is closed again, newest first, if starting fails
+	var opened []io.Closer
+	defer func() {
+		if err == nil {
+			return
+		}
+		for i := len(opened) - 1; i >= 0; i-- {
+			opened[i].Close()
+		}
+	}()
+	var configuration *raft.Configuration
+	switch options.Bootstrap {
+	case BootstrapFromConfig, "":
+		configuration = options.Configuration
+		if configuration == nil {
+			configuration, err = BootstrapConfig(options.ConfigFile)
+			if err != nil {
+				return nil, err
+			}
+		}
+	case BootstrapSelf:
+		configuration = &raft.Configuration{Servers: []raft.Server{{Suffrage: raft.Voter,
+			ID: raft.ServerID(options.ServerID), Address: raft.ServerAddress(options.Transport)}}}
+	case BootstrapNone:
+	default:
+		return nil, fmt.Errorf("unknown bootstrap mode %q", options.Bootstrap)
+	}
+	stablestore, err := NewJsonStableStore(options.StableStoreFile)
 	if err != nil {
 		return nil, err
 	}
-	snapshotstore, err := raft.NewFileSnapshotStoreWithLogger(snapshotstoredir, 3, logger)
+	opened = append(opened, stablestore)
+	clusterid := options.ClusterID
+	if clusterid == "" && configuration != nil {
+		clusterid = ConfigurationClusterID(configuration)
+	}
+	if err = checkClusterID(stablestore, clusterid); err != nil {
+		return nil, err
+	}
+	if datadir != nil {
+		if err = datadir.verifyClusterID(clusterid); err != nil {
+			return nil, err
+		}
+	}
+
+	tuning := DefaultTuning()
+	if options.Tuning != nil {
+		tuning = *options.Tuning
+	}
+	if err = tuning.Validate(); err != nil {
+		return nil, err
+	}
+
+	logstore, err := NewJsonLogStore(options.LogStoreFile)
+	if err != nil {
+		return nil, err
+	}
+	opened = append(opened, logstore)
+	logstore.SetCompression(tuning.LogCompression)
+	logstore.SetCodec(tuning.StoreCodec)
+	logstore.SetLimits(tuning.LogSegmentBytes, tuning.LogCacheBytes)
+	stablestore.SetCodec(tuning.StoreCodec)
+	snapshotstore, err := NewJsonSnapshotStore(options.SnapshotDir, tuning.SnapshotRetain, logger)
 	if err != nil {
 		return nil, err
 	}
+	snapshotstore.SetCompression(tuning.SnapshotCompression)
 
 	conf := raft.DefaultConfig()
-	conf.TrailingLogs = 50
-	conf.SnapshotThreshold = 100
-	conf.SnapshotInterval = time.Second * 60
+	tuning.apply(conf)
 	conf.Logger = logger
-	conf.LocalID = raft.ServerID(serverid)
-	tcptransport, err := raft.NewTCPTransport(transport, nil, 10, 10*time.Second, writer)
-	err = raft.BootstrapCluster(conf, logstore,
-		stablestore,
-		snapshotstore, tcptransport, *configuration)
-
-	// Error stating cluster already bootstrapped can be be safely ignored
-	if err != nil && err != raft.ErrCantBootstrap {
-		panic(err)
+	conf.LocalID = raft.ServerID(options.ServerID)
+	conf.BatchApplyCh = true
+	tcptransport, err := raft.NewTCPTransport(options.Transport, nil, tuning.TransportMaxPool,
+		time.Duration(tuning.TransportTimeout), writer)
+	if err != nil {
+		return nil, err
+	}
+	opened = append(opened, tcptransport)
+	if configuration != nil {
+		err = raft.BootstrapCluster(conf, logstore,
+			stablestore,
+			snapshotstore, tcptransport, *configuration)
+
+		// Error stating cluster already bootstrapped can be be safely ignored
+		if err != nil && err != raft.ErrCantBootstrap {
+			return nil, err
+		}
+		// A node bootstrapped by an older version has no cluster id recorded yet
+		if stored, _ := stablestore.Get([]byte(clusterIDKey)); clusterid != "" && len(stored) == 0 {
+			if err = stablestore.Set([]byte(clusterIDKey), []byte(clusterid)); err != nil {
+				return nil, fmt.Errorf("recording the cluster id: %w", err)
+			}
+		}
+		err = nil
+	}
+	if datadir != nil {
+		stored, _ := stablestore.Get([]byte(clusterIDKey))
+		if err = datadir.SetClusterID(string(stored)); err != nil {
+			return nil, err
+		}
 	}
 	fsm, err := NewFsm(logger)
 	if err != nil {
 		return nil, err
 	}
 	raftobj, err := raft.NewRaft(conf, fsm, logstore, stablestore, snapshotstore, tcptransport)
+	if err != nil {
+		return nil, err
+	}
 	raftin := &RaftInterface{}
-	raftin.configfile = configfile
+	raftin.configfile = options.ConfigFile
 	raftin.logstore = logstore
 	raftin.config = conf
 	raftin.stablestore = stablestore
 	raftin.snapshotstore = snapshotstore
 	raftin.fsm = fsm
 	raftin.myid = string(conf.LocalID)
-	raftin.myaddr = transport
+	raftin.myaddr = options.Transport
 	raftin.mytransport = tcptransport
-	raftin.snapshotdir = snapshotstoredir
-	raftin.logstorefile = logstorefile
+	raftin.snapshotdir = options.SnapshotDir
+	raftin.logstorefile = options.LogStoreFile
 	raftin.raftinterface = raftobj
 	raftin.logger = logger
+	raftin.applytimeout = time.Duration(tuning.ApplyTimeout)
+	raftin.datadir = datadir
+	raftin.events = newEventHub(raftobj, logger)
+	raftin.pipeline = newApplyPipeline(raftobj, tuning.ApplyBatchSize,
+		time.Duration(tuning.ApplyBatchLinger), raftin.applytimeout, logger)
 
 	return raftin, nil
 
 }
 
+// ClusterID returns the id of the cluster this node belongs to. It is
+// empty for nodes which have not bootstrapped or joined a cluster yet.
+func (raftin *RaftInterface) ClusterID() string {
+	clusterid, _ := raftin.stablestore.Get([]byte(clusterIDKey))
+	return string(clusterid)
+}
+
+// SetClusterID records the id of the cluster this node joined. It fails
+// if the node already belongs to a different cluster.
+func (raftin *RaftInterface) SetClusterID(clusterid string) error {
+	if clusterid == "" {
+		return nil
+	}
+	if err := checkClusterID(raftin.stablestore, clusterid); err != nil {
+		return err
+	}
+	if raftin.datadir != nil {
+		if err := raftin.datadir.SetClusterID(clusterid); err != nil {
+			return err
+		}
+	}
+	return raftin.stablestore.Set([]byte(clusterIDKey), []byte(clusterid))
+}
+
 // Attempts the get the current leader node
 func (raftin *RaftInterface) Leader() string {
 	server := raftin.raftinterface.Leader()
@@ -144,35 +337,35 @@ func (raftin *RaftInterface) LeaderWithID() (string, string) {
 // Adds a Key value pair. It will return an error if the node
 // serving the request is not the current leader.
 func (raftin *RaftInterface) AddKV(key string, value string) error {
-	cmd := fmt.Sprintf("A:%d:%d:%s%s", len(key), len(value), key, value)
-	future := raftin.raftinterface.Apply([]byte(cmd), 30*time.Second)
-	err := future.Error()
-	if err == raft.ErrNotLeader {
-		err = LeaderDifferent
-	}
-	// No need to wait for fsm response
-	return err
+	return raftin.AddKVAsync(key, value).Error()
+}
+
+// AddKVAsync sends a key value pair to the apply pipeline without waiting
+// for it to be committed
+func (raftin *RaftInterface) AddKVAsync(key string, value string) *WriteFuture {
+	return raftin.write(fmt.Sprintf("A:%d:%d:%s%s", len(key), len(value), key, value))
 }
 
 // Delete deletes a key. It will return an error if the node
 // serving the request is not the current leader.
 func (raftin *RaftInterface) Delete(key string) error {
-	cmd := fmt.Sprintf("D:%s", key)
-	future := raftin.raftinterface.Apply([]byte(cmd), 30*time.Second)
-	err := future.Error()
-	if err != nil {
-		if err == raft.ErrNotLeader {
-			err = LeaderDifferent
-		}
-		return err
-	}
+	return raftin.DeleteAsync(key).Error()
+}
+
+// DeleteAsync sends the deletion of a key to the apply pipeline without
+// waiting for it to be committed. ErrKeyNotFound is returned by the future
+// if the key does not exist.
+func (raftin *RaftInterface) DeleteAsync(key string) *WriteFuture {
+	return raftin.write(fmt.Sprintf("D:%s", key))
+}
 
-	var fsmResponse any
-	fsmResponse = future.Response()
-	if fsmResponse != nil {
-		err = fsmResponse.(error)
+// write sends a client write to the apply pipeline, it stays in flight
+// until it is committed
+func (raftin *RaftInterface) write(command string) *WriteFuture {
+	if err := raftin.beginWrite(); err != nil {
+		return &WriteFuture{err: err}
 	}
-	return err
+	return raftin.pipeline.submit(command, raftin.endWrite)
 }
 
 // Persist triggers a snapshotting, on all nodes
@@ -188,6 +381,83 @@ func (raftin *RaftInterface) Get(key string) (string, error) {
 	return raftin.fsm.Get(key)
 }
 
+// Scan gets the keys with the given prefix from the underlying fsm.
+// At most limit keys are returned, all of them if limit is 0.
+func (raftin *RaftInterface) Scan(prefix string, limit int) map[string]string {
+	return raftin.fsm.Scan(prefix, limit)
+}
+
+// AddVoter adds a server to the cluster as a voter. The http address of the
+// server is replicated first so that every node can redirect clients to it
+// once it becomes the leader.
+func (raftin *RaftInterface) AddVoter(id, address, httpaddress string) error {
+	if err := raftin.SetHttpListener(id, httpaddress); err != nil {
+		return err
+	}
+	future := raftin.raftinterface.AddVoter(raft.ServerID(id), raft.ServerAddress(address), 0,
+		raftin.applytimeout)
+	return leaderError(future.Error())
+}
+
+// AddNonvoter adds a server to the cluster which receives the log entries
+// but does not vote
+func (raftin *RaftInterface) AddNonvoter(id, address, httpaddress string) error {
+	if err := raftin.SetHttpListener(id, httpaddress); err != nil {
+		return err
+	}
+	future := raftin.raftinterface.AddNonvoter(raft.ServerID(id), raft.ServerAddress(address), 0,
+		raftin.applytimeout)
+	return leaderError(future.Error())
+}
+
+// DemoteVoter turns a voter into a nonvoter
+func (raftin *RaftInterface) DemoteVoter(id string) error {
+	future := raftin.raftinterface.DemoteVoter(raft.ServerID(id), 0, raftin.applytimeout)
+	return leaderError(future.Error())
+}
+
+// RemoveServer removes a server from the cluster along with its http address.
+// The address goes first, a leader removing itself steps down and could not
+// apply it afterwards.
+func (raftin *RaftInterface) RemoveServer(id string) error {
+	if _, err := raftin.apply(fmt.Sprintf("R:%s", id)); err != nil {
+		return err
+	}
+	future := raftin.raftinterface.RemoveServer(raft.ServerID(id), 0, raftin.applytimeout)
+	return leaderError(future.Error())
+}
+
+// SetHttpListener replicates the http address of a server
+func (raftin *RaftInterface) SetHttpListener(id, httpaddress string) error {
+	if httpaddress == "" {
+		return nil
+	}
+	_, err := raftin.apply(fmt.Sprintf("H:%d:%d:%s%s", len(id), len(httpaddress), id, httpaddress))
+	return err
+}
+
+// HttpListener gets the replicated http address of a server
+func (raftin *RaftInterface) HttpListener(id string) (string, error) {
+	return raftin.fsm.HttpListener(id)
+}
+
+// apply appends a command to the log and returns the response of the fsm
+func (raftin *RaftInterface) apply(cmd string) (interface{}, error) {
+	future := raftin.raftinterface.Apply([]byte(cmd), raftin.applytimeout)
+	if err := future.Error(); err != nil {
+		return nil, leaderError(err)
+	}
+	return future.Response(), nil
+}
+
+// leaderError maps the error raft returns on a follower to LeaderDifferent
+func leaderError(err error) error {
+	if err == raft.ErrNotLeader {
+		return LeaderDifferent
+	}
+	return err
+}
+
 // Get the current list of servers along with with their ids,
 // And whether a server is leader or not
 func (raftin *RaftInterface) GetServers() ([]Server, error) {
@@ -207,7 +477,51 @@ func (raftin *RaftInterface) GetServers() ([]Server, error) {
 		}
 		thisServer.Address = string(server.Address)
 		thisServer.Id = string(server.ID)
+		thisServer.Suffrage = server.Suffrage.String()
+		thisServer.HttpAddress, _ = raftin.fsm.HttpListener(thisServer.Id)
 		servers = append(servers, thisServer)
 	}
 	return servers, nil
 }
+
+// Shutdown stops the RAFT node, closes its network transport and flushes
+// the stores
+func (raftin *RaftInterface) Shutdown() error {
+	return raftin.GracefulShutdown(context.Background(), false)
+}
+
+// GracefulShutdown stops the node in order. Client writes are refused and
+// the ones in flight are waited for, until ctx is done. The leadership is
+// handed over if transferLeadership is set and the node is the leader.
+// Then the RAFT node is stopped, the transport closed and the stores
+// flushed.
+func (raftin *RaftInterface) GracefulShutdown(ctx context.Context, transferLeadership bool) error {
+	raftin.draining.Store(true)
+	if err := raftin.WaitInflight(ctx); err != nil {
+		raftin.logger.Warn("Shutting down with writes in flight", "inflight", raftin.inflight.Load())
+	}
+	if transferLeadership && raftin.raftinterface.State() == raft.Leader {
+		if err := raftin.LeadershipTransfer(); err != nil {
+			raftin.logger.Error("Leadership transfer failed", "Error", err)
+		}
+	}
+	raftin.pipeline.close()
+	err := raftin.raftinterface.Shutdown().Error()
+	raftin.events.close()
+	if terr := raftin.mytransport.Close(); err == nil {
+		err = terr
+	}
+	if lerr := raftin.logstore.Close(); err == nil {
+		err = lerr
+	}
+	if serr := raftin.stablestore.Close(); err == nil {
+		err = serr
+	}
+	if raftin.datadir != nil {
+		if derr := raftin.datadir.Close(); err == nil {
+			err = derr
+		}
+	}
+	raftin.logger.Info("Shutdown complete", "Error", err)
+	return err
+}
@@ -1,22 +1,70 @@
 package jsonstore
 
 import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io"
+	"time"
+
+	iradix "github.com/hashicorp/go-immutable-radix"
+	metrics "github.com/hashicorp/go-metrics/compat"
 	"github.com/hashicorp/raft"
 )
 
+// Snapshot is a point-in-time view of the FSM. The tree of key-values is
+// immutable, so it is written out while the FSM keeps applying entries.
 type Snapshot struct {
-	data []byte
-}
-
-func NewSnapshot(data []byte) Snapshot {
-	return Snapshot{data: data}
+	keyvals       *iradix.Tree
+	httplisteners map[string]string
 }
 
-func (snapshot Snapshot) Persist(sink raft.SnapshotSink) error {
-	_, err := sink.Write(snapshot.data)
-	return err
+// Persist streams the key-values to the sink one entry at a time, in the
+// layout documented on Fsm
+func (snapshot *Snapshot) Persist(sink raft.SnapshotSink) error {
+	defer metrics.MeasureSince([]string{"fsm", "snapshot", "persist"}, time.Now())
+	buffered := bufio.NewWriter(sink)
+	var size countWriter
+	out := io.MultiWriter(buffered, &size)
+	_, err := out.Write([]byte(`{"keyvals":{`))
+	var entry bytes.Buffer
+	encoder := json.NewEncoder(&entry)
+	encoder.SetEscapeHTML(false)
+	first := true
+	snapshot.keyvals.Root().Walk(func(key []byte, value interface{}) bool {
+		if err != nil {
+			return true
+		}
+		entry.Reset()
+		if !first {
+			entry.WriteByte(',')
+		}
+		first = false
+		// Encode terminates each string with a newline
+		encoder.Encode(string(key))
+		entry.Truncate(entry.Len() - 1)
+		entry.WriteByte(':')
+		encoder.Encode(value.(string))
+		entry.Truncate(entry.Len() - 1)
+		_, err = out.Write(entry.Bytes())
+		return err != nil
+	})
+	if err != nil {
+		return err
+	}
+	listeners, err := json.Marshal(snapshot.httplisteners)
+	if err != nil {
+		return err
+	}
+	out.Write([]byte(`},"httplisteners":`))
+	out.Write(listeners)
+	out.Write([]byte(`}`))
+	if err := buffered.Flush(); err != nil {
+		return err
+	}
+	metrics.SetGauge([]string{"fsm", "snapshot", "size_bytes"}, float32(size))
+	return nil
 }
 
-func (snapshot Snapshot) Release() {
-	snapshot.data = nil
+func (snapshot *Snapshot) Release() {
 }
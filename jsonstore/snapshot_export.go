@@ -0,0 +1,215 @@
+package jsonstore
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	hclog "github.com/hashicorp/go-hclog"
+	"github.com/hashicorp/raft"
+)
+
+var (
+	ErrNoSnapshot      = errors.New("no snapshot")
+	ErrDataDirNotEmpty = errors.New("data directory already has RAFT state")
+	ErrServerNotMember = errors.New("server is not in the configuration")
+	ErrNoVoter         = errors.New("configuration has no voter")
+)
+
+// Stable store key of the current term, the one raft uses
+const currentTermKey = "CurrentTerm"
+
+// Name the imported snapshot file gets while it is verified
+const importID = "import"
+
+// ExportSnapshot opens the file of the newest snapshot of the node, as it is
+// stored, after verifying its checksum. With fresh a snapshot is taken
+// first unless the newest one has every applied entry. The file is a
+// snapshot document which SeedDataDir takes.
+func (raftin *RaftInterface) ExportSnapshot(fresh bool) (*JsonSnapshot, io.ReadCloser, error) {
+	latest, err := raftin.snapshotstore.Latest()
+	if err != nil {
+		return nil, nil, err
+	}
+	if fresh && (latest == nil || latest.Index < raftin.raftinterface.AppliedIndex()) {
+		err := raftin.Persist()
+		if err != nil && !errors.Is(err, raft.ErrNothingNewToSnapshot) {
+			return nil, nil, err
+		}
+		if latest, err = raftin.snapshotstore.Latest(); err != nil {
+			return nil, nil, err
+		}
+	}
+	if latest == nil {
+		return nil, nil, ErrNoSnapshot
+	}
+	return raftin.snapshotstore.OpenFile(latest.ID)
+}
+
+// Settings for seeding a data directory from an exported snapshot
+type SeedOptions struct {
+	// Data directory to seed, it must not have RAFT state yet
+	DataDir string
+
+	// Id of the server owning the data directory
+	ServerID string
+
+	// Servers of the cluster started from the snapshot, replacing the
+	// ones recorded in it. ServerID must be one of them.
+	Configuration raft.Configuration
+
+	// Id of the new cluster, derived from Configuration if empty
+	ClusterID string
+
+	// Compression of the snapshot written to the data directory
+	Compression Compression
+}
+
+// SeedDataDir writes the snapshot read from r into the data directory of a
+// node which has never run, with the membership of options.Configuration.
+// The http addresses of servers which are not members any more are
+// dropped. The node starts from the snapshot like after a restart, nodes
+// seeded from the same snapshot and configuration form the new cluster.
+func SeedDataDir(options SeedOptions, r io.Reader, logger hclog.Logger) (*JsonSnapshot, error) {
+	members := make(map[string]bool)
+	voters := 0
+	for _, server := range options.Configuration.Servers {
+		members[string(server.ID)] = true
+		if server.Suffrage == raft.Voter {
+			voters++
+		}
+	}
+	if voters == 0 {
+		return nil, ErrNoVoter
+	}
+	if !members[options.ServerID] {
+		return nil, fmt.Errorf("%w: %s", ErrServerNotMember, options.ServerID)
+	}
+	if err := options.Compression.Validate(); err != nil {
+		return nil, err
+	}
+	clusterid := options.ClusterID
+	if clusterid == "" {
+		clusterid = ConfigurationClusterID(&options.Configuration)
+	}
+
+	datadir, err := OpenDataDir(options.DataDir, options.ServerID)
+	if err != nil {
+		return nil, err
+	}
+	defer datadir.Close()
+	stablestore, err := datadir.checkEmpty(logger)
+	if err != nil {
+		return nil, err
+	}
+
+	fsm, meta, err := importSnapshot(datadir, r, logger)
+	if err != nil {
+		return nil, err
+	}
+	for id := range fsm.httplisteners {
+		if !members[id] {
+			fsm.removeHttpListener(id)
+		}
+	}
+
+	store, err := NewJsonSnapshotStore(datadir.SnapshotDir(), DefaultTuning().SnapshotRetain, logger)
+	if err != nil {
+		return nil, err
+	}
+	store.SetCompression(options.Compression)
+	sink, err := store.Create(raft.SnapshotVersionMax, meta.Index, meta.Term, options.Configuration,
+		meta.Index, nil)
+	if err != nil {
+		return nil, err
+	}
+	snapshot, _ := fsm.Snapshot()
+	if err := snapshot.Persist(sink); err != nil {
+		sink.Cancel()
+		return nil, err
+	}
+	if err := sink.Close(); err != nil {
+		return nil, err
+	}
+
+	// Elections start from the term of the snapshot
+	if err := stablestore.SetUint64([]byte(currentTermKey), meta.Term); err != nil {
+		return nil, err
+	}
+	if err := stablestore.Set([]byte(clusterIDKey), []byte(clusterid)); err != nil {
+		return nil, err
+	}
+	if err := datadir.SetClusterID(clusterid); err != nil {
+		return nil, err
+	}
+	return store.Latest()
+}
+
+// checkEmpty fails unless the node of the data directory has never run.
+// It returns the stable store.
+func (datadir *DataDir) checkEmpty(logger hclog.Logger) (*JsonStableStore, error) {
+	stablestore, err := NewJsonStableStore(datadir.StableStoreFile())
+	if err != nil {
+		return nil, err
+	}
+	if term, _ := stablestore.GetUint64([]byte(currentTermKey)); term != 0 {
+		return nil, fmt.Errorf("%w: %s has term %d", ErrDataDirNotEmpty, datadir.path, term)
+	}
+	logstore, err := NewJsonLogStore(datadir.LogStoreDir())
+	if err != nil {
+		return nil, err
+	}
+	last, _ := logstore.LastIndex()
+	logstore.Close()
+	if last != 0 {
+		return nil, fmt.Errorf("%w: %s has log entries", ErrDataDirNotEmpty, datadir.path)
+	}
+	store, err := NewJsonSnapshotStore(datadir.SnapshotDir(), 1, logger)
+	if err != nil {
+		return nil, err
+	}
+	if latest, err := store.Latest(); err != nil || latest != nil {
+		if err == nil {
+			err = fmt.Errorf("%w: %s has snapshots", ErrDataDirNotEmpty, datadir.path)
+		}
+		return nil, err
+	}
+	return stablestore, nil
+}
+
+// importSnapshot restores a snapshot document into a new FSM. The document
+// is copied into a scratch store first, where its checksum is verified.
+func importSnapshot(datadir *DataDir, r io.Reader, logger hclog.Logger) (*Fsm, *raft.SnapshotMeta, error) {
+	scratch, err := os.MkdirTemp(datadir.path, "import")
+	if err != nil {
+		return nil, nil, err
+	}
+	defer os.RemoveAll(scratch)
+	store, err := NewJsonSnapshotStore(scratch, 1, logger)
+	if err != nil {
+		return nil, nil, err
+	}
+	// The file is decompressed when read whatever its name
+	file, err := os.Create(filepath.Join(store.dir, importID+jsonSuffix))
+	if err != nil {
+		return nil, nil, err
+	}
+	_, err = io.Copy(file, r)
+	if cerr := file.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	meta, state, err := store.Open(importID)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid snapshot: %w", err)
+	}
+	fsm, _ := NewFsm(logger)
+	if err := fsm.Restore(state); err != nil {
+		return nil, nil, fmt.Errorf("invalid snapshot: %w", err)
+	}
+	return fsm, meta, nil
+}
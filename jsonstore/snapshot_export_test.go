@@ -0,0 +1,65 @@
+package jsonstore
+
+import (
+	"errors"
+	"io"
+	"path/filepath"
+	"testing"
+
+	hclog "github.com/hashicorp/go-hclog"
+	"github.com/hashicorp/raft"
+)
+
+func TestSeedDataDir(t *testing.T) {
+	logger := hclog.NewNullLogger()
+	source, err := NewJsonSnapshotStore(t.TempDir(), 1, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	source.SetCompression(CompressionGzip)
+	id := writeSnapshot(t, source, 10,
+		`{"keyvals": {"a": "1"}, "httplisteners": {"id1": "127.0.0.1:8000", "id2": "127.0.0.1:8001"}}`)
+	_, exported, err := source.OpenFile(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer exported.Close()
+
+	dir := filepath.Join(t.TempDir(), "data")
+	configuration := raft.Configuration{Servers: []raft.Server{
+		{Suffrage: raft.Voter, ID: "id2", Address: "127.0.0.1:9001"}}}
+	options := SeedOptions{DataDir: dir, ServerID: "id2", Configuration: configuration}
+	seeded, err := SeedDataDir(options, exported, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if seeded.Index != 10 || seeded.Term != 2 || len(seeded.Configuration) != 1 ||
+		seeded.Configuration[0].Address != "127.0.0.1:9001" {
+		t.Fatalf("Unexpected snapshot %+v", seeded)
+	}
+
+	store, err := NewJsonSnapshotStore(dir, 1, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, state, err := store.Open(seeded.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ := io.ReadAll(state)
+	if string(data) != `{"keyvals":{"a":"1"},"httplisteners":{"id2":"127.0.0.1:8001"}}` {
+		t.Fatalf("Unexpected state %s", data)
+	}
+	stablestore, _ := NewJsonStableStore(filepath.Join(dir, stableStoreFile))
+	clusterid, _ := stablestore.Get([]byte(clusterIDKey))
+	if string(clusterid) != ConfigurationClusterID(&configuration) {
+		t.Fatalf("Unexpected cluster id %s", clusterid)
+	}
+
+	// A node with state is not overwritten
+	_, exported, _ = source.OpenFile(id)
+	defer exported.Close()
+	if _, err := SeedDataDir(options, exported, logger); !errors.Is(err, ErrDataDirNotEmpty) {
+		t.Fatalf("Expected ErrDataDirNotEmpty, got %v", err)
+	}
+}
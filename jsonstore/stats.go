@@ -0,0 +1,85 @@
+package jsonstore
+
+import (
+	"strconv"
+	"time"
+)
+
+// Stats is the typed form of the statistics reported by RAFT, with the
+// bounds and the size of the log store
+type Stats struct {
+	// RAFT state, Leader, Follower, Candidate or Shutdown
+	State string `json:"state"`
+	Term  uint64 `json:"term"`
+
+	// Time since the last contact with the leader, nil on the leader and
+	// before any contact
+	LastContact *Duration `json:"lastcontact,omitempty"`
+
+	CommitIndex  uint64 `json:"commitindex"`
+	AppliedIndex uint64 `json:"appliedindex"`
+	LastLogIndex uint64 `json:"lastlogindex"`
+	LastLogTerm  uint64 `json:"lastlogterm"`
+
+	// Log entries committed but not yet applied to the FSM
+	FSMPending uint64 `json:"fsmpending"`
+
+	LastSnapshotIndex uint64 `json:"lastsnapshotindex"`
+	LastSnapshotTerm  uint64 `json:"lastsnapshotterm"`
+
+	// Other voters in the latest configuration
+	NumPeers int `json:"numpeers"`
+
+	ProtocolVersion    int `json:"protocolversion"`
+	ProtocolVersionMin int `json:"protocolversionmin"`
+	ProtocolVersionMax int `json:"protocolversionmax"`
+
+	// Index bounds of the entries kept in the log store, the size of its
+	// segment files and their number
+	LogStoreFirstIndex uint64 `json:"logstorefirstindex"`
+	LogStoreLastIndex  uint64 `json:"logstorelastindex"`
+	LogStoreSizeBytes  int64  `json:"logstoresizebytes"`
+	LogStoreSegments   int    `json:"logstoresegments"`
+
+	// Memory used by the log store for the offsets of the entries and its
+	// cache of entries, and the reads served by the cache
+	LogStoreMemoryBytes  int64   `json:"logstorememorybytes"`
+	LogStoreCacheEntries int     `json:"logstorecacheentries"`
+	LogStoreCacheBytes   int64   `json:"logstorecachebytes"`
+	LogStoreCacheHits    uint64  `json:"logstorecachehits"`
+	LogStoreCacheMisses  uint64  `json:"logstorecachemisses"`
+	LogStoreCacheHitRate float64 `json:"logstorecachehitrate"`
+}
+
+// Stats reports the statistics of this node
+func (raftin *RaftInterface) Stats() Stats {
+	raw := raftin.raftinterface.Stats()
+	number := func(name string) uint64 {
+		value, _ := strconv.ParseUint(raw[name], 10, 64)
+		return value
+	}
+	stats := Stats{State: raw["state"], Term: number("term"), CommitIndex: number("commit_index"),
+		AppliedIndex: number("applied_index"), LastLogIndex: number("last_log_index"),
+		LastLogTerm: number("last_log_term"), FSMPending: number("fsm_pending"),
+		LastSnapshotIndex: number("last_snapshot_index"), LastSnapshotTerm: number("last_snapshot_term"),
+		NumPeers: int(number("num_peers")), ProtocolVersion: int(number("protocol_version")),
+		ProtocolVersionMin: int(number("protocol_version_min")),
+		ProtocolVersionMax: int(number("protocol_version_max"))}
+	// "never" before any contact and "0" on the leader
+	if lastcontact, err := time.ParseDuration(raw["last_contact"]); err == nil && lastcontact > 0 {
+		since := Duration(lastcontact)
+		stats.LastContact = &since
+	}
+	stats.LogStoreFirstIndex, _ = raftin.logstore.FirstIndex()
+	stats.LogStoreLastIndex, _ = raftin.logstore.LastIndex()
+	logstats := raftin.logstore.Stats()
+	stats.LogStoreSizeBytes = logstats.DiskBytes
+	stats.LogStoreSegments = logstats.Segments
+	stats.LogStoreMemoryBytes = logstats.MemoryBytes
+	stats.LogStoreCacheEntries = logstats.CacheEntries
+	stats.LogStoreCacheBytes = logstats.CacheBytes
+	stats.LogStoreCacheHits = logstats.CacheHits
+	stats.LogStoreCacheMisses = logstats.CacheMisses
+	stats.LogStoreCacheHitRate = logstats.CacheHitRate
+	return stats
+}
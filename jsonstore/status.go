@@ -0,0 +1,47 @@
+package jsonstore
+
+// Metadata of a snapshot
+type SnapshotInfo struct {
+	ID    string `json:"id"`
+	Index uint64 `json:"index"`
+	Term  uint64 `json:"term"`
+	Size  int64  `json:"size"`
+}
+
+// NodeStatus is the state of a node as shown on the status page
+type NodeStatus struct {
+	ID    string `json:"id"`
+	State string `json:"state"`
+
+	// Id of the leader known to the node
+	Leader string `json:"leader,omitempty"`
+
+	Term         uint64 `json:"term"`
+	CommitIndex  uint64 `json:"commitindex"`
+	AppliedIndex uint64 `json:"appliedindex"`
+	LastLogIndex uint64 `json:"lastlogindex"`
+
+	// Index bounds of the entries kept in the log store
+	LogStoreFirstIndex uint64 `json:"logstorefirstindex"`
+	LogStoreLastIndex  uint64 `json:"logstorelastindex"`
+
+	// Latest snapshot in the snapshot store, nil if there is none
+	LatestSnapshot *SnapshotInfo `json:"latestsnapshot,omitempty"`
+}
+
+// NodeStatus reports the RAFT state, the indexes and the latest snapshot of
+// this node
+func (raftin *RaftInterface) NodeStatus() NodeStatus {
+	stats := raftin.Stats()
+	_, leader := raftin.raftinterface.LeaderWithID()
+	status := NodeStatus{ID: raftin.myid, State: stats.State, Leader: string(leader), Term: stats.Term,
+		CommitIndex: stats.CommitIndex, AppliedIndex: stats.AppliedIndex, LastLogIndex: stats.LastLogIndex,
+		LogStoreFirstIndex: stats.LogStoreFirstIndex, LogStoreLastIndex: stats.LogStoreLastIndex}
+	if snapshots, err := raftin.snapshotstore.List(); err == nil && len(snapshots) > 0 {
+		// The newest snapshot comes first
+		latest := snapshots[0]
+		status.LatestSnapshot = &SnapshotInfo{ID: latest.ID, Index: latest.Index, Term: latest.Term,
+			Size: latest.Size}
+	}
+	return status
+}
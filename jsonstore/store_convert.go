@@ -0,0 +1,90 @@
+package jsonstore
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// ConvertLogStore rewrites the segments of a log store with codec, keeping
+// their compression, and returns the codec it had. The node must be
+// stopped.
+func ConvertLogStore(path string, codec Codec) (Codec, error) {
+	if err := checkConvert(path, codec); err != nil {
+		return "", err
+	}
+	store, err := NewJsonLogStore(path)
+	if err != nil {
+		return "", err
+	}
+	from, err := store.recode(codec)
+	if cerr := store.Close(); err == nil {
+		err = cerr
+	}
+	return from, err
+}
+
+// ConvertStableStore rewrites a stable store file with codec and returns
+// the codec it had. The node must be stopped.
+func ConvertStableStore(path string, codec Codec) (Codec, error) {
+	if err := checkConvert(path, codec); err != nil {
+		return "", err
+	}
+	store, err := NewJsonStableStore(path)
+	if err != nil {
+		return "", err
+	}
+	from := store.codec
+	store.codec = codec
+	data, err := store.encode()
+	if err != nil {
+		return from, err
+	}
+	return from, writeStoreFile(path, data)
+}
+
+// ConvertDataDir converts the log store and the stable store of a data
+// directory. The directory is locked, so it fails while the node runs.
+func ConvertDataDir(path string, codec Codec) (logcodec, stablecodec Codec, err error) {
+	data, err := os.ReadFile(filepath.Join(path, metaFile))
+	if err != nil {
+		return "", "", fmt.Errorf("%s is not a data directory: %w", path, err)
+	}
+	var meta DataDirMeta
+	if err = json.Unmarshal(data, &meta); err != nil {
+		return "", "", fmt.Errorf("invalid %s in %s: %w", metaFile, path, err)
+	}
+	datadir, err := OpenDataDir(path, meta.ServerID)
+	if err != nil {
+		return "", "", err
+	}
+	defer datadir.Close()
+	if logcodec, err = ConvertLogStore(datadir.LogStoreDir(), codec); err != nil {
+		return logcodec, "", err
+	}
+	stablecodec, err = ConvertStableStore(datadir.StableStoreFile(), codec)
+	return logcodec, stablecodec, err
+}
+
+// checkConvert refuses unknown codecs and missing files
+func checkConvert(path string, codec Codec) error {
+	if codec == "" {
+		return fmt.Errorf("no codec given, expected %s or %s", CodecJSON, CodecMsgpack)
+	}
+	if err := codec.Validate(); err != nil {
+		return err
+	}
+	_, err := os.Stat(path)
+	return err
+}
+
+// writeStoreFile replaces a store file through a temporary file, so that
+// a failed conversion leaves the file as it was
+func writeStoreFile(path string, data []byte) error {
+	return WriteFileAtomic(path, func(w io.Writer) error {
+		_, err := w.Write(data)
+		return err
+	})
+}
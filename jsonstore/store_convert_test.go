@@ -0,0 +1,78 @@
+package jsonstore
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/raft"
+)
+
+func TestConvertStores(t *testing.T) {
+	dir := t.TempDir()
+	logfile := filepath.Join(dir, logStoreDir)
+	stablefile := filepath.Join(dir, stableStoreFile)
+
+	// Files written before the header are read as JSON, the log store file
+	// is turned into segments
+	appended := time.Date(2026, 3, 10, 12, 0, 0, 0, time.UTC)
+	legacy := `{"1":{"Index":1,"Term":2,"Type":0,"Data":"QTozOjE6YWJjZA==","Extensions":null,` +
+		`"AppendedAt":"2026-03-10T12:00:00Z"}}`
+	os.WriteFile(logfile, []byte(legacy), 0600)
+	os.WriteFile(stablefile, []byte(`{"CurrentTerm":"2"}`), 0600)
+
+	store, err := NewJsonLogStore(logfile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	store.SetCompression(CompressionGzip)
+	store.StoreLog(&raft.Log{Index: 2, Term: 2, Type: raft.LogCommand, Data: []byte("D:abc"),
+		AppendedAt: appended})
+	if from, err := ConvertLogStore(logfile, CodecMsgpack); err != nil || from != CodecJSON {
+		t.Fatalf("Conversion from %s failed: %v", from, err)
+	}
+	if from, err := ConvertStableStore(stablefile, CodecMsgpack); err != nil || from != CodecJSON {
+		t.Fatalf("Conversion from %s failed: %v", from, err)
+	}
+
+	segment := filepath.Join(logfile, "00000000000000000001.seg")
+	content, _ := os.ReadFile(segment)
+	if !bytes.HasPrefix(content, segmentHeader(CodecMsgpack)) || bytes.Contains(content, []byte("AppendedAt")) {
+		t.Fatalf("Expected a segment of packed entries, got %q", content)
+	}
+	store, err = NewJsonLogStore(logfile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var log raft.Log
+	if err := store.GetLog(1, &log); err != nil || string(log.Data) != "A:3:1:abcd" ||
+		!log.AppendedAt.Equal(appended) {
+		t.Fatalf("Unexpected entry %+v: %v", log, err)
+	}
+	if err := store.GetLog(2, &log); err != nil || string(log.Data) != "D:abc" || log.Term != 2 {
+		t.Fatalf("Unexpected entry %+v: %v", log, err)
+	}
+	stable, err := NewJsonStableStore(stablefile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if term, err := stable.GetUint64([]byte(currentTermKey)); err != nil || term != 2 {
+		t.Fatalf("Unexpected term %d: %v", term, err)
+	}
+
+	// And back to JSON
+	if from, err := ConvertLogStore(logfile, CodecJSON); err != nil || from != CodecMsgpack {
+		t.Fatalf("Conversion from %s failed: %v", from, err)
+	}
+	var out bytes.Buffer
+	file, _ := os.Open(segment)
+	defer file.Close()
+	if err := Inspect(&out, file); err != nil || !bytes.Contains(out.Bytes(), []byte(`"codec": "json"`)) {
+		t.Fatalf("Unexpected inspect output %s: %v", out.String(), err)
+	}
+	if _, err := ConvertLogStore(logfile, "bson"); err == nil {
+		t.Fatal("Expected an unknown codec to be refused")
+	}
+}
@@ -0,0 +1,189 @@
+package jsonstore
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/hashicorp/raft"
+)
+
+// Duration is a time.Duration which is written in JSON as a string like
+// "1s" or "500ms". Plain numbers are read as nanoseconds.
+type Duration time.Duration
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var value interface{}
+	if err := json.Unmarshal(data, &value); err != nil {
+		return err
+	}
+	switch value := value.(type) {
+	case float64:
+		*d = Duration(value)
+	case string:
+		duration, err := time.ParseDuration(value)
+		if err != nil {
+			return err
+		}
+		*d = Duration(duration)
+	default:
+		return fmt.Errorf("invalid duration %s", data)
+	}
+	return nil
+}
+
+// Tuning holds the RAFT and transport settings of a node
+type Tuning struct {
+	// Time without contact with the leader before a follower starts an
+	// election
+	HeartbeatTimeout Duration `json:"heartbeat_timeout"`
+
+	// Time without contact with the leader before a candidate starts an
+	// election
+	ElectionTimeout Duration `json:"election_timeout"`
+
+	// Time without an Apply after which the leader sends a heartbeat to
+	// commit the entries
+	CommitTimeout Duration `json:"commit_timeout"`
+
+	// Time the leader stays the leader without contact with a quorum
+	LeaderLeaseTimeout Duration `json:"leader_lease_timeout"`
+
+	// Log entries kept after a snapshot so that slow followers can catch
+	// up without a snapshot
+	TrailingLogs uint64 `json:"trailing_logs"`
+
+	// Log entries after which a snapshot is taken
+	SnapshotThreshold uint64 `json:"snapshot_threshold"`
+
+	// How often the need for a snapshot is checked
+	SnapshotInterval Duration `json:"snapshot_interval"`
+
+	// Snapshots kept in the snapshot store
+	SnapshotRetain int `json:"snapshot_retain"`
+
+	// Compression of new snapshots and of the log store file, none or gzip.
+	// Files are read whatever they were written with.
+	SnapshotCompression Compression `json:"snapshot_compression"`
+	LogCompression      Compression `json:"log_compression"`
+
+	// Codec of the log store and the stable store files, json or msgpack.
+	// Files are read whatever they were written with.
+	StoreCodec Codec `json:"store_codec"`
+
+	// Size from which the log store starts a new segment file, and the
+	// memory its cache of recent entries may use
+	LogSegmentBytes int64 `json:"log_segment_bytes"`
+	LogCacheBytes   int64 `json:"log_cache_bytes"`
+
+	// Connections kept open per peer by the TCP transport
+	TransportMaxPool int `json:"transport_max_pool"`
+
+	// IO deadline of the TCP transport
+	TransportTimeout Duration `json:"transport_timeout"`
+
+	// Time allowed for a client write or a membership change to be
+	// committed
+	ApplyTimeout Duration `json:"apply_timeout"`
+
+	// Client writes coalesced into one log entry at most, and the time a
+	// write waits for others to join it. 0 applies the writes already
+	// queued without waiting. Older versions cannot apply the coalesced
+	// entries, so the size stays 1 until every node runs this version.
+	ApplyBatchSize   int      `json:"apply_batch_size"`
+	ApplyBatchLinger Duration `json:"apply_batch_linger"`
+}
+
+// DefaultTuning returns the settings used when none are configured
+func DefaultTuning() Tuning {
+	conf := raft.DefaultConfig()
+	return Tuning{
+		HeartbeatTimeout:    Duration(conf.HeartbeatTimeout),
+		ElectionTimeout:     Duration(conf.ElectionTimeout),
+		CommitTimeout:       Duration(conf.CommitTimeout),
+		LeaderLeaseTimeout:  Duration(conf.LeaderLeaseTimeout),
+		TrailingLogs:        50,
+		SnapshotThreshold:   100,
+		SnapshotInterval:    Duration(60 * time.Second),
+		SnapshotRetain:      3,
+		SnapshotCompression: CompressionNone,
+		LogCompression:      CompressionNone,
+		StoreCodec:          CodecJSON,
+		LogSegmentBytes:     DefaultLogSegmentBytes,
+		LogCacheBytes:       DefaultLogCacheBytes,
+		TransportMaxPool:    10,
+		TransportTimeout:    Duration(10 * time.Second),
+		ApplyTimeout:        Duration(30 * time.Second),
+		ApplyBatchSize:      1,
+		ApplyBatchLinger:    Duration(time.Millisecond),
+	}
+}
+
+// LoadTuning reads the settings from a JSON file. Settings missing from
+// the file keep their default values.
+func LoadTuning(tuningfile string) (Tuning, error) {
+	tuning := DefaultTuning()
+	data, err := os.ReadFile(tuningfile)
+	if err != nil {
+		return tuning, err
+	}
+	if err = json.Unmarshal(data, &tuning); err != nil {
+		return tuning, fmt.Errorf("%s: %w", tuningfile, err)
+	}
+	return tuning, tuning.Validate()
+}
+
+// Validate checks the settings, including the checks done by RAFT
+func (tuning Tuning) Validate() error {
+	var errs []error
+	positive := func(name string, value int64) {
+		if value <= 0 {
+			errs = append(errs, fmt.Errorf("%s must be positive, got %s", name, strconv.FormatInt(value, 10)))
+		}
+	}
+	positive("snapshot_retain", int64(tuning.SnapshotRetain))
+	positive("transport_max_pool", int64(tuning.TransportMaxPool))
+	positive("transport_timeout", int64(tuning.TransportTimeout))
+	positive("apply_timeout", int64(tuning.ApplyTimeout))
+	positive("apply_batch_size", int64(tuning.ApplyBatchSize))
+	positive("log_segment_bytes", tuning.LogSegmentBytes)
+	positive("log_cache_bytes", tuning.LogCacheBytes)
+	if tuning.ApplyBatchLinger < 0 || tuning.ApplyBatchLinger >= tuning.ApplyTimeout {
+		errs = append(errs, fmt.Errorf("apply_batch_linger must be below apply_timeout, got %s",
+			time.Duration(tuning.ApplyBatchLinger)))
+	}
+	if err := tuning.SnapshotCompression.Validate(); err != nil {
+		errs = append(errs, fmt.Errorf("snapshot_compression: %w", err))
+	}
+	if err := tuning.LogCompression.Validate(); err != nil {
+		errs = append(errs, fmt.Errorf("log_compression: %w", err))
+	}
+	if err := tuning.StoreCodec.Validate(); err != nil {
+		errs = append(errs, fmt.Errorf("store_codec: %w", err))
+	}
+	conf := raft.DefaultConfig()
+	conf.LocalID = "validate"
+	tuning.apply(conf)
+	if err := raft.ValidateConfig(conf); err != nil {
+		errs = append(errs, err)
+	}
+	return errors.Join(errs...)
+}
+
+// apply copies the RAFT settings to conf
+func (tuning Tuning) apply(conf *raft.Config) {
+	conf.HeartbeatTimeout = time.Duration(tuning.HeartbeatTimeout)
+	conf.ElectionTimeout = time.Duration(tuning.ElectionTimeout)
+	conf.CommitTimeout = time.Duration(tuning.CommitTimeout)
+	conf.LeaderLeaseTimeout = time.Duration(tuning.LeaderLeaseTimeout)
+	conf.TrailingLogs = tuning.TrailingLogs
+	conf.SnapshotThreshold = tuning.SnapshotThreshold
+	conf.SnapshotInterval = time.Duration(tuning.SnapshotInterval)
+}
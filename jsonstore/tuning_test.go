@@ -0,0 +1,55 @@
+package jsonstore
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestLoadTuning(t *testing.T) {
+	tuningfile := filepath.Join(t.TempDir(), "tuning.json")
+	err := os.WriteFile(tuningfile, []byte(`{"heartbeat_timeout": "200ms", "election_timeout": "300ms",
+		"leader_lease_timeout": "100ms", "snapshot_retain": 5, "apply_timeout": 5000000000}`), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tuning, err := LoadTuning(tuningfile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tuning.HeartbeatTimeout != Duration(200*time.Millisecond) || tuning.SnapshotRetain != 5 ||
+		tuning.ApplyTimeout != Duration(5*time.Second) {
+		t.Fatalf("Settings not loaded %+v", tuning)
+	}
+	// Missing settings keep the defaults
+	if tuning.TrailingLogs != DefaultTuning().TrailingLogs {
+		t.Fatal("Default for trailing_logs not kept", tuning.TrailingLogs)
+	}
+}
+
+func TestTuningValidate(t *testing.T) {
+	if err := DefaultTuning().Validate(); err != nil {
+		t.Fatal(err)
+	}
+	tuning := DefaultTuning()
+	tuning.SnapshotRetain = 0
+	if err := tuning.Validate(); err == nil {
+		t.Fatal("Expected snapshot_retain to be refused")
+	}
+	tuning = DefaultTuning()
+	tuning.LeaderLeaseTimeout = tuning.HeartbeatTimeout * 2
+	if err := tuning.Validate(); err == nil {
+		t.Fatal("Expected leader lease above heartbeat timeout to be refused")
+	}
+	tuning = DefaultTuning()
+	tuning.SnapshotCompression = "zip"
+	if err := tuning.Validate(); err == nil {
+		t.Fatal("Expected unknown snapshot_compression to be refused")
+	}
+	tuning = DefaultTuning()
+	tuning.ApplyBatchLinger = tuning.ApplyTimeout
+	if err := tuning.Validate(); err == nil {
+		t.Fatal("Expected apply_batch_linger above apply_timeout to be refused")
+	}
+}
@@ -0,0 +1,437 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"regexp"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/nipuntalukdar/raftdemojson/client"
+	"github.com/nipuntalukdar/raftdemojson/jsonstore"
+)
+
+const kvUsage = `Usage: raftdemojson kv [flags] <command> [arguments]
+
+Commands:
+  put <key> <value> [<key> <value>...]  Add or replace keys
+  get <key>...                          Get the values of keys
+  del <key>...                          Delete keys
+  scan [-limit n] [prefix]              List keys starting with prefix
+  watch [-interval d] <key>...          Print the keys whenever they change
+  members                               List the servers in the cluster
+  leader                                Show the current leader
+  snapshot                              Ask the leader to take a snapshot
+  backup [-fresh] <file>                Save the newest snapshot of the leader for restore, - for stdout
+  export [-prefix p] <file>             Save the keys as JSON Lines for import, - for stdout
+  import [-batch n] [-resume] <file>    Add the key-values of a JSON Lines or JSON array file, - for stdin
+
+Flags:
+`
+
+// Environment variable with the default endpoints for the kv commands
+const endpointsEnv = "RAFTDEMO_ENDPOINTS"
+
+type kvCommand struct {
+	client *client.Client
+	output string
+	in     io.Reader
+	out    io.Writer
+	errout io.Writer
+}
+
+// runKV runs a kv subcommand with the given standard streams and returns
+// the exit code
+func runKV(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	flags := flag.NewFlagSet("kv", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	defaultEndpoints := os.Getenv(endpointsEnv)
+	if defaultEndpoints == "" {
+		defaultEndpoints = "127.0.0.1:8000"
+	}
+	endpoints := flags.String("endpoints", defaultEndpoints,
+		"Comma separated http addresses of the nodes, also read from "+endpointsEnv)
+	output := flags.String("o", "table", "Output format, table or json")
+	timeout := flags.Duration("timeout", 30*time.Second,
+		"Timeout for the commands other than watch, backup, export and import")
+	flags.Usage = func() {
+		fmt.Fprint(flags.Output(), kvUsage)
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if flags.NArg() == 0 || (*output != "table" && *output != "json") {
+		flags.Usage()
+		return 2
+	}
+
+	c, err := client.New(strings.Split(*endpoints, ","))
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return 1
+	}
+	cmd := &kvCommand{client: c, output: *output, in: stdin, out: stdout, errout: stderr}
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer cancel()
+
+	name, cmdargs := flags.Arg(0), flags.Args()[1:]
+	switch name {
+	case "watch", "backup", "export", "import":
+	default:
+		var cancelTimeout context.CancelFunc
+		ctx, cancelTimeout = context.WithTimeout(ctx, *timeout)
+		defer cancelTimeout()
+	}
+	switch name {
+	case "put":
+		err = cmd.put(ctx, cmdargs)
+	case "get":
+		err = cmd.get(ctx, cmdargs)
+	case "del":
+		err = cmd.del(ctx, cmdargs)
+	case "scan":
+		err = cmd.scan(ctx, cmdargs)
+	case "watch":
+		err = cmd.watch(ctx, cmdargs)
+	case "members":
+		err = cmd.members(ctx)
+	case "leader":
+		err = cmd.leader(ctx)
+	case "snapshot":
+		err = cmd.snapshot(ctx)
+	case "backup":
+		err = cmd.backup(ctx, cmdargs)
+	case "export":
+		err = cmd.export(ctx, cmdargs)
+	case "import":
+		err = cmd.importKeys(ctx, cmdargs)
+	default:
+		flags.Usage()
+		return 2
+	}
+	if err != nil {
+		if errors.Is(err, errUsage) {
+			flags.Usage()
+			return 2
+		}
+		fmt.Fprintln(stderr, "Error:", err)
+		return 1
+	}
+	return 0
+}
+
+var errUsage = errors.New("usage")
+
+func (cmd *kvCommand) put(ctx context.Context, args []string) error {
+	if len(args) == 0 || len(args)%2 != 0 {
+		return errUsage
+	}
+	kvs := make(map[string]string)
+	for i := 0; i < len(args); i += 2 {
+		kvs[args[i]] = args[i+1]
+	}
+	if err := cmd.client.PutAll(ctx, kvs); err != nil {
+		return err
+	}
+	return cmd.print(map[string]interface{}{"status": "success", "added": len(kvs)},
+		func(w io.Writer) { fmt.Fprintf(w, "Added %d keys\n", len(kvs)) })
+}
+
+func (cmd *kvCommand) get(ctx context.Context, args []string) error {
+	if len(args) == 0 {
+		return errUsage
+	}
+	found, notfound, err := cmd.client.GetAll(ctx, args)
+	if err != nil {
+		return err
+	}
+	return cmd.printKeys(found, notfound)
+}
+
+func (cmd *kvCommand) del(ctx context.Context, args []string) error {
+	if len(args) == 0 {
+		return errUsage
+	}
+	deleted, notfound, err := cmd.client.DeleteAll(ctx, args)
+	if err != nil {
+		return err
+	}
+	return cmd.print(map[string]interface{}{"deleted": deleted, "notfound": notfound},
+		func(w io.Writer) {
+			fmt.Fprintln(w, "KEY\tSTATUS")
+			for _, key := range deleted {
+				fmt.Fprintf(w, "%s\tdeleted\n", key)
+			}
+			for _, key := range notfound {
+				fmt.Fprintf(w, "%s\tnot found\n", key)
+			}
+		})
+}
+
+func (cmd *kvCommand) scan(ctx context.Context, args []string) error {
+	flags := flag.NewFlagSet("scan", flag.ContinueOnError)
+	limit := flags.Int("limit", 0, "Maximum number of keys, 0 for all")
+	if err := flags.Parse(args); err != nil || flags.NArg() > 1 {
+		return errUsage
+	}
+	found, err := cmd.client.Scan(ctx, flags.Arg(0), *limit)
+	if err != nil {
+		return err
+	}
+	return cmd.printKeys(found, nil)
+}
+
+// watch polls the keys and prints them every time one of them changes
+func (cmd *kvCommand) watch(ctx context.Context, args []string) error {
+	flags := flag.NewFlagSet("watch", flag.ContinueOnError)
+	interval := flags.Duration("interval", time.Second, "Polling interval")
+	if err := flags.Parse(args); err != nil || flags.NArg() == 0 {
+		return errUsage
+	}
+	var last map[string]string
+	for {
+		found, _, err := cmd.client.GetAll(ctx, flags.Args())
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+		for _, key := range flags.Args() {
+			value, exists := found[key]
+			oldvalue, existed := last[key]
+			if last != nil && exists == existed && value == oldvalue {
+				continue
+			}
+			event := map[string]interface{}{"time": time.Now().Format(time.RFC3339),
+				"key": key, "exists": exists}
+			if exists {
+				event["value"] = value
+			}
+			if err := cmd.print(event, func(w io.Writer) {
+				if exists {
+					fmt.Fprintf(w, "%s\t%s\t%s\n", event["time"], key, value)
+				} else {
+					fmt.Fprintf(w, "%s\t%s\t(not found)\n", event["time"], key)
+				}
+			}); err != nil {
+				return err
+			}
+		}
+		last = found
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(*interval):
+		}
+	}
+}
+
+func (cmd *kvCommand) members(ctx context.Context) error {
+	servers, err := cmd.client.Servers(ctx)
+	if err != nil {
+		return err
+	}
+	return cmd.printServers(servers)
+}
+
+func (cmd *kvCommand) leader(ctx context.Context) error {
+	leader, err := cmd.client.Leader(ctx)
+	if err != nil {
+		return err
+	}
+	return cmd.printServers([]client.Server{leader})
+}
+
+func (cmd *kvCommand) snapshot(ctx context.Context) error {
+	if err := cmd.client.Snapshot(ctx); err != nil {
+		return err
+	}
+	return cmd.print(map[string]string{"status": "success"},
+		func(w io.Writer) { fmt.Fprintln(w, "Snapshot taken") })
+}
+
+// backup saves the newest snapshot of the leader, to be restored with the
+// restore command
+func (cmd *kvCommand) backup(ctx context.Context, args []string) error {
+	flags := flag.NewFlagSet("backup", flag.ContinueOnError)
+	fresh := flags.Bool("fresh", false, "Take a snapshot before saving it")
+	if err := flags.Parse(args); err != nil || flags.NArg() != 1 {
+		return errUsage
+	}
+	name := flags.Arg(0)
+	if name == "-" {
+		_, err := cmd.client.ExportSnapshot(ctx, cmd.out, *fresh)
+		return err
+	}
+	var exported string
+	err := jsonstore.WriteFileAtomic(name, func(w io.Writer) (err error) {
+		exported, err = cmd.client.ExportSnapshot(ctx, w, *fresh)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	return cmd.print(map[string]string{"status": "success", "snapshot": exported, "file": name},
+		func(w io.Writer) { fmt.Fprintf(w, "Saved %s to %s\n", exported, name) })
+}
+
+// Beginning of a snapshot document, its metadata starts with the version
+var snapshotStart = regexp.MustCompile(`^\s*\{\s*"version"\s*:`)
+
+// isSnapshot tells whether input holds a snapshot, which is gzip compressed
+// or starts with its version, rather than key-value records
+func isSnapshot(input *bufio.Reader) bool {
+	start, _ := input.Peek(512)
+	return bytes.HasPrefix(start, []byte{0x1f, 0x8b}) || snapshotStart.Match(start)
+}
+
+// Contents of the file keeping the progress of an import, <file>.progress
+type importState struct {
+	Committed int64 `json:"committed"`
+}
+
+// importKeys adds the key-values of a file in batches. When the import
+// fails, the records committed are saved in <file>.progress, and -resume
+// skips them.
+func (cmd *kvCommand) importKeys(ctx context.Context, args []string) error {
+	flags := flag.NewFlagSet("import", flag.ContinueOnError)
+	batch := flags.Int("batch", 0, "Key-values per RAFT entry, the node's default if 0")
+	resume := flags.Bool("resume", false, "Skip the records committed by the last failed import")
+	if err := flags.Parse(args); err != nil || flags.NArg() != 1 {
+		return errUsage
+	}
+	name := flags.Arg(0)
+	input := cmd.in
+	statefile := ""
+	if name != "-" {
+		file, err := os.Open(name)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		input = file
+		statefile = name + ".progress"
+	} else if *resume {
+		return errors.New("-resume needs a file")
+	}
+	buffered := bufio.NewReader(input)
+	if isSnapshot(buffered) {
+		return fmt.Errorf("%s holds a snapshot saved by kv backup, it seeds a node with the restore subcommand",
+			name)
+	}
+	input = buffered
+	var state importState
+	if *resume {
+		data, err := os.ReadFile(statefile)
+		if err == nil {
+			err = json.Unmarshal(data, &state)
+		}
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+
+	var reported time.Time
+	progress, err := cmd.client.Import(ctx, input, client.ImportOptions{Skip: state.Committed, Batch: *batch},
+		func(progress client.ImportProgress) {
+			if time.Since(reported) >= time.Second {
+				reported = time.Now()
+				fmt.Fprintf(cmd.errout, "Committed %d records\n", progress.Committed)
+			}
+		})
+	if err != nil {
+		if statefile == "" {
+			return err
+		}
+		if progress.Committed > state.Committed {
+			data, _ := json.Marshal(importState{Committed: progress.Committed})
+			if werr := os.WriteFile(statefile, data, 0600); werr != nil {
+				return errors.Join(err, werr)
+			}
+		}
+		return fmt.Errorf("%w, run again with -resume to continue after record %d", err,
+			max(progress.Committed, state.Committed))
+	}
+	if statefile != "" {
+		os.Remove(statefile)
+	}
+	return cmd.print(map[string]interface{}{"status": "success", "committed": progress.Committed,
+		"added": progress.Added, "entries": progress.Entries},
+		func(w io.Writer) {
+			fmt.Fprintf(w, "Added %d key-values with %d entries\n", progress.Added, progress.Entries)
+		})
+}
+
+// export saves the keys as JSON Lines, which import reads back
+func (cmd *kvCommand) export(ctx context.Context, args []string) error {
+	flags := flag.NewFlagSet("export", flag.ContinueOnError)
+	prefix := flags.String("prefix", "", "Only the keys starting with prefix")
+	if err := flags.Parse(args); err != nil || flags.NArg() != 1 {
+		return errUsage
+	}
+	name := flags.Arg(0)
+	if name == "-" {
+		return cmd.client.Export(ctx, cmd.out, *prefix)
+	}
+	err := jsonstore.WriteFileAtomic(name, func(w io.Writer) error {
+		return cmd.client.Export(ctx, w, *prefix)
+	})
+	if err != nil {
+		return err
+	}
+	return cmd.print(map[string]string{"status": "success", "file": name},
+		func(w io.Writer) { fmt.Fprintf(w, "Saved the keys to %s\n", name) })
+}
+
+func (cmd *kvCommand) printKeys(found map[string]string, notfound []string) error {
+	return cmd.print(map[string]interface{}{"found": found, "notfound": notfound},
+		func(w io.Writer) {
+			keys := make([]string, 0, len(found))
+			for key := range found {
+				keys = append(keys, key)
+			}
+			sort.Strings(keys)
+			fmt.Fprintln(w, "KEY\tVALUE")
+			for _, key := range keys {
+				fmt.Fprintf(w, "%s\t%s\n", key, found[key])
+			}
+			for _, key := range notfound {
+				fmt.Fprintf(w, "%s\t(not found)\n", key)
+			}
+		})
+}
+
+func (cmd *kvCommand) printServers(servers []client.Server) error {
+	return cmd.print(servers, func(w io.Writer) {
+		fmt.Fprintln(w, "ID\tRAFT ADDRESS\tHTTP ADDRESS\tSUFFRAGE\tLEADER")
+		for _, server := range servers {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%t\n", server.Id, server.Address, server.HttpAddress,
+				server.Suffrage, server.Leader)
+		}
+	})
+}
+
+// print writes value as JSON or calls table to write it as a table,
+// depending on the output format
+func (cmd *kvCommand) print(value interface{}, table func(w io.Writer)) error {
+	if cmd.output == "json" {
+		encoder := json.NewEncoder(cmd.out)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(value)
+	}
+	w := tabwriter.NewWriter(cmd.out, 0, 4, 2, ' ', 0)
+	table(w)
+	return w.Flush()
+}
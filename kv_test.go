@@ -0,0 +1,124 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nipuntalukdar/raftdemojson/client"
+	"github.com/nipuntalukdar/raftdemojson/testcluster"
+)
+
+// kv runs a kv subcommand with stdin and returns its exit code and output
+func kv(t *testing.T, stdin string, args ...string) (int, string, string) {
+	t.Helper()
+	var stdout, stderr bytes.Buffer
+	code := runKV(args, strings.NewReader(stdin), &stdout, &stderr)
+	return code, stdout.String(), stderr.String()
+}
+
+func TestKV(t *testing.T) {
+	cluster := testcluster.New(t, 1)
+	if _, err := cluster.WaitForLeader(10 * time.Second); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv(endpointsEnv, cluster.Endpoints()[0])
+
+	if code, out, errout := kv(t, "", "put", "a", "1", "b", "2", "c", "3"); code != 0 || out != "Added 3 keys\n" {
+		t.Fatalf("put exited with %d: %s%s", code, out, errout)
+	}
+	code, out, _ := kv(t, "", "get", "a", "missing")
+	if code != 0 || strings.Join(strings.Fields(out), " ") != "KEY VALUE a 1 missing (not found)" {
+		t.Fatalf("Unexpected table, exit %d:\n%s", code, out)
+	}
+	var keys struct {
+		Found    map[string]string `json:"found"`
+		NotFound []string          `json:"notfound"`
+	}
+	code, out, _ = kv(t, "", "-o", "json", "get", "a", "missing")
+	if err := json.Unmarshal([]byte(out), &keys); err != nil || code != 0 || keys.Found["a"] != "1" ||
+		len(keys.NotFound) != 1 {
+		t.Fatalf("Unexpected JSON, exit %d: %s", code, out)
+	}
+	code, out, _ = kv(t, "", "-o", "json", "scan", "-limit", "2")
+	if err := json.Unmarshal([]byte(out), &keys); err != nil || code != 0 || len(keys.Found) != 2 {
+		t.Fatalf("Unexpected scan, exit %d: %s", code, out)
+	}
+	var deleted struct {
+		Deleted  []string `json:"deleted"`
+		NotFound []string `json:"notfound"`
+	}
+	code, out, _ = kv(t, "", "-o", "json", "del", "c", "missing")
+	if err := json.Unmarshal([]byte(out), &deleted); err != nil || code != 0 || len(deleted.Deleted) != 1 ||
+		len(deleted.NotFound) != 1 {
+		t.Fatalf("Unexpected delete, exit %d: %s", code, out)
+	}
+	var servers []client.Server
+	code, out, _ = kv(t, "", "-o", "json", "members")
+	if err := json.Unmarshal([]byte(out), &servers); err != nil || code != 0 || len(servers) != 1 ||
+		!servers[0].Leader {
+		t.Fatalf("Unexpected members, exit %d: %s", code, out)
+	}
+	if code, out, _ = kv(t, "", "leader"); code != 0 || !strings.Contains(out, "LEADER") ||
+		!strings.Contains(out, "true") {
+		t.Fatalf("Unexpected leader, exit %d:\n%s", code, out)
+	}
+
+	// -endpoints takes precedence over the environment
+	t.Setenv(endpointsEnv, "127.0.0.1:1")
+	if code, _, errout := kv(t, "", "-timeout", "500ms", "get", "a"); code != 1 || !strings.Contains(errout, "Error:") {
+		t.Fatalf("Expected a failure without a reachable node, exit %d: %s", code, errout)
+	}
+	endpoints := "-endpoints=" + strings.Join(cluster.Endpoints(), ",")
+	if code, _, errout := kv(t, "", endpoints, "get", "a"); code != 0 {
+		t.Fatalf("get exited with %d: %s", code, errout)
+	}
+
+	// Saved files are written whole, stdout gets the data itself
+	dir := t.TempDir()
+	export := filepath.Join(dir, "keys.jsonl")
+	if code, _, errout := kv(t, "", endpoints, "export", export); code != 0 {
+		t.Fatalf("export exited with %d: %s", code, errout)
+	}
+	data, _ := os.ReadFile(export)
+	if code, out, _ = kv(t, "", endpoints, "export", "-"); code != 0 || out != string(data) ||
+		!strings.Contains(out, `"key":"b"`) {
+		t.Fatalf("Unexpected export, exit %d: %s, file %s", code, out, data)
+	}
+	if code, out, errout := kv(t, `{"key": "d", "value": "4"}`+"\n", endpoints, "-o", "json", "import", "-"); code != 0 ||
+		!strings.Contains(out, `"added": 1`) {
+		t.Fatalf("import exited with %d: %s%s", code, out, errout)
+	}
+	snapshot := filepath.Join(dir, "backup.json")
+	if code, out, errout := kv(t, "", endpoints, "backup", "-fresh", snapshot); code != 0 ||
+		!strings.HasPrefix(out, "Saved ") {
+		t.Fatalf("backup exited with %d: %s%s", code, out, errout)
+	}
+	data, _ = os.ReadFile(snapshot)
+	if !bytes.Contains(data, []byte(`"d": "4"`)) {
+		t.Fatalf("Unexpected snapshot %s", data)
+	}
+	// A snapshot is not imported as key-values
+	if code, _, errout := kv(t, string(data), endpoints, "import", "-"); code != 1 ||
+		!strings.Contains(errout, "restore") {
+		t.Fatalf("Expected import to refuse the snapshot, exit %d: %s", code, errout)
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 2 {
+		t.Fatalf("Expected only the saved files, got %v", entries)
+	}
+}
+
+func TestKVUsage(t *testing.T) {
+	t.Setenv(endpointsEnv, "127.0.0.1:1")
+	for _, args := range [][]string{{}, {"-o", "yaml", "get", "a"}, {"frobnicate"}, {"put", "a"},
+		{"get"}, {"del"}, {"scan", "a", "b"}, {"watch"}, {"backup"}, {"import"}, {"export", "a", "b"},
+		{"-nosuchflag", "get", "a"}} {
+		if code, _, errout := kv(t, "", args...); code != 2 || !strings.Contains(errout, "Usage: raftdemojson kv") {
+			t.Errorf("Expected the usage for %v, exit %d: %s", args, code, errout)
+		}
+	}
+}
@@ -1,28 +1,28 @@
 package main
 
 import (
+	"context"
+	"crypto/tls"
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
-	"io"
 	"net/http"
 	"os"
+	"os/signal"
+	"strings"
+	"syscall"
 	"time"
 
 	hclog "github.com/hashicorp/go-hclog"
+	"github.com/nipuntalukdar/raftdemojson/client"
+	"github.com/nipuntalukdar/raftdemojson/httpapi"
 	"github.com/nipuntalukdar/raftdemojson/jsonstore"
+	"github.com/nipuntalukdar/raftdemojson/nodeconfig"
+	"github.com/nipuntalukdar/raftdemojson/telemetry"
 	"github.com/nipuntalukdar/rollingwriter"
 )
 
-type Document struct {
-	Key   string `json:"key"`
-	Value string `json:"value"`
-}
-
-type RequestData struct {
-	Data []Document `json:"data"`
-}
-
 type HttpListerner struct {
 	ID                  string `json:"ID"`
 	HttpListenerAddress string `json:"HttpListenerAddress"`
@@ -32,300 +32,305 @@ type HttpListenerConfig struct {
 	HttpListeners []HttpListerner
 }
 
-type RequestKeys struct {
-	Keys []string `json:"keys"`
-}
+func getHttpListeners(httplisteners string) (*HttpListenerConfig, error) {
 
-type Servers struct {
-	Servers []jsonstore.Server `json:"servers"`
-}
+	data, err := os.ReadFile(httplisteners)
+	if err != nil {
+		return nil, err
+	}
 
-type Response struct {
-	Status     string             `json:"status"`
-	Message    string             `json:"message,omitempty"`
-	DeleteKeys []string           `json:"deleted,omitempty"`
-	NotFound   []string           `json:"notfound,omitempty"`
-	FoundKeys  map[string]string  `json:"found,omitempty"`
-	Servers    []jsonstore.Server `json:"servers,omitempty"`
+	var httpconfig HttpListenerConfig
+	err = json.Unmarshal(data, &httpconfig.HttpListeners)
+	if err != nil {
+		return nil, fmt.Errorf("invalid http listener config %s: %w", httplisteners, err)
+	}
+	return &httpconfig, nil
 }
 
-type kvStore struct {
-	rinf          *jsonstore.RaftInterface
-	logger        hclog.Logger
-	httplisteners map[string]string
+// Settings of this node, from the node config file or from the flags
+type nodeSettings struct {
+	raft               jsonstore.RaftOptions
+	httpaddr           string
+	httplisteners      map[string]string
+	logging            nodeconfig.Logging
+	http               nodeconfig.HTTP
+	join               []string
+	nonvoter           bool
+	shutdowntimeout    time.Duration
+	transferleadership bool
+	backup             jsonstore.BackupConfig
 }
 
-func newAdKVHandler(rinf *jsonstore.RaftInterface, logger hclog.Logger,
-	httplisteners map[string]string) *kvStore {
-	return &kvStore{rinf: rinf, logger: logger, httplisteners: httplisteners}
+func settingsFromConfig(cfg *nodeconfig.Config) *nodeSettings {
+	return &nodeSettings{raft: cfg.RaftOptions(), httpaddr: cfg.HttpAddress,
+		httplisteners: cfg.HttpListeners(), logging: cfg.Logging, http: cfg.HTTP, join: cfg.Join,
+		nonvoter: cfg.Nonvoter, shutdowntimeout: time.Duration(cfg.ShutdownTimeout),
+		transferleadership: cfg.TransferLeadership, backup: cfg.Backup}
 }
 
-func (kv *kvStore) deleteKeys(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodDelete {
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusMethodNotAllowed)
-		json.NewEncoder(w).Encode(Response{Status: "failed", Message: "Method not allowed"})
-		return
-	}
+// Command line flags used when no node config file is given
+type legacyFlags struct {
+	configFile, httpListenerConfigFile, httpAddr, logstoreFile, stablestoreFile *string
+	transport, snapshotDir, serverid, logfileconfig, bootstrap, clusterid       *string
+	join, tuningFile, dataDir                                                   *string
+	nonvoter, transferLeadership                                                *bool
+	shutdownTimeout                                                             *time.Duration
+}
 
-	var req RequestKeys
-	err := json.NewDecoder(r.Body).Decode(&req)
-	if err != nil {
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusBadRequest)
-		json.NewEncoder(w).Encode(Response{Status: "failed", Message: "Bad request body"})
-		return
+func settingsFromFlags(flags legacyFlags) (*nodeSettings, error) {
+	if *flags.serverid == "" {
+		return nil, errors.New("server id must be passed with -serverid")
 	}
-
-	deletedKeys := []string{}
-	notFoundKeys := []string{}
-	baderr := err
-	for _, key := range req.Keys {
-
-		err = kv.rinf.Delete(key)
-		if err != nil {
-			if err == jsonstore.ErrKeyNotFound {
-				notFoundKeys = append(notFoundKeys, key)
-			} else if err == jsonstore.LeaderDifferent {
-				leaderserver, leaderid := kv.rinf.LeaderWithID()
-				kv.logger.Info("Different leader", "leader", leaderserver)
-				if leaderserver != "" {
-					leaderUrl := fmt.Sprintf("http://%s/delete", kv.httplisteners[leaderid])
-					w.Header().Set("Location", leaderUrl)
-					w.WriteHeader(http.StatusPermanentRedirect)
-
-				} else {
-					w.Header().Set("Content-Type", "application/json")
-					w.WriteHeader(http.StatusInternalServerError)
-					json.NewEncoder(w).Encode(Response{Status: "failed", Message: "Leader not found"})
-				}
-				return
-
-			} else {
-				baderr = err
-			}
-		} else {
-			deletedKeys = append(deletedKeys, key)
+	settings := &nodeSettings{httpaddr: *flags.httpAddr, httplisteners: make(map[string]string),
+		nonvoter: *flags.nonvoter, shutdowntimeout: *flags.shutdownTimeout,
+		transferleadership: *flags.transferLeadership}
+
+	httpconfig, err := getHttpListeners(*flags.httpListenerConfigFile)
+	if err == nil {
+		for _, listener := range httpconfig.HttpListeners {
+			settings.httplisteners[listener.ID] = listener.HttpListenerAddress
 		}
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+	if settings.httpaddr == "" {
+		settings.httpaddr = settings.httplisteners[*flags.serverid]
 	}
-	response := Response{}
-	if len(notFoundKeys) > 0 {
-		response.NotFound = notFoundKeys
+	if settings.httpaddr == "" {
+		return nil, errors.New("http address must be passed with -httpaddr or be in the http listener config")
 	}
 
-	if len(deletedKeys) > 0 {
-		response.DeleteKeys = deletedKeys
-		w.WriteHeader(http.StatusOK)
-		response.Status = "success"
-	} else {
-		response.Status = "failed"
-		w.WriteHeader(http.StatusNotFound)
+	settings.logging = nodeconfig.Logging{Level: "debug", File: rollingwriter.NewDefaultConfig()}
+	data, err := os.ReadFile(*flags.logfileconfig)
+	if err != nil {
+		return nil, err
 	}
-	if baderr != nil {
-		response.Status = "failed"
-		w.WriteHeader(http.StatusInternalServerError)
-		response.Message = baderr.Error()
+	if err = json.Unmarshal(data, &settings.logging.File); err != nil {
+		return nil, fmt.Errorf("invalid log file config %s: %w", *flags.logfileconfig, err)
 	}
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(response)
-}
 
-func (kv *kvStore) handlePost(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodPost {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-		return
+	var tuning *jsonstore.Tuning
+	if *flags.tuningFile != "" {
+		loaded, err := jsonstore.LoadTuning(*flags.tuningFile)
+		if err != nil {
+			return nil, fmt.Errorf("invalid tuning: %w", err)
+		}
+		tuning = &loaded
 	}
 
-	var requestData RequestData
-	decoder := json.NewDecoder(r.Body)
-	if err := decoder.Decode(&requestData); err != nil {
-		http.Error(w, "Invalid JSON", http.StatusBadRequest)
-		kv.logger.Error("DecodeError", "Error", err)
-		return
+	bootstrap := jsonstore.BootstrapMode(*flags.bootstrap)
+	if *flags.join != "" {
+		bootstrap = jsonstore.BootstrapNone
+		settings.join = strings.Split(*flags.join, ",")
 	}
-
-	kv.logger.Info("Received keyvals:")
-	for _, doc := range requestData.Data {
-		kv.logger.Debug("Add Data", doc.Key, doc.Value)
-		err := kv.rinf.AddKV(doc.Key, doc.Value)
+	settings.raft = jsonstore.RaftOptions{LogStoreFile: *flags.logstoreFile,
+		StableStoreFile: *flags.stablestoreFile, SnapshotDir: *flags.snapshotDir, DataDir: *flags.dataDir,
+		Transport: *flags.transport, ServerID: *flags.serverid, Bootstrap: bootstrap,
+		ClusterID: *flags.clusterid, Tuning: tuning}
+	if bootstrap == jsonstore.BootstrapFromConfig {
+		configuration, err := jsonstore.BootstrapConfig(*flags.configFile)
 		if err != nil {
-			if err != jsonstore.LeaderDifferent {
-				http.Error(w, "Internal Error", http.StatusInternalServerError)
-				kv.logger.Error("KeyAadd", "Error", err)
-				return
-			} else {
-				leaderserver, leaderid := kv.rinf.LeaderWithID()
-				kv.logger.Info("Different leader", "leader", leaderserver)
-				if leaderserver != "" {
-					leaderUrl := fmt.Sprintf("http://%s/keyvals", kv.httplisteners[leaderid])
-					w.Header().Set("Location", leaderUrl)
-					w.WriteHeader(http.StatusPermanentRedirect)
-
-				} else {
-					http.Error(w, "Internal Error", http.StatusInternalServerError)
-
-				}
-				return
+			return nil, err
+		}
+		found := false
+		for _, server := range configuration.Servers {
+			if string(server.ID) == *flags.serverid {
+				found = true
 			}
 		}
+		if !found {
+			return nil, fmt.Errorf("server id %s is not in %s", *flags.serverid, *flags.configFile)
+		}
+		settings.raft.Configuration = configuration
 	}
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(Response{Status: "success"})
+	return settings, nil
 }
 
-func (kv *kvStore) getServers(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodGet {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-		return
+// joinCluster asks the members to add this node and records the id of the
+// cluster. The node catches up through the log or a snapshot sent by the
+// leader.
+func joinCluster(raftin *jsonstore.RaftInterface, members []string, req client.JoinRequest) error {
+	c, err := client.NewWithConfig(client.Config{Endpoints: members, MaxRetries: 10})
+	if err != nil {
+		return err
 	}
-	kv.logger.Info("Getting servers")
-
-	servers, err := kv.rinf.GetServers()
-
-	w.Header().Set("Content-Type", "application/json")
+	if req.ClusterID == "" {
+		req.ClusterID = raftin.ClusterID()
+	}
+	clusterid, err := c.Join(context.Background(), req)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		json.NewEncoder(w).Encode(Response{Status: "failure"})
-	} else {
-		w.WriteHeader(http.StatusOK)
-		json.NewEncoder(w).Encode(Response{Status: "success", Servers: servers})
+		return err
 	}
+	return raftin.SetClusterID(clusterid)
 }
 
-func (kv *kvStore) testPersist(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodPost {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-		return
+func main() {
+	if len(os.Args) > 1 && os.Args[1] == "kv" {
+		os.Exit(runKV(os.Args[2:], os.Stdin, os.Stdout, os.Stderr))
 	}
-	kv.logger.Info("Persisting snapshot")
-	kv.rinf.Persist()
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(Response{Status: "success"})
-}
-
-func (kv *kvStore) getKeys(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodGet {
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusMethodNotAllowed)
-		json.NewEncoder(w).Encode(Response{Status: "failed", Message: "Method not allowed"})
-		return
+	if len(os.Args) > 1 && os.Args[1] == "inspect" {
+		os.Exit(runInspect(os.Args[2:]))
 	}
-
-	var req RequestKeys
-	err := json.NewDecoder(r.Body).Decode(&req)
-	if err != nil {
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusBadRequest)
-		json.NewEncoder(w).Encode(Response{Status: "failed", Message: "Bad request body"})
-		return
+	if len(os.Args) > 1 && os.Args[1] == "restore" {
+		os.Exit(runRestore(os.Args[2:]))
 	}
-
-	foundkeys := make(map[string]string)
-	notFoundKeys := []string{}
-	baderr := err
-	for _, key := range req.Keys {
-		value, err := kv.rinf.Get(key)
-		if err != nil {
-			if err == jsonstore.ErrKeyNotFound {
-				notFoundKeys = append(notFoundKeys, key)
-			} else {
-				baderr = err
-			}
-		} else {
-			foundkeys[key] = value
-		}
+	if len(os.Args) > 1 && os.Args[1] == "convert" {
+		os.Exit(runConvert(os.Args[2:]))
 	}
-	response := Response{}
-	if len(notFoundKeys) > 0 {
-		response.NotFound = notFoundKeys
+	nodeConfigFile := flag.String("nodeconfig", "",
+		"Path to the node config file, the other flags are ignored when it is given")
+	flags := legacyFlags{
+		configFile: flag.String("config", "sampleconfig/config.json", "Path to configuration file"),
+		httpListenerConfigFile: flag.String("httplistenerconfig", "sampleconfig/http_config.json",
+			"Path to http listener config file, optional once the servers have replicated their http addresses"),
+		httpAddr:        flag.String("httpaddr", "", "Http address to listen on, taken from the http listener config if empty"),
+		logstoreFile:    flag.String("logstore", "log/logstore", "Directory of the logstore segments, a <dir>.json logstore file of older versions is migrated"),
+		stablestoreFile: flag.String("stablestore", "log/stablestore.json", "Path to stablestore file"),
+		transport:       flag.String("transport", "127.0.0.1:7000", "Address to listen on"),
+		snapshotDir:     flag.String("snapshotdir", "/tmp/snapshot", "Directory for snapshots"),
+		dataDir: flag.String("datadir", "",
+			"Directory for all the node state, replaces -logstore, -stablestore and -snapshotdir"),
+		serverid:      flag.String("serverid", "", "Server Id for this server"),
+		logfileconfig: flag.String("logfileconfig", "sampleconfig/logfile_config.json", "logfileconfig"),
+		bootstrap: flag.String("bootstrap", "config",
+			"Bootstrap with all servers in -config (config), only this node as the seed (self) or not at all (none)"),
+		clusterid: flag.String("clusterid", "", "Cluster id, derived from the bootstrap configuration if empty"),
+		join: flag.String("join", "",
+			"Comma separated http addresses of cluster members to join, implies -bootstrap none"),
+		nonvoter: flag.Bool("nonvoter", false, "Join the cluster as a nonvoter"),
+		shutdownTimeout: flag.Duration("shutdowntimeout", 30*time.Second,
+			"Time allowed for finishing the requests in flight on SIGINT or SIGTERM"),
+		transferLeadership: flag.Bool("transferleadership", true,
+			"Hand the leadership over to another server when shutting down"),
+		tuningFile: flag.String("tuning", "", "Path to RAFT tuning file, built-in defaults if empty"),
 	}
 
-	if len(foundkeys) > 0 {
-		response.FoundKeys = foundkeys
-		w.WriteHeader(http.StatusOK)
-		response.Status = "success"
+	flag.Parse()
+	var settings *nodeSettings
+	var cfg *nodeconfig.Config
+	if *nodeConfigFile != "" {
+		var err error
+		cfg, err = nodeconfig.Load(*nodeConfigFile)
+		if err != nil {
+			fmt.Println("Invalid node config:", err)
+			os.Exit(1)
+		}
+		settings = settingsFromConfig(cfg)
 	} else {
-		response.Status = "failed"
-		w.WriteHeader(http.StatusNotFound)
+		var err error
+		settings, err = settingsFromFlags(flags)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
 	}
-	if baderr != nil {
-		response.Status = "failed"
-		w.WriteHeader(http.StatusInternalServerError)
-		response.Message = baderr.Error()
+
+	rollingwr, err := newLogWriter(settings.logging.File)
+	if err != nil {
+		fmt.Println("Failed to open the log file:", err)
+		os.Exit(1)
 	}
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(response)
-}
 
-func getHttpListeners(httplisteners string) (*HttpListenerConfig, error) {
+	logger := hclog.New(&hclog.LoggerOptions{Name: "RaftDemo", Output: rollingwr,
+		Level: hclog.LevelFromString(settings.logging.Level)})
 
-	file, err := os.OpenFile(httplisteners, os.O_RDONLY, 0600)
+	// RAFT emits its metrics to the global sink, set it up first
+	sink, err := telemetry.Setup("raftdemo")
 	if err != nil {
-		return nil, err
+		fmt.Println("Failed to set up metrics:", err)
+		os.Exit(1)
 	}
-	data, err := io.ReadAll(file)
 
-	var httpconfig HttpListenerConfig
-	err = json.Unmarshal(data, &httpconfig.HttpListeners)
+	raftin, err := jsonstore.NewRaftInterfaceWithOptions(settings.raft, logger, rollingwr)
 	if err != nil {
-		return nil, err
+		fmt.Println("Failed to start raft:", err)
+		os.Exit(1)
 	}
-	return &httpconfig, err
-}
-
-func main() {
-	fmt.Println("Make sure that IDs for the http listener config and raft config match ")
-	configFile := flag.String("config", "sampleconfig/config.json", "Path to configuration file")
-	httpListentconfigFile := flag.String("httplistenerconfig", "sampleconfig/http_config.json",
-		"Path to http listener config file")
-	logstoreFile := flag.String("logstore", "log/logstore.json", "Path to logstore file")
-	stablestoreFile := flag.String("stablestore", "log/stablestore.json", "Path to stablestore file")
-	transport := flag.String("transport", "127.0.0.1:7000", "Address to listen on")
-	snapshotDrr := flag.String("snapshotdir", "/tmp/snapshot", "Directory for snapshots")
-	serverid := flag.String("serverid", "", "Server Id for this server")
-	logfileconfig := flag.String("logfileconfig", "sampleconfig/logfile_config.json", "logfileconfig")
-
-	flag.Parse()
-	if *serverid == "" {
-		fmt.Println("Server id must be passed ")
+	serverid := settings.raft.ServerID
+	if len(settings.join) > 0 {
+		err = joinCluster(raftin, settings.join, client.JoinRequest{ID: serverid,
+			Address: settings.raft.Transport, HttpAddress: settings.httpaddr, Nonvoter: settings.nonvoter,
+			ClusterID: settings.raft.ClusterID})
+		if err != nil {
+			fmt.Println("Failed to join the cluster:", err)
+			os.Exit(1)
+		}
+		logger.Info("Joined the cluster", "clusterid", raftin.ClusterID())
+	}
+	time.Sleep(2 * time.Second)
+	raftin.Leader()
+	addkv := httpapi.NewKVStore(raftin, logger, settings.httplisteners)
+	if err := addkv.SetLimits(settings.http.Limits); err != nil {
+		fmt.Println("Invalid http limits:", err)
 		os.Exit(1)
 	}
-
-	httpconfig, err := getHttpListeners(*httpListentconfigFile)
+	backups, err := jsonstore.NewBackups(raftin, settings.backup, logger)
 	if err != nil {
-		panic(err)
+		fmt.Println("Invalid backup settings:", err)
+		os.Exit(1)
 	}
-	http_listeners := make(map[string]string)
-	for _, listener := range httpconfig.HttpListeners {
-		http_listeners[listener.ID] = listener.HttpListenerAddress
+	addkv.SetBackups(backups)
+	backups.Start()
+	addkv.Register(http.DefaultServeMux)
+	http.DefaultServeMux.Handle("/metrics", sink)
+	server := &http.Server{Addr: settings.httpaddr}
+	server.RegisterOnShutdown(addkv.CloseStreams)
+	var certificate *httpapi.Certificate
+	if settings.http.TLSCertFile != "" {
+		certificate, err = httpapi.LoadCertificate(settings.http.TLSCertFile, settings.http.TLSKeyFile)
+		if err != nil {
+			fmt.Println("Failed to load the TLS certificate:", err)
+			os.Exit(1)
+		}
+		server.TLSConfig = &tls.Config{GetCertificate: certificate.GetCertificate}
+		addkv.UseTLS()
 	}
-
-	rollingwr, err := rollingwriter.NewWriterFromConfigFile(*logfileconfig)
-	if err != nil {
-		panic(err)
+	reload := &reloader{configfile: *nodeConfigFile, cfg: cfg, logger: logger, writer: rollingwr,
+		kvstore: addkv, certificate: certificate}
+	if cfg != nil {
+		addkv.SetReloader(reload.Reload)
 	}
 
-	logger := hclog.New(&hclog.LoggerOptions{Name: "RaftDemo", Output: rollingwr,
-		Level: hclog.Debug})
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	go addkv.Announce(ctx, serverid, settings.httpaddr)
 
-	raftin, err := jsonstore.NewRaftInterface(*configFile, *logstoreFile, *stablestoreFile, *snapshotDrr,
-		*transport, *serverid, logger, rollingwr)
+	hangup := make(chan os.Signal, 1)
+	signal.Notify(hangup, syscall.SIGHUP)
+	go func() {
+		for range hangup {
+			if _, err := reload.Reload(); err != nil {
+				logger.Error("Reload failed", "Error", err)
+			}
+		}
+	}()
 
-	if err != nil {
-		panic(err)
+	go func() {
+		var err error
+		if certificate != nil {
+			err = server.ListenAndServeTLS("", "")
+		} else {
+			err = server.ListenAndServe()
+		}
+		if err != nil && err != http.ErrServerClosed {
+			logger.Error("Error listening", "Error", err)
+			stop()
+		}
+	}()
+	logger.Info("Server started", "raft-address", settings.raft.Transport, "http-listener", settings.httpaddr)
+
+	<-ctx.Done()
+	stop()
+	logger.Info("Shutting down", "timeout", settings.shutdowntimeout)
+	shutdownctx, cancel := context.WithTimeout(context.Background(), settings.shutdowntimeout)
+	defer cancel()
+	// Stop accepting requests and wait for the ones being served
+	if err := server.Shutdown(shutdownctx); err != nil {
+		logger.Error("Http server shutdown", "Error", err)
 	}
-	time.Sleep(2 * time.Second)
-	raftin.Leader()
-	addkv := newAdKVHandler(raftin, logger, http_listeners)
-	http.HandleFunc("/keyvals", addkv.handlePost)
-	http.HandleFunc("/delete", addkv.deleteKeys)
-	http.HandleFunc("/testpersist", addkv.testPersist)
-	http.HandleFunc("/getkeys", addkv.getKeys)
-	http.HandleFunc("/servers", addkv.getServers)
-
-	logger.Info("Server started", "raft-address", transport, "http-listener", http_listeners[*serverid])
-	if err := http.ListenAndServe(http_listeners[*serverid], nil); err != nil {
-		logger.Error("Error listening", "Error", err)
+	backups.Stop()
+	if err := raftin.GracefulShutdown(shutdownctx, settings.transferleadership); err != nil {
+		logger.Error("Raft shutdown", "Error", err)
 	}
 
 	rollingwr.Close()
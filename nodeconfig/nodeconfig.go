@@ -0,0 +1,304 @@
+// Package nodeconfig reads the settings of a node from a single JSON file,
+// with overrides from environment variables
+package nodeconfig
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	hclog "github.com/hashicorp/go-hclog"
+	"github.com/hashicorp/raft"
+	"github.com/nipuntalukdar/raftdemojson/httpapi"
+	"github.com/nipuntalukdar/raftdemojson/jsonstore"
+	"github.com/nipuntalukdar/rollingwriter"
+)
+
+// A server of the cluster
+type Peer struct {
+	ID string `json:"id"`
+
+	// Address for RAFT traffic
+	RaftAddress string `json:"raft_address"`
+
+	// Address of the http listener
+	HttpAddress string `json:"http_address"`
+
+	// Bootstrap the server as a nonvoter
+	Nonvoter bool `json:"nonvoter,omitempty"`
+}
+
+// Logging settings of a node
+type Logging struct {
+	// trace, debug, info, warn or error
+	Level string `json:"level"`
+
+	// Settings of the rolling log file
+	File rollingwriter.Config `json:"file"`
+}
+
+// Settings of the http listener
+type HTTP struct {
+	httpapi.Limits
+
+	// Certificate and key of the listener in PEM format, plain http if
+	// empty
+	TLSCertFile string `json:"tls_cert_file,omitempty"`
+	TLSKeyFile  string `json:"tls_key_file,omitempty"`
+}
+
+// Config holds everything needed to start a node
+type Config struct {
+	// Id of this node, it must be one of the peers when bootstrapping
+	// from config
+	ServerID string `json:"server_id"`
+
+	// Directory for the log store, the stable store and the snapshots,
+	// locked while the node runs
+	DataDir string `json:"data_dir"`
+
+	// Address to be used for RAFT traffic
+	RaftAddress string `json:"raft_address"`
+
+	// Address of the http listener
+	HttpAddress string `json:"http_address"`
+
+	// config, self or none
+	Bootstrap jsonstore.BootstrapMode `json:"bootstrap"`
+
+	// Id of the cluster, derived from the peers if empty
+	ClusterID string `json:"cluster_id,omitempty"`
+
+	// Http addresses of cluster members to join, implies bootstrap none
+	Join []string `json:"join,omitempty"`
+
+	// Join the cluster as a nonvoter
+	Nonvoter bool `json:"nonvoter,omitempty"`
+
+	// Servers to bootstrap the cluster with
+	Peers []Peer `json:"peers,omitempty"`
+
+	// RAFT and transport settings
+	Tuning jsonstore.Tuning `json:"tuning"`
+
+	Logging Logging `json:"logging"`
+
+	HTTP HTTP `json:"http"`
+
+	// Time allowed for finishing the requests in flight when shutting down
+	ShutdownTimeout jsonstore.Duration `json:"shutdown_timeout"`
+
+	// Hand the leadership over to another server when shutting down
+	TransferLeadership bool `json:"transfer_leadership"`
+
+	// Backups taken by the leader on a schedule, none if the directory is
+	// empty
+	Backup jsonstore.BackupConfig `json:"backup"`
+}
+
+// Default returns the settings used for anything missing from the file
+func Default() Config {
+	return Config{
+		DataDir:            "data",
+		Bootstrap:          jsonstore.BootstrapFromConfig,
+		Tuning:             jsonstore.DefaultTuning(),
+		Logging:            Logging{Level: "debug", File: rollingwriter.NewDefaultConfig()},
+		ShutdownTimeout:    jsonstore.Duration(30 * time.Second),
+		TransferLeadership: true,
+		Backup:             jsonstore.DefaultBackupConfig(),
+	}
+}
+
+// Environment variables overriding the settings in the file
+var envOverrides = []struct {
+	name string
+	set  func(cfg *Config, value string) error
+}{
+	{"RAFTDEMO_SERVER_ID", func(cfg *Config, value string) error { cfg.ServerID = value; return nil }},
+	{"RAFTDEMO_DATA_DIR", func(cfg *Config, value string) error { cfg.DataDir = value; return nil }},
+	{"RAFTDEMO_RAFT_ADDRESS", func(cfg *Config, value string) error { cfg.RaftAddress = value; return nil }},
+	{"RAFTDEMO_HTTP_ADDRESS", func(cfg *Config, value string) error { cfg.HttpAddress = value; return nil }},
+	{"RAFTDEMO_BOOTSTRAP", func(cfg *Config, value string) error {
+		cfg.Bootstrap = jsonstore.BootstrapMode(value)
+		return nil
+	}},
+	{"RAFTDEMO_CLUSTER_ID", func(cfg *Config, value string) error { cfg.ClusterID = value; return nil }},
+	{"RAFTDEMO_JOIN", func(cfg *Config, value string) error { cfg.Join = strings.Split(value, ","); return nil }},
+	{"RAFTDEMO_NONVOTER", func(cfg *Config, value string) (err error) {
+		cfg.Nonvoter, err = strconv.ParseBool(value)
+		return err
+	}},
+	{"RAFTDEMO_LOG_LEVEL", func(cfg *Config, value string) error { cfg.Logging.Level = value; return nil }},
+	{"RAFTDEMO_LOG_PATH", func(cfg *Config, value string) error { cfg.Logging.File.LogPath = value; return nil }},
+}
+
+// Load reads the config file, applies the environment overrides and
+// validates the result
+func Load(configfile string) (*Config, error) {
+	cfg := Default()
+	data, err := os.ReadFile(configfile)
+	if err != nil {
+		return nil, err
+	}
+	if err = json.Unmarshal(data, &cfg); err != nil {
+		return nil, fmt.Errorf("%s: %w", configfile, err)
+	}
+	for _, env := range envOverrides {
+		if value, ok := os.LookupEnv(env.name); ok {
+			if err = env.set(&cfg, value); err != nil {
+				return nil, fmt.Errorf("%s: %w", env.name, err)
+			}
+		}
+	}
+	if len(cfg.Join) > 0 && cfg.Bootstrap == jsonstore.BootstrapFromConfig {
+		cfg.Bootstrap = jsonstore.BootstrapNone
+	}
+	if err = cfg.Validate(); err != nil {
+		return nil, fmt.Errorf("%s: %w", configfile, err)
+	}
+	return &cfg, nil
+}
+
+// Validate checks the settings and that the directories can be written
+func (cfg *Config) Validate() error {
+	var errs []error
+	required := func(name, value string) {
+		if value == "" {
+			errs = append(errs, fmt.Errorf("%s is missing", name))
+		}
+	}
+	required("server_id", cfg.ServerID)
+	required("data_dir", cfg.DataDir)
+	required("raft_address", cfg.RaftAddress)
+	required("http_address", cfg.HttpAddress)
+
+	switch cfg.Bootstrap {
+	case jsonstore.BootstrapFromConfig, jsonstore.BootstrapSelf, jsonstore.BootstrapNone:
+	default:
+		errs = append(errs, fmt.Errorf("unknown bootstrap mode %q", cfg.Bootstrap))
+	}
+	if len(cfg.Join) > 0 && cfg.Bootstrap != jsonstore.BootstrapNone {
+		errs = append(errs, fmt.Errorf("join needs bootstrap none, got %q", cfg.Bootstrap))
+	}
+
+	ids := make(map[string]bool)
+	addresses := make(map[string]string)
+	var self *Peer
+	for i, peer := range cfg.Peers {
+		if peer.ID == "" {
+			errs = append(errs, fmt.Errorf("peer %d has no id", i))
+			continue
+		}
+		if ids[peer.ID] {
+			errs = append(errs, fmt.Errorf("duplicate peer id %s", peer.ID))
+		}
+		ids[peer.ID] = true
+		if peer.RaftAddress == "" {
+			errs = append(errs, fmt.Errorf("peer %s has no raft_address", peer.ID))
+		} else if other, ok := addresses[peer.RaftAddress]; ok {
+			errs = append(errs, fmt.Errorf("peers %s and %s have the same raft_address %s",
+				other, peer.ID, peer.RaftAddress))
+		} else {
+			addresses[peer.RaftAddress] = peer.ID
+		}
+		if peer.HttpAddress == "" {
+			errs = append(errs, fmt.Errorf("peer %s has no http_address", peer.ID))
+		}
+		if peer.ID == cfg.ServerID {
+			self = &cfg.Peers[i]
+		}
+	}
+	if cfg.Bootstrap == jsonstore.BootstrapFromConfig {
+		if self == nil {
+			errs = append(errs, fmt.Errorf("server_id %s is not one of the peers", cfg.ServerID))
+		}
+	}
+	if self != nil {
+		if self.RaftAddress != cfg.RaftAddress {
+			errs = append(errs, fmt.Errorf("raft_address %s differs from %s of peer %s",
+				cfg.RaftAddress, self.RaftAddress, self.ID))
+		}
+		if self.HttpAddress != cfg.HttpAddress {
+			errs = append(errs, fmt.Errorf("http_address %s differs from %s of peer %s",
+				cfg.HttpAddress, self.HttpAddress, self.ID))
+		}
+	}
+
+	if err := cfg.Tuning.Validate(); err != nil {
+		errs = append(errs, err)
+	}
+	if hclog.LevelFromString(cfg.Logging.Level) == hclog.NoLevel {
+		errs = append(errs, fmt.Errorf("unknown log level %q", cfg.Logging.Level))
+	}
+	if err := cfg.HTTP.Limits.Validate(); err != nil {
+		errs = append(errs, err)
+	}
+	if (cfg.HTTP.TLSCertFile == "") != (cfg.HTTP.TLSKeyFile == "") {
+		errs = append(errs, errors.New("tls_cert_file and tls_key_file must be given together"))
+	} else if cfg.HTTP.TLSCertFile != "" {
+		if _, err := tls.LoadX509KeyPair(cfg.HTTP.TLSCertFile, cfg.HTTP.TLSKeyFile); err != nil {
+			errs = append(errs, fmt.Errorf("tls certificate: %w", err))
+		}
+	}
+	if cfg.ShutdownTimeout <= 0 {
+		errs = append(errs, fmt.Errorf("shutdown_timeout must be positive"))
+	}
+	if err := cfg.Backup.Validate(); err != nil {
+		errs = append(errs, err)
+	}
+
+	if cfg.DataDir != "" {
+		if err := jsonstore.CheckWritable(cfg.DataDir); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if err := jsonstore.CheckWritable(cfg.Logging.File.LogPath); err != nil {
+		errs = append(errs, err)
+	}
+	if cfg.Backup.Enabled() {
+		if err := jsonstore.CheckWritable(cfg.Backup.Dir); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Configuration returns the peers as the RAFT bootstrap configuration
+func (cfg *Config) Configuration() *raft.Configuration {
+	configuration := &raft.Configuration{}
+	for _, peer := range cfg.Peers {
+		suffrage := raft.Voter
+		if peer.Nonvoter {
+			suffrage = raft.Nonvoter
+		}
+		configuration.Servers = append(configuration.Servers, raft.Server{Suffrage: suffrage,
+			ID: raft.ServerID(peer.ID), Address: raft.ServerAddress(peer.RaftAddress)})
+	}
+	return configuration
+}
+
+// RaftOptions returns the settings for starting RAFT
+func (cfg *Config) RaftOptions() jsonstore.RaftOptions {
+	tuning := cfg.Tuning
+	options := jsonstore.RaftOptions{DataDir: cfg.DataDir, Transport: cfg.RaftAddress, ServerID: cfg.ServerID,
+		Bootstrap: cfg.Bootstrap, ClusterID: cfg.ClusterID, Tuning: &tuning}
+	if cfg.Bootstrap == jsonstore.BootstrapFromConfig {
+		options.Configuration = cfg.Configuration()
+	}
+	return options
+}
+
+// HttpListeners maps the ids of the peers to their http addresses
+func (cfg *Config) HttpListeners() map[string]string {
+	listeners := make(map[string]string)
+	for _, peer := range cfg.Peers {
+		listeners[peer.ID] = peer.HttpAddress
+	}
+	listeners[cfg.ServerID] = cfg.HttpAddress
+	return listeners
+}
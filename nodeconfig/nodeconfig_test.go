@@ -0,0 +1,109 @@
+package nodeconfig
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nipuntalukdar/raftdemojson/jsonstore"
+)
+
+func writeConfig(t *testing.T, dir, data string) string {
+	configfile := filepath.Join(dir, "node.json")
+	if err := os.WriteFile(configfile, []byte(data), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return configfile
+}
+
+func TestLoad(t *testing.T) {
+	dir := t.TempDir()
+	configfile := writeConfig(t, dir, `{"server_id": "id2", "data_dir": "`+filepath.Join(dir, "data")+`",
+		"raft_address": "127.0.0.1:7001", "http_address": "127.0.0.1:8001",
+		"peers": [{"id": "id1", "raft_address": "127.0.0.1:7000", "http_address": "127.0.0.1:8000"},
+			{"id": "id2", "raft_address": "127.0.0.1:7001", "http_address": "127.0.0.1:8001"}],
+		"tuning": {"snapshot_retain": 5}, "logging": {"level": "info", "file": {"log_path": "`+
+		filepath.Join(dir, "log")+`"}}}`)
+	t.Setenv("RAFTDEMO_LOG_LEVEL", "warn")
+
+	cfg, err := Load(configfile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Logging.Level != "warn" || cfg.Tuning.SnapshotRetain != 5 ||
+		cfg.Tuning.ApplyTimeout != jsonstore.DefaultTuning().ApplyTimeout ||
+		cfg.ShutdownTimeout != jsonstore.Duration(30*time.Second) {
+		t.Fatalf("Settings not loaded %+v", cfg)
+	}
+	options := cfg.RaftOptions()
+	if options.Configuration == nil || len(options.Configuration.Servers) != 2 ||
+		options.DataDir != filepath.Join(dir, "data") {
+		t.Fatalf("Unexpected raft options %+v", options)
+	}
+	if listeners := cfg.HttpListeners(); listeners["id1"] != "127.0.0.1:8000" {
+		t.Fatal("Http listeners not loaded", listeners)
+	}
+
+	// Joining a cluster implies not bootstrapping
+	t.Setenv("RAFTDEMO_JOIN", "127.0.0.1:8000")
+	if cfg, err = Load(configfile); err != nil || cfg.Bootstrap != jsonstore.BootstrapNone {
+		t.Fatal("Join not applied", cfg, err)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	dir := t.TempDir()
+	readonly := filepath.Join(dir, "readonly")
+	if err := os.Mkdir(readonly, 0500); err != nil {
+		t.Fatal(err)
+	}
+	cfg := Default()
+	cfg.ServerID = "id3"
+	cfg.DataDir = filepath.Join(dir, "data")
+	cfg.RaftAddress = "127.0.0.1:7002"
+	cfg.HttpAddress = "127.0.0.1:8002"
+	cfg.Logging.File.LogPath = filepath.Join(dir, "log")
+	cfg.Peers = []Peer{{ID: "id1", RaftAddress: "127.0.0.1:7000", HttpAddress: "127.0.0.1:8000"},
+		{ID: "id1", RaftAddress: "127.0.0.1:7001"}}
+
+	err := cfg.Validate()
+	if err == nil {
+		t.Fatal("Expected the config to be refused")
+	}
+	for _, expected := range []string{"duplicate peer id id1", "peer id1 has no http_address",
+		"server_id id3 is not one of the peers"} {
+		if !strings.Contains(err.Error(), expected) {
+			t.Errorf("Expected %q in %q", expected, err)
+		}
+	}
+
+	if os.Geteuid() != 0 {
+		cfg.Peers = []Peer{{ID: "id3", RaftAddress: "127.0.0.1:7002", HttpAddress: "127.0.0.1:8002"}}
+		cfg.DataDir = readonly
+		if err := cfg.Validate(); err == nil || !strings.Contains(err.Error(), "not writable") {
+			t.Fatal("Expected the data directory to be refused, got", err)
+		}
+	}
+}
+
+func TestReload(t *testing.T) {
+	cfg := Default()
+	cfg.ServerID = "id1"
+	newcfg := cfg
+	newcfg.ServerID = "id2"
+	newcfg.Logging.Level = "warn"
+	newcfg.HTTP.RateLimit = 100
+	newcfg.HTTP.TLSCertFile = "cert.pem"
+
+	merged, changed, restart := cfg.Reload(&newcfg)
+	if strings.Join(changed, ",") != "logging.level,http.rate_limit" ||
+		strings.Join(restart, ",") != "server_id,http.tls" {
+		t.Fatal("Unexpected changes", changed, restart)
+	}
+	if merged.ServerID != "id1" || merged.Logging.Level != "warn" || merged.HTTP.RateLimit != 100 ||
+		merged.HTTP.TLSCertFile != "" {
+		t.Fatalf("Unexpected merged settings %+v", merged)
+	}
+}
@@ -0,0 +1,60 @@
+package nodeconfig
+
+import "reflect"
+
+// Reload compares the running settings with newcfg. It returns the running
+// settings with the reloadable ones taken from newcfg, the names of the
+// changed reloadable settings and the names of the changed settings which
+// need a restart.
+func (cfg *Config) Reload(newcfg *Config) (merged Config, changed []string, restart []string) {
+	merged = *cfg
+	needsRestart := func(name string, old, new interface{}) {
+		if !reflect.DeepEqual(old, new) {
+			restart = append(restart, name)
+		}
+	}
+	reload := func(name string, old, new interface{}, apply func()) {
+		if !reflect.DeepEqual(old, new) {
+			changed = append(changed, name)
+			apply()
+		}
+	}
+
+	needsRestart("server_id", cfg.ServerID, newcfg.ServerID)
+	needsRestart("data_dir", cfg.DataDir, newcfg.DataDir)
+	needsRestart("raft_address", cfg.RaftAddress, newcfg.RaftAddress)
+	needsRestart("http_address", cfg.HttpAddress, newcfg.HttpAddress)
+	needsRestart("bootstrap", cfg.Bootstrap, newcfg.Bootstrap)
+	needsRestart("cluster_id", cfg.ClusterID, newcfg.ClusterID)
+	needsRestart("join", cfg.Join, newcfg.Join)
+	needsRestart("nonvoter", cfg.Nonvoter, newcfg.Nonvoter)
+	needsRestart("peers", cfg.Peers, newcfg.Peers)
+	needsRestart("tuning", cfg.Tuning, newcfg.Tuning)
+	needsRestart("shutdown_timeout", cfg.ShutdownTimeout, newcfg.ShutdownTimeout)
+	needsRestart("transfer_leadership", cfg.TransferLeadership, newcfg.TransferLeadership)
+	needsRestart("backup", cfg.Backup, newcfg.Backup)
+
+	reload("logging.level", cfg.Logging.Level, newcfg.Logging.Level,
+		func() { merged.Logging.Level = newcfg.Logging.Level })
+	reload("logging.file", cfg.Logging.File, newcfg.Logging.File,
+		func() { merged.Logging.File = newcfg.Logging.File })
+	reload("http.rate_limit", cfg.HTTP.RateLimit, newcfg.HTTP.RateLimit,
+		func() { merged.HTTP.RateLimit = newcfg.HTTP.RateLimit })
+	reload("http.rate_burst", cfg.HTTP.RateBurst, newcfg.HTTP.RateBurst,
+		func() { merged.HTTP.RateBurst = newcfg.HTTP.RateBurst })
+	reload("http.admin_allow", cfg.HTTP.AdminAllow, newcfg.HTTP.AdminAllow,
+		func() { merged.HTTP.AdminAllow = newcfg.HTTP.AdminAllow })
+
+	// Certificates can be replaced, switching between http and https needs
+	// a new listener
+	if (cfg.HTTP.TLSCertFile == "") != (newcfg.HTTP.TLSCertFile == "") {
+		restart = append(restart, "http.tls")
+	} else {
+		reload("http.tls", [2]string{cfg.HTTP.TLSCertFile, cfg.HTTP.TLSKeyFile},
+			[2]string{newcfg.HTTP.TLSCertFile, newcfg.HTTP.TLSKeyFile}, func() {
+				merged.HTTP.TLSCertFile = newcfg.HTTP.TLSCertFile
+				merged.HTTP.TLSKeyFile = newcfg.HTTP.TLSKeyFile
+			})
+	}
+	return merged, changed, restart
+}
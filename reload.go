@@ -0,0 +1,121 @@
+package main
+
+import (
+	"crypto/tls"
+	"errors"
+	"slices"
+	"sync"
+
+	hclog "github.com/hashicorp/go-hclog"
+	"github.com/nipuntalukdar/raftdemojson/httpapi"
+	"github.com/nipuntalukdar/raftdemojson/nodeconfig"
+	"github.com/nipuntalukdar/rollingwriter"
+)
+
+// logWriter writes to a rolling writer which can be replaced while the
+// loggers are using it
+type logWriter struct {
+	lock   sync.RWMutex
+	writer rollingwriter.RollingWriter
+}
+
+func newLogWriter(config rollingwriter.Config) (*logWriter, error) {
+	writer, err := rollingwriter.NewWriterFromConfig(&config)
+	if err != nil {
+		return nil, err
+	}
+	return &logWriter{writer: writer}, nil
+}
+
+func (lw *logWriter) Write(data []byte) (int, error) {
+	lw.lock.RLock()
+	defer lw.lock.RUnlock()
+	return lw.writer.Write(data)
+}
+
+// swap switches to writer and closes the replaced one
+func (lw *logWriter) swap(writer rollingwriter.RollingWriter) error {
+	lw.lock.Lock()
+	old := lw.writer
+	lw.writer = writer
+	lw.lock.Unlock()
+	return old.Close()
+}
+
+func (lw *logWriter) Close() error {
+	lw.lock.Lock()
+	defer lw.lock.Unlock()
+	return lw.writer.Close()
+}
+
+// reloader applies the reloadable settings of the node config file to the
+// running node
+type reloader struct {
+	configfile  string
+	cfg         *nodeconfig.Config
+	logger      hclog.Logger
+	writer      *logWriter
+	kvstore     *httpapi.KVStore
+	certificate *httpapi.Certificate
+	lock        sync.Mutex
+}
+
+// Reload reads the node config file again. The new log file and the
+// certificate are opened before any setting is applied, nothing is applied
+// if the file is not valid or one of them fails.
+func (rl *reloader) Reload() (httpapi.ReloadReport, error) {
+	rl.lock.Lock()
+	defer rl.lock.Unlock()
+	report := httpapi.ReloadReport{Changed: []string{}, RestartRequired: []string{}}
+	if rl.cfg == nil {
+		return report, errors.New("reload needs a node config file given with -nodeconfig")
+	}
+	newcfg, err := nodeconfig.Load(rl.configfile)
+	if err != nil {
+		return report, err
+	}
+	merged, changed, restart := rl.cfg.Reload(newcfg)
+	report.RestartRequired = append(report.RestartRequired, restart...)
+
+	var writer rollingwriter.RollingWriter
+	if slices.Contains(changed, "logging.file") {
+		if writer, err = rollingwriter.NewWriterFromConfig(&merged.Logging.File); err != nil {
+			return report, err
+		}
+	}
+	// The files may have been replaced under the same names
+	var cert *tls.Certificate
+	if rl.certificate != nil && merged.HTTP.TLSCertFile != "" {
+		loaded, err := tls.LoadX509KeyPair(merged.HTTP.TLSCertFile, merged.HTTP.TLSKeyFile)
+		if err != nil {
+			if writer != nil {
+				writer.Close()
+			}
+			return report, err
+		}
+		cert = &loaded
+	}
+
+	for _, name := range changed {
+		switch name {
+		case "logging.level":
+			rl.logger.SetLevel(hclog.LevelFromString(merged.Logging.Level))
+		case "logging.file":
+			if err := rl.writer.swap(writer); err != nil {
+				rl.logger.Warn("Failed to close the previous log file", "Error", err)
+			}
+		case "http.rate_limit", "http.rate_burst", "http.admin_allow":
+			// Checked when the file was loaded
+			if err := rl.kvstore.SetLimits(merged.HTTP.Limits); err != nil {
+				return report, err
+			}
+		}
+		report.Changed = append(report.Changed, name)
+	}
+	if cert != nil && rl.certificate.Store(cert) && !slices.Contains(report.Changed, "http.tls") {
+		report.Changed = append(report.Changed, "http.tls")
+	}
+	rl.cfg = &merged
+	rl.logger.Info("Settings reloaded", "changed", report.Changed, "restart-required", report.RestartRequired)
+	return report, nil
+}
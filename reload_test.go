@@ -0,0 +1,58 @@
+package main
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	hclog "github.com/hashicorp/go-hclog"
+	"github.com/nipuntalukdar/raftdemojson/nodeconfig"
+)
+
+func writeNodeConfig(t *testing.T, configfile, dir, logging string) {
+	t.Helper()
+	data := `{"server_id": "id1", "data_dir": "` + filepath.Join(dir, "data") + `",
+		"raft_address": "127.0.0.1:7000", "http_address": "127.0.0.1:8000", "bootstrap": "self",
+		"logging": ` + logging + `}`
+	if err := os.WriteFile(configfile, []byte(data), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestReloadAppliesNothingOnError(t *testing.T) {
+	dir := t.TempDir()
+	configfile := filepath.Join(dir, "node.json")
+	logpath := filepath.Join(dir, "log")
+	writeNodeConfig(t, configfile, dir, `{"level": "info", "file": {"log_path": "`+logpath+`"}}`)
+	cfg, err := nodeconfig.Load(configfile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writer, err := newLogWriter(cfg.Logging.File)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer writer.Close()
+	logger := hclog.New(&hclog.LoggerOptions{Level: hclog.Info, Output: io.Discard})
+	rl := &reloader{configfile: configfile, cfg: cfg, logger: logger, writer: writer}
+
+	// The level is valid, the rolling pattern of the log file is not
+	writeNodeConfig(t, configfile, dir, `{"level": "warn", "file": {"log_path": "`+logpath+
+		`", "rolling_ploicy": 1, "rolling_time_pattern": "every minute"}}`)
+	if _, err := rl.Reload(); err == nil {
+		t.Fatal("Expected the reload to fail")
+	}
+	if level := logger.GetLevel(); level != hclog.Info {
+		t.Fatal("The log level was applied:", level)
+	}
+
+	writeNodeConfig(t, configfile, dir, `{"level": "warn", "file": {"log_path": "`+logpath+`"}}`)
+	report, err := rl.Reload()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Changed) != 1 || report.Changed[0] != "logging.level" || logger.GetLevel() != hclog.Warn {
+		t.Fatalf("Unexpected reload %+v, level %s", report, logger.GetLevel())
+	}
+}
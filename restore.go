@@ -0,0 +1,83 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	hclog "github.com/hashicorp/go-hclog"
+	"github.com/hashicorp/raft"
+	"github.com/nipuntalukdar/raftdemojson/jsonstore"
+)
+
+const restoreUsage = `Usage: raftdemojson restore [flags] <file>
+
+Seeds the data directory of a node which has never run with a snapshot
+saved by "kv backup", - reads it from stdin. The cluster membership of the
+snapshot is replaced by the servers in -config, or by this server alone.
+Every node of the new cluster is restored from the same snapshot and
+configuration, then started with the same -config, or with -bootstrap self
+for a single node.
+
+Flags:
+`
+
+// runRestore seeds a data directory from a snapshot and returns the exit
+// code
+func runRestore(args []string) int {
+	flags := flag.NewFlagSet("restore", flag.ContinueOnError)
+	dataDir := flags.String("datadir", "", "Data directory of the node, must have no RAFT state")
+	serverid := flags.String("serverid", "", "Server Id for this server")
+	transport := flags.String("transport", "127.0.0.1:7000",
+		"Address to listen on, the membership when -config is empty")
+	configFile := flags.String("config", "", "Path to the configuration file with the servers of the new cluster")
+	clusterid := flags.String("clusterid", "", "Cluster id, derived from the configuration if empty")
+	compression := flags.String("compression", string(jsonstore.CompressionNone),
+		"Compression of the restored snapshot, none or gzip")
+	flags.Usage = func() {
+		fmt.Fprint(flags.Output(), restoreUsage)
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if flags.NArg() != 1 || *dataDir == "" || *serverid == "" {
+		flags.Usage()
+		return 2
+	}
+
+	configuration := &raft.Configuration{Servers: []raft.Server{{Suffrage: raft.Voter,
+		ID: raft.ServerID(*serverid), Address: raft.ServerAddress(*transport)}}}
+	if *configFile != "" {
+		var err error
+		configuration, err = jsonstore.BootstrapConfig(*configFile)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+	}
+
+	var input io.Reader = os.Stdin
+	if name := flags.Arg(0); name != "-" {
+		file, err := os.Open(name)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		defer file.Close()
+		input = file
+	}
+
+	logger := hclog.New(&hclog.LoggerOptions{Name: "restore", Output: os.Stderr, Level: hclog.Warn})
+	snapshot, err := jsonstore.SeedDataDir(jsonstore.SeedOptions{DataDir: *dataDir, ServerID: *serverid,
+		Configuration: *configuration, ClusterID: *clusterid,
+		Compression: jsonstore.Compression(*compression)}, input, logger)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Restore failed:", err)
+		return 1
+	}
+	fmt.Printf("Restored snapshot %s at index %d, term %d into %s\n", snapshot.ID, snapshot.Index,
+		snapshot.Term, *dataDir)
+	return 0
+}
@@ -0,0 +1,58 @@
+package telemetry
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	metrics "github.com/hashicorp/go-metrics/compat"
+)
+
+// Instrument counts the requests to endpoint by status code, measures their
+// latency and counts the redirects to the leader
+func Instrument(endpoint string, handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		sw := &statusWriter{ResponseWriter: w, code: http.StatusOK}
+		handler(sw, r)
+		labels := []metrics.Label{{Name: "endpoint", Value: endpoint}}
+		metrics.MeasureSinceWithLabels([]string{"http", "request"}, start, labels)
+		metrics.IncrCounterWithLabels([]string{"http", "requests"}, 1,
+			append(labels, metrics.Label{Name: "code", Value: strconv.Itoa(sw.code)}))
+		if sw.code >= 300 && sw.code < 400 {
+			metrics.IncrCounterWithLabels([]string{"http", "redirects"}, 1, labels)
+		}
+	}
+}
+
+// statusWriter records the status code of a response
+type statusWriter struct {
+	http.ResponseWriter
+	code        int
+	wroteHeader bool
+}
+
+func (sw *statusWriter) WriteHeader(code int) {
+	if !sw.wroteHeader {
+		sw.code = code
+		sw.wroteHeader = true
+	}
+	sw.ResponseWriter.WriteHeader(code)
+}
+
+func (sw *statusWriter) Write(data []byte) (int, error) {
+	sw.wroteHeader = true
+	return sw.ResponseWriter.Write(data)
+}
+
+// Flush lets streaming handlers flush through the recorder
+func (sw *statusWriter) Flush() {
+	if flusher, ok := sw.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Unwrap gives http.ResponseController access to the underlying writer
+func (sw *statusWriter) Unwrap() http.ResponseWriter {
+	return sw.ResponseWriter
+}
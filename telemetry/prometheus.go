@@ -0,0 +1,197 @@
+// Package telemetry collects the metrics emitted through go-metrics, by
+// RAFT and by this application, and serves them in the Prometheus text
+// format
+package telemetry
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+
+	metrics "github.com/hashicorp/go-metrics/compat"
+)
+
+// Kinds of series, named as in the Prometheus text format
+const (
+	kindGauge   = "gauge"
+	kindCounter = "counter"
+	kindSummary = "summary"
+)
+
+// A metric with one set of label values
+type series struct {
+	labels string
+	value  float64
+	count  uint64
+}
+
+// A metric with all its series
+type family struct {
+	kind   string
+	series map[string]*series
+}
+
+// PrometheusSink is a go-metrics sink keeping the last value of gauges, the
+// total of counters and the count and sum of samples. Samples are timings
+// in milliseconds.
+type PrometheusSink struct {
+	lock     sync.Mutex
+	families map[string]*family
+}
+
+func NewPrometheusSink() *PrometheusSink {
+	return &PrometheusSink{families: make(map[string]*family)}
+}
+
+// Setup makes sink the global go-metrics sink, which RAFT also uses
+func Setup(serviceName string) (*PrometheusSink, error) {
+	sink := NewPrometheusSink()
+	conf := metrics.DefaultConfig(serviceName)
+	conf.EnableHostname = false
+	conf.EnableHostnameLabel = false
+	if _, err := metrics.NewGlobal(conf, sink); err != nil {
+		return nil, err
+	}
+	return sink, nil
+}
+
+func (sink *PrometheusSink) SetGauge(key []string, val float32) {
+	sink.SetGaugeWithLabels(key, val, nil)
+}
+
+func (sink *PrometheusSink) SetGaugeWithLabels(key []string, val float32, labels []metrics.Label) {
+	sink.update(kindGauge, key, labels, func(s *series) { s.value = float64(val) })
+}
+
+func (sink *PrometheusSink) EmitKey(key []string, val float32) {
+	sink.SetGaugeWithLabels(key, val, nil)
+}
+
+func (sink *PrometheusSink) IncrCounter(key []string, val float32) {
+	sink.IncrCounterWithLabels(key, val, nil)
+}
+
+func (sink *PrometheusSink) IncrCounterWithLabels(key []string, val float32, labels []metrics.Label) {
+	sink.update(kindCounter, key, labels, func(s *series) { s.value += float64(val) })
+}
+
+func (sink *PrometheusSink) AddSample(key []string, val float32) {
+	sink.AddSampleWithLabels(key, val, nil)
+}
+
+func (sink *PrometheusSink) AddSampleWithLabels(key []string, val float32, labels []metrics.Label) {
+	sink.update(kindSummary, key, labels, func(s *series) {
+		s.value += float64(val)
+		s.count++
+	})
+}
+
+func (sink *PrometheusSink) update(kind string, key []string, labels []metrics.Label, apply func(s *series)) {
+	name := metricName(key)
+	labeltext := labelText(labels)
+	sink.lock.Lock()
+	defer sink.lock.Unlock()
+	f, ok := sink.families[name]
+	if !ok {
+		f = &family{kind: kind, series: make(map[string]*series)}
+		sink.families[name] = f
+	} else if f.kind != kind {
+		// The same key used as another kind, keep the first one
+		return
+	}
+	s, ok := f.series[labeltext]
+	if !ok {
+		s = &series{labels: labeltext}
+		f.series[labeltext] = s
+	}
+	apply(s)
+}
+
+// WriteTo writes all the metrics in the Prometheus text format
+func (sink *PrometheusSink) WriteTo(w io.Writer) (int64, error) {
+	sink.lock.Lock()
+	defer sink.lock.Unlock()
+	names := make([]string, 0, len(sink.families))
+	for name := range sink.families {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	out := &countingWriter{w: bufio.NewWriter(w)}
+	for _, name := range names {
+		f := sink.families[name]
+		fmt.Fprintf(out, "# TYPE %s %s\n", name, f.kind)
+		labels := make([]string, 0, len(f.series))
+		for labeltext := range f.series {
+			labels = append(labels, labeltext)
+		}
+		sort.Strings(labels)
+		for _, labeltext := range labels {
+			s := f.series[labeltext]
+			if f.kind == kindSummary {
+				fmt.Fprintf(out, "%s_sum%s %g\n", name, braces(s.labels), s.value)
+				fmt.Fprintf(out, "%s_count%s %d\n", name, braces(s.labels), s.count)
+			} else {
+				fmt.Fprintf(out, "%s%s %g\n", name, braces(s.labels), s.value)
+			}
+		}
+	}
+	return out.n, out.w.Flush()
+}
+
+// ServeHTTP serves the metrics for Prometheus to scrape
+func (sink *PrometheusSink) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	sink.WriteTo(w)
+}
+
+type countingWriter struct {
+	w *bufio.Writer
+	n int64
+}
+
+func (cw *countingWriter) Write(data []byte) (int, error) {
+	n, err := cw.w.Write(data)
+	cw.n += int64(n)
+	return n, err
+}
+
+// metricName joins the parts of a key with underscores and replaces the
+// characters Prometheus does not allow
+func metricName(key []string) string {
+	return sanitize(strings.Join(key, "_"))
+}
+
+func sanitize(name string) string {
+	return strings.Map(func(r rune) rune {
+		if (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') || r == '_' || r == ':' {
+			return r
+		}
+		return '_'
+	}, name)
+}
+
+// labelText formats the labels sorted by name, without the braces
+func labelText(labels []metrics.Label) string {
+	if len(labels) == 0 {
+		return ""
+	}
+	pairs := make([]string, 0, len(labels))
+	for _, label := range labels {
+		value := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(label.Value)
+		pairs = append(pairs, fmt.Sprintf(`%s="%s"`, sanitize(label.Name), value))
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
+
+func braces(labels string) string {
+	if labels == "" {
+		return ""
+	}
+	return "{" + labels + "}"
+}
@@ -0,0 +1,35 @@
+package telemetry
+
+import (
+	"strings"
+	"testing"
+
+	metrics "github.com/hashicorp/go-metrics/compat"
+)
+
+func TestPrometheusSink(t *testing.T) {
+	sink := NewPrometheusSink()
+	sink.SetGauge([]string{"raftdemo", "fsm", "keys"}, 3)
+	sink.SetGauge([]string{"raftdemo", "fsm", "keys"}, 5)
+	labels := []metrics.Label{{Name: "endpoint", Value: "/keyvals"}}
+	sink.IncrCounterWithLabels([]string{"raftdemo", "http", "redirects"}, 1, labels)
+	sink.IncrCounterWithLabels([]string{"raftdemo", "http", "redirects"}, 1, labels)
+	sink.AddSampleWithLabels([]string{"raftdemo", "fsm", "apply"}, 1.5, []metrics.Label{{Name: "op", Value: "add"}})
+	sink.AddSampleWithLabels([]string{"raftdemo", "fsm", "apply"}, 0.5, []metrics.Label{{Name: "op", Value: "add"}})
+	sink.SetGauge([]string{"raftdemo", "runtime.alloc-bytes"}, 10)
+
+	var out strings.Builder
+	if _, err := sink.WriteTo(&out); err != nil {
+		t.Fatal(err)
+	}
+	for _, expected := range []string{
+		"# TYPE raftdemo_fsm_keys gauge\nraftdemo_fsm_keys 5\n",
+		"# TYPE raftdemo_http_redirects counter\nraftdemo_http_redirects{endpoint=\"/keyvals\"} 2\n",
+		"raftdemo_fsm_apply_sum{op=\"add\"} 2\nraftdemo_fsm_apply_count{op=\"add\"} 2\n",
+		"raftdemo_runtime_alloc_bytes 10\n",
+	} {
+		if !strings.Contains(out.String(), expected) {
+			t.Errorf("Expected %q in\n%s", expected, out.String())
+		}
+	}
+}
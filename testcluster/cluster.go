@@ -0,0 +1,198 @@
+// Package testcluster runs an in-process raftdemojson cluster for
+// integration tests. Every node gets its own raft transport, JSON stores
+// and http listener serving the regular REST API.
+package testcluster
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	hclog "github.com/hashicorp/go-hclog"
+	"github.com/hashicorp/raft"
+	"github.com/nipuntalukdar/raftdemojson/httpapi"
+	"github.com/nipuntalukdar/raftdemojson/jsonstore"
+)
+
+// Node is a single member of the test cluster
+type Node struct {
+	ID       string
+	RaftAddr string
+	HttpAddr string
+	Raft     *jsonstore.RaftInterface
+
+	listener net.Listener
+	server   *http.Server
+	cancel   context.CancelFunc
+	stopped  bool
+}
+
+// Cluster is a set of nodes bootstrapped together
+type Cluster struct {
+	Nodes []*Node
+	t     testing.TB
+
+	dir        string
+	configfile string
+}
+
+// New starts a cluster of size nodes. The cluster is stopped when the test
+// finishes.
+func New(t testing.TB, size int) *Cluster {
+	t.Helper()
+	cluster := &Cluster{t: t, dir: t.TempDir()}
+	var servers []raft.Server
+	for i := 0; i < size; i++ {
+		node := cluster.newNode()
+		servers = append(servers, raft.Server{Suffrage: raft.Voter, ID: raft.ServerID(node.ID),
+			Address: raft.ServerAddress(node.RaftAddr)})
+	}
+	data, err := json.Marshal(servers)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cluster.configfile = filepath.Join(cluster.dir, "config.json")
+	if err := os.WriteFile(cluster.configfile, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(cluster.Close)
+
+	for _, node := range cluster.Nodes {
+		cluster.start(node, jsonstore.BootstrapFromConfig)
+	}
+	return cluster
+}
+
+// AddNode starts a node which is not a member of the cluster yet and does
+// not bootstrap. It has to be added with one of the membership APIs.
+func (cluster *Cluster) AddNode() *Node {
+	node := cluster.newNode()
+	cluster.start(node, jsonstore.BootstrapNone)
+	return node
+}
+
+func (cluster *Cluster) newNode() *Node {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		cluster.t.Fatal(err)
+	}
+	node := &Node{ID: fmt.Sprintf("id%d", len(cluster.Nodes)+1), RaftAddr: freeAddr(cluster.t),
+		HttpAddr: listener.Addr().String(), listener: listener}
+	cluster.Nodes = append(cluster.Nodes, node)
+	return node
+}
+
+func (cluster *Cluster) start(node *Node, bootstrap jsonstore.BootstrapMode) {
+	t := cluster.t
+	// Short timeouts, the nodes run on the same host
+	tuning := jsonstore.DefaultTuning()
+	tuning.HeartbeatTimeout = jsonstore.Duration(300 * time.Millisecond)
+	tuning.ElectionTimeout = jsonstore.Duration(300 * time.Millisecond)
+	tuning.LeaderLeaseTimeout = jsonstore.Duration(150 * time.Millisecond)
+	tuning.ApplyTimeout = jsonstore.Duration(5 * time.Second)
+	logger := hclog.NewNullLogger()
+	var err error
+	node.Raft, err = jsonstore.NewRaftInterfaceWithOptions(jsonstore.RaftOptions{ConfigFile: cluster.configfile,
+		DataDir: filepath.Join(cluster.dir, node.ID), Transport: node.RaftAddr, ServerID: node.ID,
+		Bootstrap: bootstrap, Tuning: &tuning}, logger, io.Discard)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mux := http.NewServeMux()
+	// No static http config, the nodes learn the addresses through raft
+	kvstore := httpapi.NewKVStore(node.Raft, logger, nil)
+	kvstore.Register(mux)
+	node.server = &http.Server{Handler: mux}
+	go node.server.Serve(node.listener)
+	var ctx context.Context
+	ctx, node.cancel = context.WithCancel(context.Background())
+	go kvstore.Announce(ctx, node.ID, node.HttpAddr)
+}
+
+// Endpoints returns the http addresses of all the nodes
+func (cluster *Cluster) Endpoints() []string {
+	var endpoints []string
+	for _, node := range cluster.Nodes {
+		endpoints = append(endpoints, node.HttpAddr)
+	}
+	return endpoints
+}
+
+// WaitForLeader waits until a running node reports itself as the leader
+// and the running members of the cluster know the http address of the
+// leader
+func (cluster *Cluster) WaitForLeader(timeout time.Duration) (*Node, error) {
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) {
+		for _, node := range cluster.Nodes {
+			if node.stopped {
+				continue
+			}
+			if _, id := node.Raft.LeaderWithID(); id == node.ID && cluster.knowLeader(node) {
+				return node, nil
+			}
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	return nil, fmt.Errorf("no leader elected within %s", timeout)
+}
+
+func (cluster *Cluster) knowLeader(leader *Node) bool {
+	servers, err := leader.Raft.GetServers()
+	if err != nil {
+		return false
+	}
+	members := make(map[string]bool)
+	for _, server := range servers {
+		members[server.Id] = true
+	}
+	for _, node := range cluster.Nodes {
+		if node.stopped || !members[node.ID] {
+			continue
+		}
+		if address, err := node.Raft.HttpListener(leader.ID); err != nil || address != leader.HttpAddr {
+			return false
+		}
+	}
+	return true
+}
+
+// Stop shuts down the raft node and the http listener of node
+func (cluster *Cluster) Stop(node *Node) {
+	if node.stopped {
+		return
+	}
+	node.stopped = true
+	node.cancel()
+	node.server.Close()
+	if err := node.Raft.Shutdown(); err != nil {
+		cluster.t.Log("shutdown", node.ID, err)
+	}
+}
+
+// Close stops all the nodes
+func (cluster *Cluster) Close() {
+	for _, node := range cluster.Nodes {
+		if node.Raft != nil {
+			cluster.Stop(node)
+		} else {
+			node.listener.Close()
+		}
+	}
+}
+
+func freeAddr(t testing.TB) string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	return listener.Addr().String()
+}